@@ -0,0 +1,313 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mavryk-network/mvgo/micheline"
+)
+
+type generator struct {
+	name     string
+	pkg      string
+	source   string
+	prefixFn bool
+	decls    bytes.Buffer
+	names    map[string]int
+	imports  map[string]bool
+}
+
+func newGenerator(name, pkg, source string) *generator {
+	return &generator{
+		name:    exportName(name),
+		pkg:     pkg,
+		source:  source,
+		names:   make(map[string]int),
+		imports: make(map[string]bool),
+	}
+}
+
+// WithPrefix adds the contract name to decode helper names so that bindings
+// for multiple contracts can live in the same package.
+func (g *generator) WithPrefix(b bool) *generator {
+	g.prefixFn = b
+	return g
+}
+
+// Generate emits Go source for the storage type, all entrypoint parameters
+// and named bigmap key/value types of script plus typed helpers.
+func (g *generator) Generate(script *micheline.Script) ([]byte, error) {
+	g.imports["github.com/mavryk-network/mvpro-go/mvpro/index"] = true
+	g.imports["context"] = true
+	g.imports["fmt"] = true
+	g.imports["github.com/mavryk-network/mvgo/mavryk"] = true
+
+	// storage
+	storeType := g.goType(script.StorageType().Typedef(""), g.name+"Storage")
+
+	// entrypoints in stable order
+	eps, err := script.Entrypoints(true)
+	if err != nil {
+		return nil, err
+	}
+	epNames := make([]string, 0, len(eps))
+	for n := range eps {
+		epNames = append(epNames, n)
+	}
+	sort.Strings(epNames)
+	for _, n := range epNames {
+		ep := eps[n]
+		typ := ep.Type()
+		typ.Prim.Anno = nil
+		goName := g.name + exportName(n) + "Params"
+		t := g.goType(typ.Typedef(""), goName)
+		if t != goName {
+			g.typeAlias(goName, t)
+		}
+		g.decodeHelper(n, goName)
+	}
+
+	// bigmaps in stable order
+	bigmaps := script.BigmapTypes()
+	bmNames := make([]string, 0, len(bigmaps))
+	for n := range bigmaps {
+		bmNames = append(bmNames, n)
+	}
+	sort.Strings(bmNames)
+	for _, n := range bmNames {
+		typ := bigmaps[n]
+		keyName := g.name + exportName(n) + "Key"
+		valName := g.name + exportName(n) + "Value"
+		if t := g.goType(typ.Left().Typedef(""), keyName); t != keyName {
+			g.typeAlias(keyName, t)
+		}
+		if t := g.goType(typ.Right().Typedef(""), valName); t != valName {
+			g.typeAlias(valName, t)
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by mvprogen from %s. DO NOT EDIT.\n\n", g.source)
+	fmt.Fprintf(&out, "package %s\n\n", g.pkg)
+	out.WriteString("import (\n")
+	imps := make([]string, 0, len(g.imports))
+	for n := range g.imports {
+		imps = append(imps, n)
+	}
+	sort.Strings(imps)
+	var wasStd bool
+	for i, n := range imps {
+		isStd := !strings.Contains(n, ".")
+		if i > 0 && wasStd && !isStd {
+			out.WriteString("\n")
+		}
+		wasStd = isStd
+		fmt.Fprintf(&out, "\t%q\n", n)
+	}
+	out.WriteString(")\n\n")
+	g.writeContract(&out, storeType, bmNames)
+	out.Write(g.decls.Bytes())
+	return format.Source(out.Bytes())
+}
+
+func (g *generator) writeContract(w *bytes.Buffer, storeType string, bigmaps []string) {
+	fmt.Fprintf(w, "// %s is a typed reader for contract storage and bigmaps.\n", g.name)
+	fmt.Fprintf(w, "type %s struct {\n\tAddress mavryk.Address\n\tapi index.ContractAPI\n\tbigmaps map[string]int64\n}\n\n", g.name)
+	fmt.Fprintf(w, "func New%s(api index.ContractAPI, addr mavryk.Address) *%s {\n", g.name, g.name)
+	fmt.Fprintf(w, "\treturn &%s{Address: addr, api: api}\n}\n\n", g.name)
+
+	fmt.Fprintf(w, "func (c *%s) GetStorage(ctx context.Context) (*%s, error) {\n", g.name, storeType)
+	fmt.Fprintf(w, "\tcv, err := c.api.GetStorage(ctx, c.Address, index.NewQuery())\n")
+	fmt.Fprintf(w, "\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+	fmt.Fprintf(w, "\tvar s %s\n\tif err := cv.Unmarshal(&s); err != nil {\n\t\treturn nil, err\n\t}\n\treturn &s, nil\n}\n\n", storeType)
+
+	if len(bigmaps) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "func (c *%s) bigmapId(ctx context.Context, name string) (int64, error) {\n", g.name)
+	fmt.Fprintf(w, "\tif c.bigmaps == nil {\n")
+	fmt.Fprintf(w, "\t\tcc, err := c.api.Get(ctx, c.Address, index.NewQuery())\n")
+	fmt.Fprintf(w, "\t\tif err != nil {\n\t\t\treturn 0, err\n\t\t}\n")
+	fmt.Fprintf(w, "\t\tc.bigmaps = cc.Bigmaps\n\t}\n")
+	fmt.Fprintf(w, "\tid, ok := c.bigmaps[name]\n")
+	fmt.Fprintf(w, "\tif !ok {\n\t\treturn 0, fmt.Errorf(\"%%s: missing bigmap %%q\", c.Address, name)\n\t}\n")
+	fmt.Fprintf(w, "\treturn id, nil\n}\n\n")
+
+	for _, n := range bigmaps {
+		valName := g.name + exportName(n) + "Value"
+		fmt.Fprintf(w, "// Get%sValue reads a single %s bigmap entry. Key is the key hash or\n", exportName(n), n)
+		fmt.Fprintf(w, "// the key's string representation as accepted by the API.\n")
+		fmt.Fprintf(w, "func (c *%s) Get%sValue(ctx context.Context, key string) (*%s, error) {\n", g.name, exportName(n), valName)
+		fmt.Fprintf(w, "\tid, err := c.bigmapId(ctx, %q)\n\tif err != nil {\n\t\treturn nil, err\n\t}\n", n)
+		fmt.Fprintf(w, "\tbv, err := c.api.GetBigmapValue(ctx, id, key, index.NewQuery())\n")
+		fmt.Fprintf(w, "\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+		fmt.Fprintf(w, "\tvar v %s\n\tif err := bv.Unmarshal(&v); err != nil {\n\t\treturn nil, err\n\t}\n\treturn &v, nil\n}\n\n", valName)
+	}
+}
+
+func (g *generator) decodeHelper(ep, typ string) {
+	fn := "Decode" + exportName(ep) + "Params"
+	if g.prefixFn {
+		fn = "Decode" + g.name + exportName(ep) + "Params"
+	}
+	fmt.Fprintf(&g.decls, "// %s decodes call parameters of entrypoint %s. Contract types\n", fn, ep)
+	fmt.Fprintf(&g.decls, "// must be resolved before, e.g. with OpAPI.ResolveTypes.\n")
+	fmt.Fprintf(&g.decls, "func %s(op *index.Op) (*%s, error) {\n", fn, typ)
+	fmt.Fprintf(&g.decls, "\tif op.Entrypoint != \"\" && op.Entrypoint != %q {\n", ep)
+	fmt.Fprintf(&g.decls, "\t\treturn nil, fmt.Errorf(\"op %%s: unexpected entrypoint %%q\", op.Hash, op.Entrypoint)\n\t}\n")
+	fmt.Fprintf(&g.decls, "\tparams, err := op.DecodeParams(false, 0)\n\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+	fmt.Fprintf(&g.decls, "\tif params.Entrypoint != %q {\n", ep)
+	fmt.Fprintf(&g.decls, "\t\treturn nil, fmt.Errorf(\"op %%s: unexpected entrypoint %%q\", op.Hash, params.Entrypoint)\n\t}\n")
+	fmt.Fprintf(&g.decls, "\tvar v %s\n\tif err := params.Unmarshal(&v); err != nil {\n\t\treturn nil, err\n\t}\n\treturn &v, nil\n}\n\n", typ)
+}
+
+func (g *generator) typeAlias(name, typ string) {
+	fmt.Fprintf(&g.decls, "type %s = %s\n\n", name, typ)
+}
+
+// goType returns the Go type for td and declares named struct types on
+// demand. The JSON tags follow the field names produced by Value.Map.
+func (g *generator) goType(td micheline.Typedef, hint string) string {
+	var typ string
+	switch td.Type {
+	case micheline.TypeStruct:
+		typ = g.declareStruct(td, hint, false)
+	case micheline.TypeUnion:
+		typ = g.declareStruct(td, hint, true)
+	case "list", "set":
+		if len(td.Args) == 0 {
+			typ = "[]any"
+		} else {
+			typ = "[]" + g.goType(td.Args[0], hint+"Item")
+		}
+	case "map":
+		typ = "map[string]" + g.goType(td.Args[1], hint+"Value")
+	case "big_map", "sapling_state":
+		typ = "int64"
+	case "int", "nat", "mumav":
+		g.imports["github.com/mavryk-network/mvgo/mavryk"] = true
+		typ = "mavryk.Z"
+	case "string":
+		typ = "string"
+	case "bool":
+		typ = "bool"
+	case "bytes":
+		g.imports["github.com/mavryk-network/mvgo/mavryk"] = true
+		typ = "mavryk.HexBytes"
+	case "timestamp":
+		g.imports["time"] = true
+		typ = "time.Time"
+	case "address", "key_hash":
+		g.imports["github.com/mavryk-network/mvgo/mavryk"] = true
+		typ = "mavryk.Address"
+	case "key":
+		g.imports["github.com/mavryk-network/mvgo/mavryk"] = true
+		typ = "mavryk.Key"
+	case "signature":
+		g.imports["github.com/mavryk-network/mvgo/mavryk"] = true
+		typ = "mavryk.Signature"
+	case "chain_id":
+		g.imports["github.com/mavryk-network/mvgo/mavryk"] = true
+		typ = "mavryk.ChainIdHash"
+	case "contract":
+		// may carry an entrypoint suffix
+		typ = "string"
+	default:
+		// unit, lambda, operation, ticket, never and other exotic types
+		typ = "any"
+	}
+	if td.Optional && typ != "any" && !strings.HasPrefix(typ, "[]") && !strings.HasPrefix(typ, "map[") {
+		typ = "*" + typ
+	}
+	return typ
+}
+
+func (g *generator) declareStruct(td micheline.Typedef, hint string, isUnion bool) string {
+	name := g.uniqueName(hint)
+	var body bytes.Buffer
+	if isUnion {
+		fmt.Fprintf(&body, "// %s is a union type, exactly one field is set.\n", name)
+	}
+	fmt.Fprintf(&body, "type %s struct {\n", name)
+	used := make(map[string]bool)
+	for _, arg := range td.Args {
+		field := fieldName(arg.Name)
+		for i := 1; used[field]; i++ {
+			field = fieldName(arg.Name) + strconv.Itoa(i)
+		}
+		used[field] = true
+		var ftyp string
+		if isUnion {
+			// unit branches decode as JSON null, RawMessage keeps them distinguishable
+			if arg.Type == "unit" {
+				g.imports["encoding/json"] = true
+				ftyp = "json.RawMessage"
+			} else {
+				ftyp = g.goType(arg, name+field)
+				if !strings.HasPrefix(ftyp, "*") && ftyp != "any" {
+					ftyp = "*" + ftyp
+				}
+			}
+			fmt.Fprintf(&body, "\t%s %s `json:\"%s,omitempty\"`\n", field, ftyp, arg.Name)
+		} else {
+			ftyp = g.goType(arg, name+field)
+			fmt.Fprintf(&body, "\t%s %s `json:\"%s\"`\n", field, ftyp, arg.Name)
+		}
+	}
+	body.WriteString("}\n\n")
+	g.decls.Write(body.Bytes())
+	return name
+}
+
+func (g *generator) uniqueName(n string) string {
+	cnt := g.names[n]
+	g.names[n] = cnt + 1
+	if cnt == 0 {
+		return n
+	}
+	return n + strconv.Itoa(cnt)
+}
+
+func fieldName(s string) string {
+	s = strings.TrimPrefix(s, "@")
+	if s == "" {
+		return "Value"
+	}
+	if unicode.IsDigit(rune(s[0])) {
+		return "Field" + s
+	}
+	return exportName(s)
+}
+
+// exportName converts snake_case and similar identifiers into exported
+// CamelCase Go names.
+func exportName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		switch {
+		case r == '_' || r == '-' || r == '.' || r == '@' || r == '%' || r == ' ':
+			upper = true
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if upper {
+				b.WriteRune(unicode.ToUpper(r))
+				upper = false
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	res := b.String()
+	if res == "" || unicode.IsDigit(rune(res[0])) {
+		res = "X" + res
+	}
+	return res
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+// mvprogen generates typed Go bindings for a smart contract from its script.
+// Bindings contain structs for storage, entrypoint parameters and bigmap
+// keys/values plus helpers to decode call parameters from index.Op and to
+// read storage and bigmap values through the MvPro API.
+//
+// Usage with go generate:
+//
+//	//go:generate go run github.com/mavryk-network/mvpro-go/cmd/mvprogen -name Token -pkg token -address KT1... -out token_gen.go
+//	//go:generate go run github.com/mavryk-network/mvpro-go/cmd/mvprogen -name Token -pkg token -src script.json -out token_gen.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvgo/micheline"
+	"github.com/mavryk-network/mvpro-go/mvpro"
+)
+
+var (
+	flags   = flag.NewFlagSet("mvprogen", flag.ContinueOnError)
+	api     string
+	address string
+	src     string
+	name    string
+	pkg     string
+	out     string
+	prefix  bool
+)
+
+func init() {
+	flags.Usage = func() {}
+	flags.StringVar(&api, "api", "https://api.mvpro.io", "MvPro API url")
+	flags.StringVar(&address, "address", "", "load script from contract `address`")
+	flags.StringVar(&src, "src", "", "load script from JSON `file` (API contract script or Micheline script)")
+	flags.StringVar(&name, "name", "", "contract type name used as prefix for generated types")
+	flags.StringVar(&pkg, "pkg", "", "Go package name (default: $GOPACKAGE)")
+	flags.StringVar(&out, "out", "", "output `file` (default: stdout)")
+	flags.BoolVar(&prefix, "prefix", false, "prefix decode helpers with contract name")
+}
+
+func main() {
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			fmt.Printf("Usage: mvprogen [options]\n\n")
+			flags.PrintDefaults()
+			os.Exit(0)
+		}
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if err := run(); err != nil {
+		if e, ok := mvpro.IsErrApi(err); ok {
+			fmt.Printf("Error: %s: %s\n", e.Message, e.Detail)
+		} else {
+			fmt.Printf("Error: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if pkg == "" {
+		pkg = os.Getenv("GOPACKAGE")
+	}
+	switch {
+	case name == "":
+		return fmt.Errorf("missing contract name")
+	case pkg == "":
+		return fmt.Errorf("missing package name")
+	case (address == "") == (src == ""):
+		return fmt.Errorf("exactly one of -address or -src is required")
+	}
+
+	var (
+		script *micheline.Script
+		source string
+		err    error
+	)
+	if address != "" {
+		script, err = loadRemote(address)
+		source = address
+	} else {
+		script, err = loadFile(src)
+		source = filepath.Base(src)
+	}
+	if err != nil {
+		return err
+	}
+
+	buf, err := newGenerator(name, pkg, source).WithPrefix(prefix).Generate(script)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(buf)
+		return err
+	}
+	return os.WriteFile(out, buf, 0644)
+}
+
+func loadRemote(s string) (*micheline.Script, error) {
+	addr, err := mavryk.ParseAddress(s)
+	if err != nil {
+		return nil, err
+	}
+	c := mvpro.NewClient(api, nil)
+	if key := os.Getenv("MVPRO_API_KEY"); key != "" {
+		c.WithApiKey(key)
+	}
+	cs, err := c.Contract.GetScript(context.Background(), addr, mvpro.NewQuery().WithPrim())
+	if err != nil {
+		return nil, err
+	}
+	if cs.Script == nil {
+		return nil, fmt.Errorf("%s: missing script", addr)
+	}
+	return cs.Script, nil
+}
+
+// loadFile reads an API contract script with a nested `script` field,
+// a plain Micheline script with `code` and `storage` or a bare code sequence.
+func loadFile(fname string) (*micheline.Script, error) {
+	buf, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var wrapped struct {
+		Script *micheline.Script `json:"script"`
+	}
+	if err := json.Unmarshal(buf, &wrapped); err == nil && wrapped.Script != nil {
+		return wrapped.Script, nil
+	}
+	script := micheline.NewScript()
+	if len(buf) > 0 && buf[0] == '[' {
+		err = json.Unmarshal(buf, &script.Code)
+	} else {
+		err = json.Unmarshal(buf, script)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+	return script, nil
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+// mvprometa validates metadata documents against the MvPro metadata schemas
+// before upload and synchronizes a directory of metadata files with the
+// metadata API. Files contain a single document or an array of documents
+// in the format used by the metadata API. Schemas embedded in the SDK are
+// used unless -fetch or -schemas is given. Exits with status 1 when any
+// document is invalid.
+//
+// Usage:
+//
+//	mvprometa [options] file.json [file.json ...]
+//	mvprometa -fetch -api https://api.mvpro.io metadata/*.json
+//	mvprometa -schemas ./schemas metadata/*.json
+//	mvprometa [options] plan dir
+//	mvprometa [options] apply dir
+//
+// Plan prints the changes required to make remote state match dir. Apply
+// executes them. Apply refuses to delete more than -max-deletes entries.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mavryk-network/mvpro-go/mvpro"
+	"github.com/mavryk-network/mvpro-go/mvpro/metadata"
+)
+
+var (
+	flags   = flag.NewFlagSet("mvprometa", flag.ContinueOnError)
+	api     string
+	fetch   bool
+	schemas string
+	lax     bool
+	verbose bool
+
+	dryRun     bool
+	noPrune    bool
+	maxDeletes int
+	batchSize  int
+)
+
+func init() {
+	flags.Usage = func() {}
+	flags.StringVar(&api, "api", "https://api.mvpro.io", "MvPro API url")
+	flags.BoolVar(&fetch, "fetch", false, "fetch current schemas from the API (falls back to embedded schemas)")
+	flags.StringVar(&schemas, "schemas", "", "load schemas from `dir` containing <key>.json files")
+	flags.BoolVar(&lax, "lax", false, "accept content keys without schema")
+	flags.BoolVar(&verbose, "v", false, "print schema source and valid files")
+	flags.BoolVar(&dryRun, "dry-run", false, "apply: show changes without calling the API")
+	flags.BoolVar(&noPrune, "no-prune", false, "plan/apply: keep remote entries without local file")
+	flags.IntVar(&maxDeletes, "max-deletes", 10, "apply: refuse plans deleting more than `n` entries (-1 disables)")
+	flags.IntVar(&batchSize, "batch", 100, "apply: create `n` entries per API call")
+}
+
+func main() {
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			fmt.Printf("Usage: mvprometa [options] file.json [file.json ...]\n")
+			fmt.Printf("       mvprometa [options] plan|apply dir\n\n")
+			flags.PrintDefaults()
+			os.Exit(0)
+		}
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	ok, err := run()
+	if err != nil {
+		if e, ok := mvpro.IsErrApi(err); ok {
+			fmt.Printf("Error: %s: %s\n", e.Message, e.Detail)
+		} else {
+			fmt.Printf("Error: %v\n", err)
+		}
+		os.Exit(1)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func run() (bool, error) {
+	if flags.NArg() == 0 {
+		return false, fmt.Errorf("missing input files")
+	}
+	v, err := newValidator()
+	if err != nil {
+		return false, err
+	}
+	switch cmd := flags.Arg(0); cmd {
+	case "plan", "apply":
+		if flags.NArg() != 2 {
+			return false, fmt.Errorf("%s: expected a single directory", cmd)
+		}
+		return sync(v, cmd == "apply", flags.Arg(1))
+	default:
+		return validate(v, flags.Args())
+	}
+}
+
+func newValidator() (*metadata.Validator, error) {
+	v := metadata.NewValidator().WithStrict(!lax)
+	if fetch {
+		c := mvpro.NewClient(api, nil)
+		if err := v.Fetch(context.Background(), c.Metadata); err != nil {
+			fmt.Printf("Warning: fetching schemas failed, using %s schemas: %v\n", v.Source(), err)
+		}
+	}
+	if schemas != "" {
+		m, err := loadSchemas(schemas)
+		if err != nil {
+			return nil, err
+		}
+		if err := v.Load(m); err != nil {
+			return nil, err
+		}
+	}
+	if verbose {
+		fmt.Printf("Using %s schemas for %s\n", v.Source(), strings.Join(v.Keys(), ", "))
+	}
+	return v, nil
+}
+
+func sync(v *metadata.Validator, apply bool, dir string) (bool, error) {
+	local, err := metadata.LoadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	c := mvpro.NewClient(api, nil)
+	s := metadata.NewSyncer(c.Metadata).
+		WithValidator(v).
+		WithPrune(!noPrune).
+		WithMaxDeletes(maxDeletes).
+		WithBatchSize(batchSize).
+		WithDryRun(dryRun)
+	plan, err := s.Plan(context.Background(), local)
+	if err != nil {
+		if errs, ok := metadata.IsValidationError(err); ok {
+			for _, e := range errs {
+				fmt.Println(e.Error())
+			}
+			return false, nil
+		}
+		return false, err
+	}
+	fmt.Print(plan)
+	if !apply {
+		if err := s.Check(plan); err != nil {
+			fmt.Printf("Warning: apply will fail: %v\n", err)
+		}
+		return true, nil
+	}
+	if plan.IsEmpty() {
+		return true, nil
+	}
+	res, err := s.Apply(context.Background(), plan)
+	if res != nil {
+		prefix := "Applied"
+		if res.DryRun {
+			prefix = "Dry-run"
+		}
+		fmt.Printf("%s: %d created, %d updated, %d deleted\n", prefix, res.Created, res.Updated, res.Deleted)
+	}
+	return err == nil, err
+}
+
+func validate(v *metadata.Validator, files []string) (bool, error) {
+	valid := true
+	for _, fname := range files {
+		buf, err := os.ReadFile(fname)
+		if err != nil {
+			return false, err
+		}
+		err = v.ValidateJSON(buf)
+		if err == nil {
+			if verbose {
+				fmt.Printf("%s: ok\n", fname)
+			}
+			continue
+		}
+		valid = false
+		errs, ok := metadata.IsValidationError(err)
+		if !ok {
+			fmt.Printf("%s: %v\n", fname, err)
+			continue
+		}
+		for _, e := range errs {
+			fmt.Printf("%s: %s\n", fname, e.Error())
+		}
+	}
+	return valid, nil
+}
+
+func loadSchemas(dir string) (map[string]json.RawMessage, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no schema files", dir)
+	}
+	res := make(map[string]json.RawMessage, len(files))
+	for _, fname := range files {
+		buf, err := os.ReadFile(fname)
+		if err != nil {
+			return nil, err
+		}
+		res[strings.TrimSuffix(filepath.Base(fname), ".json")] = buf
+	}
+	return res, nil
+}
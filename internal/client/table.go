@@ -93,6 +93,15 @@ func NewTableQuery[T any](c *Client, name string) *TableQuery[T] {
 	}
 }
 
+// Clone returns a copy of q which can be modified without affecting q.
+func (q *TableQuery[T]) Clone() *TableQuery[T] {
+	nq := *q
+	nq.Query = q.Query.Clone()
+	nq.Columns = append([]string(nil), q.Columns...)
+	nq.Filter = append(make(FilterList, 0, len(q.Filter)), q.Filter...)
+	return &nq
+}
+
 func (q *TableQuery[T]) GetColumns() []string {
 	return q.Columns
 }
@@ -0,0 +1,38 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package util
+
+import (
+	"bytes"
+)
+
+// LimitedBuffer captures writes until max bytes are exceeded. Writes never
+// fail, so the buffer can be used as a side channel of an io.MultiWriter.
+// After an overflow the buffer is empty and discards all further data.
+type LimitedBuffer struct {
+	bytes.Buffer
+	max      int64
+	overflow bool
+}
+
+func NewLimitedBuffer(max int64) *LimitedBuffer {
+	return &LimitedBuffer{max: max}
+}
+
+// Overflow reports whether more than max bytes were written.
+func (b *LimitedBuffer) Overflow() bool {
+	return b.overflow
+}
+
+func (b *LimitedBuffer) Write(p []byte) (int, error) {
+	if b.overflow {
+		return len(p), nil
+	}
+	if int64(b.Len()+len(p)) > b.max {
+		b.overflow = true
+		b.Reset()
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,345 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/echa/log"
+	"github.com/mavryk-network/mvpro-go/internal/client"
+)
+
+// Report is the result of an audit over a block range.
+type Report struct {
+	From       int64          `json:"from"`
+	To         int64          `json:"to"`
+	Blocks     int            `json:"blocks"`
+	Checks     map[string]int `json:"checks"`
+	Failures   map[string]int `json:"failures"`
+	Violations []Violation    `json:"violations"`
+	Genesis    float64        `json:"genesis"`
+	Missing    []int64        `json:"missing,omitempty"`
+}
+
+// OK reports whether all invariants held.
+func (r *Report) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// FirstBreak returns the earliest violation.
+func (r *Report) FirstBreak() (Violation, bool) {
+	if len(r.Violations) == 0 {
+		return Violation{}, false
+	}
+	return r.Violations[0], true
+}
+
+func (r *Report) add(v Violation) {
+	r.Failures[v.Invariant]++
+	r.Violations = append(r.Violations, v)
+}
+
+func (r *Report) sort() {
+	sort.SliceStable(r.Violations, func(i, j int) bool {
+		return r.Violations[i].Height < r.Violations[j].Height
+	})
+}
+
+// Auditor verifies supply identities over block or cycle ranges. Supply
+// rows are checked against each other, against the chain table for gaps
+// and optionally against burns summed from ops and balance flows.
+type Auditor struct {
+	explorer   ExplorerAPI
+	ops        OpAPI
+	accounts   AccountAPI
+	invariants []Invariant
+	tolerance  float64
+	genesis    *float64
+	maxPerType int
+	batch      int
+	log        log.Logger
+}
+
+// NewAuditor creates an auditor. ops and accounts may be nil to skip the
+// burn cross-checks against ops and flows.
+func NewAuditor(explorer ExplorerAPI, ops OpAPI, accounts AccountAPI) *Auditor {
+	return &Auditor{
+		explorer:   explorer,
+		ops:        ops,
+		accounts:   accounts,
+		invariants: DefaultInvariants(),
+		tolerance:  0.000001,
+		maxPerType: 100,
+		batch:      1000,
+		log:        log.Disabled,
+	}
+}
+
+// WithTolerance sets the absolute tolerance in tez, 1 mumav by default.
+func (a *Auditor) WithTolerance(t float64) *Auditor {
+	a.tolerance = t
+	return a
+}
+
+// WithGenesis sets the non-fundraiser genesis supply used by the total
+// balance identity. Without it the offset is taken from the first row of
+// a range and the identity only detects changes within the range.
+func (a *Auditor) WithGenesis(v float64) *Auditor {
+	a.genesis = &v
+	return a
+}
+
+// WithInvariants adds custom invariants.
+func (a *Auditor) WithInvariants(inv ...Invariant) *Auditor {
+	a.invariants = append(a.invariants, inv...)
+	return a
+}
+
+// WithMaxViolations limits the violations recorded per invariant. Failures
+// are still counted.
+func (a *Auditor) WithMaxViolations(n int) *Auditor {
+	a.maxPerType = n
+	return a
+}
+
+// WithBatchSize sets the page size of table queries.
+func (a *Auditor) WithBatchSize(n int) *Auditor {
+	a.batch = n
+	return a
+}
+
+func (a *Auditor) WithLogger(l log.Logger) *Auditor {
+	a.log = l
+	return a
+}
+
+// AuditBlocks checks all blocks in [from, to].
+func (a *Auditor) AuditBlocks(ctx context.Context, from, to int64) (*Report, error) {
+	rows, err := fetchAll(ctx, a.explorer.NewSupplyQuery().AndRange("height", from, to), a.batch)
+	if err != nil {
+		return nil, fmt.Errorf("loading supply: %w", err)
+	}
+	chain, err := fetchAll(ctx, a.explorer.NewChainQuery().AndRange("height", from, to).WithColumns("row_id", "height", "cycle"), a.batch)
+	if err != nil {
+		return nil, fmt.Errorf("loading chain: %w", err)
+	}
+	return a.audit(ctx, from, to, rows, chain)
+}
+
+// AuditCycles checks all blocks of cycles [from, to].
+func (a *Auditor) AuditCycles(ctx context.Context, from, to int64) (*Report, error) {
+	chain, err := fetchAll(ctx, a.explorer.NewChainQuery().AndRange("cycle", from, to).WithColumns("row_id", "height", "cycle"), a.batch)
+	if err != nil {
+		return nil, fmt.Errorf("loading chain: %w", err)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no blocks in cycles %d..%d", from, to)
+	}
+	first, last := chain[0].Height, chain[len(chain)-1].Height
+	rows, err := fetchAll(ctx, a.explorer.NewSupplyQuery().AndRange("height", first, last), a.batch)
+	if err != nil {
+		return nil, fmt.Errorf("loading supply: %w", err)
+	}
+	return a.audit(ctx, first, last, rows, chain)
+}
+
+func (a *Auditor) audit(ctx context.Context, from, to int64, rows []*Supply, chain []*Chain) (*Report, error) {
+	a.log.Debugf("audit: blocks %d..%d with %d supply rows", from, to, len(rows))
+	rep := a.Check(rows)
+	rep.From, rep.To = from, to
+
+	// every block in the chain table must have a supply row
+	have := make(map[int64]bool, len(rows))
+	for _, s := range rows {
+		have[s.Height] = true
+	}
+	for _, c := range chain {
+		if have[c.Height] {
+			continue
+		}
+		rep.Missing = append(rep.Missing, c.Height)
+		rep.Checks["supply_coverage"]++
+		rep.add(Violation{
+			Invariant: "supply_coverage",
+			Height:    c.Height,
+			Cycle:     c.Cycle,
+			Message:   "no supply row for block",
+		})
+	}
+
+	if err := a.crossCheckBurns(ctx, from, to, rows, rep); err != nil {
+		return nil, err
+	}
+	rep.sort()
+	a.log.Debugf("audit: %d violations", len(rep.Violations))
+	return rep, nil
+}
+
+// Check verifies invariants on supply rows without API access. Rows are
+// sorted by height, identities between blocks are only checked for
+// consecutive heights.
+func (a *Auditor) Check(rows []*Supply) *Report {
+	rows = append([]*Supply(nil), rows...)
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Height < rows[j].Height })
+	rep := &Report{
+		Blocks:   len(rows),
+		Checks:   make(map[string]int),
+		Failures: make(map[string]int),
+	}
+	if len(rows) == 0 {
+		return rep
+	}
+	rep.From, rep.To = rows[0].Height, rows[len(rows)-1].Height
+
+	invariants := append([]Invariant(nil), a.invariants...)
+	if a.genesis != nil {
+		rep.Genesis = *a.genesis
+	} else {
+		s := rows[0]
+		rep.Genesis = s.Total - (s.Activated + s.Unclaimed + s.Minted - s.Burned)
+	}
+	invariants = append(invariants, TotalInvariant(rep.Genesis))
+
+	var prev *Supply
+	for _, s := range rows {
+		if prev != nil && prev.Height+1 != s.Height {
+			prev = nil
+		}
+		for _, inv := range invariants {
+			got, want, ok := inv.Check(prev, s)
+			if !ok {
+				continue
+			}
+			rep.Checks[inv.Name]++
+			if equal(got, want, a.tolerance) {
+				continue
+			}
+			if rep.Failures[inv.Name] >= a.maxPerType {
+				rep.Failures[inv.Name]++
+				continue
+			}
+			rep.add(Violation{
+				Invariant: inv.Name,
+				Height:    s.Height,
+				Cycle:     s.Cycle,
+				Got:       got,
+				Want:      want,
+				Diff:      got - want,
+			})
+		}
+		prev = s
+	}
+	rep.sort()
+	return rep
+}
+
+// burnDelta is the change of burned supply in a block.
+type burnDelta struct {
+	cycle int64
+	all   float64
+	ops   float64
+}
+
+// crossCheckBurns compares per-block burn deltas from supply rows with
+// burns from balance flows (all burns) and ops (burns paid by operations,
+// i.e. origination, allocation, storage, explicit and rollup burns).
+func (a *Auditor) crossCheckBurns(ctx context.Context, from, to int64, rows []*Supply, rep *Report) error {
+	if a.ops == nil && a.accounts == nil {
+		return nil
+	}
+	deltas := make(map[int64]burnDelta)
+	for i := 1; i < len(rows); i++ {
+		p, s := rows[i-1], rows[i]
+		if p.Height+1 != s.Height {
+			continue
+		}
+		deltas[s.Height] = burnDelta{
+			cycle: s.Cycle,
+			all:   s.Burned - p.Burned,
+			ops:   opBurned(s) - opBurned(p),
+		}
+	}
+
+	if a.accounts != nil {
+		flows, err := fetchAll(ctx, a.accounts.NewFlowQuery().
+			AndRange("height", from, to).
+			AndEqual("is_burned", true), a.batch)
+		if err != nil {
+			return fmt.Errorf("loading flows: %w", err)
+		}
+		sums := make(map[int64]float64)
+		for _, f := range flows {
+			sums[f.Height] += f.AmountOut
+		}
+		a.compare(rep, "flow_burns", deltas, sums, func(d burnDelta) float64 { return d.all })
+	}
+	if a.ops != nil {
+		ops, err := fetchAll(ctx, a.ops.NewQuery().
+			AndRange("height", from, to).
+			AndGt("burned", 0), a.batch)
+		if err != nil {
+			return fmt.Errorf("loading ops: %w", err)
+		}
+		sums := make(map[int64]float64)
+		for _, o := range ops {
+			sums[o.Height] += o.Burned
+		}
+		a.compare(rep, "op_burns", deltas, sums, func(d burnDelta) float64 { return d.ops })
+	}
+	return nil
+}
+
+// compare checks burn sums per block against supply deltas. Blocks
+// without a delta (first row or gaps) are skipped.
+func (a *Auditor) compare(rep *Report, name string, deltas map[int64]burnDelta, sums map[int64]float64, want func(burnDelta) float64) {
+	heights := make([]int64, 0, len(deltas))
+	for h := range deltas {
+		heights = append(heights, h)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	for _, h := range heights {
+		d := deltas[h]
+		got, exp := sums[h], want(d)
+		rep.Checks[name]++
+		if equal(got, exp, a.tolerance) {
+			continue
+		}
+		if rep.Failures[name] >= a.maxPerType {
+			rep.Failures[name]++
+			continue
+		}
+		rep.add(Violation{
+			Invariant: name,
+			Height:    h,
+			Cycle:     d.cycle,
+			Got:       got,
+			Want:      exp,
+			Diff:      got - exp,
+		})
+	}
+}
+
+// opBurned is the part of burned supply paid by operations.
+func opBurned(s *Supply) float64 {
+	return s.BurnedOrigination + s.BurnedAllocation + s.BurnedStorage + s.BurnedExplicit + s.BurnedRollup
+}
+
+// fetchAll pages through a table query.
+func fetchAll[T any](ctx context.Context, q *client.TableQuery[T], limit int) ([]T, error) {
+	q.WithLimit(limit).Asc()
+	var res []T
+	for {
+		r, err := q.Run(ctx)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r.Rows()...)
+		if r.Len() < limit {
+			return res, nil
+		}
+		q.WithCursor(r.Cursor())
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package audit
+
+import (
+	"fmt"
+	"math"
+)
+
+// Invariant is a supply identity checked on every supply row. Check
+// receives the previous row (nil for the first row or after a gap) and
+// returns the two sides of the identity. Invariants that only hold
+// between consecutive blocks return ok false when prev is nil.
+type Invariant struct {
+	Name  string
+	Desc  string
+	Check func(prev, cur *Supply) (got, want float64, ok bool)
+}
+
+// Violation is a single failed check.
+type Violation struct {
+	Invariant string  `json:"invariant"`
+	Height    int64   `json:"height"`
+	Cycle     int64   `json:"cycle"`
+	Got       float64 `json:"got"`
+	Want      float64 `json:"want"`
+	Diff      float64 `json:"diff"`
+	Message   string  `json:"message,omitempty"`
+}
+
+func (v Violation) String() string {
+	if v.Message != "" {
+		return fmt.Sprintf("%d: %s: %s", v.Height, v.Invariant, v.Message)
+	}
+	return fmt.Sprintf("%d: %s: got %.6f want %.6f (diff %.6f)", v.Height, v.Invariant, v.Got, v.Want, v.Diff)
+}
+
+// DefaultInvariants returns the identities between the supply components
+// of index.Supply. Minted and burned values are running totals.
+func DefaultInvariants() []Invariant {
+	return []Invariant{
+		{
+			Name: "minted_components",
+			Desc: "minted = baking + endorsing + seeding + airdrop + subsidy",
+			Check: func(_, s *Supply) (float64, float64, bool) {
+				return s.Minted, s.MintedBaking + s.MintedEndorsing + s.MintedSeeding + s.MintedAirdrop + s.MintedSubsidy, true
+			},
+		},
+		{
+			Name: "burned_components",
+			Desc: "burned = sum of all burn categories",
+			Check: func(_, s *Supply) (float64, float64, bool) {
+				return s.Burned, s.BurnedDoubleBaking + s.BurnedDoubleEndorse + s.BurnedOrigination +
+					s.BurnedAllocation + s.BurnedStorage + s.BurnedExplicit + s.BurnedSeedMiss +
+					s.BurnedAbsence + s.BurnedRollup, true
+			},
+		},
+		{
+			Name: "frozen_components",
+			Desc: "frozen = deposits + rewards + fees + bonds",
+			Check: func(_, s *Supply) (float64, float64, bool) {
+				return s.Frozen, s.FrozenDeposits + s.FrozenRewards + s.FrozenFees + s.FrozenBonds, true
+			},
+		},
+		{
+			Name: "total_delta",
+			Desc: "total change per block = minted change - burned change",
+			Check: func(p, s *Supply) (float64, float64, bool) {
+				if p == nil {
+					return 0, 0, false
+				}
+				return s.Total - p.Total, (s.Minted - p.Minted) - (s.Burned - p.Burned), true
+			},
+		},
+		{
+			Name: "fundraiser_constant",
+			Desc: "activated + unclaimed stays constant",
+			Check: func(p, s *Supply) (float64, float64, bool) {
+				if p == nil {
+					return 0, 0, false
+				}
+				return s.Activated + s.Unclaimed, p.Activated + p.Unclaimed, true
+			},
+		},
+		{
+			Name: "minted_monotonic",
+			Desc: "minted never decreases",
+			Check: func(p, s *Supply) (float64, float64, bool) {
+				if p == nil || s.Minted >= p.Minted {
+					return 0, 0, false
+				}
+				return s.Minted, p.Minted, true
+			},
+		},
+		{
+			Name: "burned_monotonic",
+			Desc: "burned never decreases",
+			Check: func(p, s *Supply) (float64, float64, bool) {
+				if p == nil || s.Burned >= p.Burned {
+					return 0, 0, false
+				}
+				return s.Burned, p.Burned, true
+			},
+		},
+		{
+			Name: "circulating_bound",
+			Desc: "circulating + frozen + unclaimed <= total",
+			Check: func(_, s *Supply) (float64, float64, bool) {
+				if sum := s.Circulating + s.Frozen + s.Unclaimed; sum > s.Total {
+					return sum, s.Total, true
+				}
+				return 0, 0, false
+			},
+		},
+		{
+			Name: "stake_bound",
+			Desc: "active stake <= staking + delegated",
+			Check: func(_, s *Supply) (float64, float64, bool) {
+				if s.ActiveStake > s.Staking+s.Delegated {
+					return s.ActiveStake, s.Staking + s.Delegated, true
+				}
+				return 0, 0, false
+			},
+		},
+	}
+}
+
+// TotalInvariant checks total = genesis + activated + unclaimed + minted -
+// burned where genesis is the supply outside the fundraiser allocation
+// (bootstrap accounts).
+func TotalInvariant(genesis float64) Invariant {
+	return Invariant{
+		Name: "total_balance",
+		Desc: "total = genesis + activated + unclaimed + minted - burned",
+		Check: func(_, s *Supply) (float64, float64, bool) {
+			return s.Total, genesis + s.Activated + s.Unclaimed + s.Minted - s.Burned, true
+		},
+	}
+}
+
+// equal compares within an absolute tolerance widened by the rounding
+// error of large float sums.
+func equal(got, want, tol float64) bool {
+	return math.Abs(got-want) <= tol+1e-12*math.Max(math.Abs(got), math.Abs(want))
+}
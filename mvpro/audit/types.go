@@ -0,0 +1,18 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package audit
+
+import (
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+type (
+	Supply      = index.Supply
+	Chain       = index.Chain
+	Flow        = index.Flow
+	Op          = index.Op
+	ExplorerAPI = index.ExplorerAPI
+	OpAPI       = index.OpAPI
+	AccountAPI  = index.AccountAPI
+)
@@ -0,0 +1,338 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package bigmap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+// pageSize is the number of rows requested per API call.
+const pageSize = 500
+
+type ChangeType string
+
+const (
+	ChangeAdded   ChangeType = "added"
+	ChangeRemoved ChangeType = "removed"
+	ChangeChanged ChangeType = "changed"
+)
+
+// PathDiff is a change of a single leaf inside a nested value. Path uses the
+// dot notation of BigmapValue.Walk. Before or After is nil when the leaf
+// does not exist on that side.
+type PathDiff struct {
+	Path   string `json:"path"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// KeyDiff describes how a single bigmap key differs between both sides.
+type KeyDiff struct {
+	Hash   ExprHash   `json:"hash"`
+	Key    string     `json:"key"`
+	Type   ChangeType `json:"type"`
+	Before any        `json:"before,omitempty"`
+	After  any        `json:"after,omitempty"`
+	Paths  []PathDiff `json:"paths,omitempty"`
+}
+
+// Diff lists keys that were added, removed or changed between two bigmap
+// states, either of the same bigmap at two heights or of two bigmaps.
+type Diff struct {
+	FromId     int64     `json:"from_id"`
+	ToId       int64     `json:"to_id"`
+	FromHeight int64     `json:"from_height,omitempty"`
+	ToHeight   int64     `json:"to_height,omitempty"`
+	Added      int       `json:"n_added"`
+	Removed    int       `json:"n_removed"`
+	Changed    int       `json:"n_changed"`
+	Keys       []KeyDiff `json:"keys"`
+}
+
+func (d Diff) IsEmpty() bool {
+	return len(d.Keys) == 0
+}
+
+// DiffHeights compares bigmap id after all updates at height from with its
+// state after all updates at height to. Both states are reconstructed by
+// replaying the bigmap's updates, so allocs, copies and removals of the
+// entire bigmap inside the range are reflected in the result.
+func DiffHeights(ctx context.Context, api ContractAPI, id, from, to int64) (*Diff, error) {
+	return NewBuilder(api).DiffHeights(ctx, id, from, to)
+}
+
+// DiffHeights is like the package level DiffHeights but uses the builder's
+// store and batch size for both snapshots.
+func (b *Builder) DiffHeights(ctx context.Context, id, from, to int64) (*Diff, error) {
+	if to < from {
+		from, to = to, from
+	}
+	a, err := b.BigmapSnapshot(ctx, id, from)
+	if err != nil {
+		return nil, err
+	}
+	defer a.discard()
+	z, err := b.BigmapSnapshot(ctx, id, to)
+	if err != nil {
+		return nil, err
+	}
+	defer z.discard()
+	d, err := DiffSnapshots(a, z)
+	if err != nil {
+		return nil, err
+	}
+	d.FromHeight, d.ToHeight = from, to
+	return d, nil
+}
+
+// DiffSnapshots compares two bigmap snapshots by key hash.
+func DiffSnapshots(from, to *Snapshot) (*Diff, error) {
+	d := &Diff{
+		FromId: from.Id,
+		ToId:   to.Id,
+		Keys:   make([]KeyDiff, 0),
+	}
+	err := to.Range(func(e *Entry) error {
+		old, err := from.GetHash(e.Hash)
+		if err != nil {
+			return err
+		}
+		if old != nil && old.Value.IsEqual(e.Value) {
+			return nil
+		}
+		after, err := to.bigmapValue(e)
+		if err != nil {
+			return err
+		}
+		var before *index.BigmapValue
+		if old != nil {
+			if before, err = from.bigmapValue(old); err != nil {
+				return err
+			}
+		}
+		if kd, ok := diffKey(before, after); ok {
+			d.add(kd)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = from.Range(func(e *Entry) error {
+		cur, err := to.GetHash(e.Hash)
+		if err != nil || cur != nil {
+			return err
+		}
+		before, err := from.bigmapValue(e)
+		if err != nil {
+			return err
+		}
+		if kd, ok := diffKey(before, nil); ok {
+			d.add(kd)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.sort()
+	return d, nil
+}
+
+// DiffBigmaps compares the current state of two bigmaps with the same key
+// and value types, e.g. the ledgers of a staging and a production contract.
+func DiffBigmaps(ctx context.Context, api ContractAPI, from, to int64) (*Diff, error) {
+	a, err := listValues(ctx, api, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := listValues(ctx, api, to)
+	if err != nil {
+		return nil, err
+	}
+	d := DiffValues(a, b)
+	d.FromId, d.ToId = from, to
+	return d, nil
+}
+
+func listValues(ctx context.Context, api ContractAPI, id int64) (index.BigmapValueList, error) {
+	res := make(index.BigmapValueList, 0)
+	var cursor uint64
+	for {
+		list, err := api.ListBigmapValues(ctx, id, NewQuery().WithCursor(cursor).WithLimit(pageSize))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, list...)
+		if len(list) < pageSize {
+			break
+		}
+		cursor = list.Cursor()
+	}
+	return res, nil
+}
+
+// DiffValues compares two lists of decoded bigmap values by key hash.
+func DiffValues(from, to index.BigmapValueList) *Diff {
+	a := make(map[string]*index.BigmapValue, len(from))
+	for _, v := range from {
+		a[v.Hash.String()] = v
+	}
+	d := &Diff{Keys: make([]KeyDiff, 0)}
+	for _, v := range to {
+		h := v.Hash.String()
+		if kd, ok := diffKey(a[h], v); ok {
+			d.add(kd)
+		}
+		delete(a, h)
+	}
+	for _, v := range a {
+		if kd, ok := diffKey(v, nil); ok {
+			d.add(kd)
+		}
+	}
+	d.sort()
+	return d
+}
+
+func diffKey(before, after *index.BigmapValue) (KeyDiff, bool) {
+	var kd KeyDiff
+	switch {
+	case before == nil && after == nil:
+		return kd, false
+	case before == nil:
+		kd = KeyDiff{Hash: after.Hash, Key: after.Key.String(), Type: ChangeAdded, After: after.Value}
+	case after == nil:
+		kd = KeyDiff{Hash: before.Hash, Key: before.Key.String(), Type: ChangeRemoved, Before: before.Value}
+	default:
+		paths := diffPaths(before, after)
+		if len(paths) == 0 {
+			return kd, false
+		}
+		kd = KeyDiff{
+			Hash:   after.Hash,
+			Key:    after.Key.String(),
+			Type:   ChangeChanged,
+			Before: before.Value,
+			After:  after.Value,
+			Paths:  paths,
+		}
+	}
+	return kd, true
+}
+
+// diffPaths flattens both values into leaf paths and returns all leaves that
+// differ in path order.
+func diffPaths(before, after *index.BigmapValue) []PathDiff {
+	a, b := leaves(before), leaves(after)
+	paths := make([]PathDiff, 0)
+	for p, av := range a {
+		bv, ok := b[p]
+		if !ok {
+			paths = append(paths, PathDiff{Path: p, Before: av})
+		} else if !reflect.DeepEqual(av, bv) {
+			paths = append(paths, PathDiff{Path: p, Before: av, After: bv})
+		}
+	}
+	for p, bv := range b {
+		if _, ok := a[p]; !ok {
+			paths = append(paths, PathDiff{Path: p, After: bv})
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].Path < paths[j].Path })
+	return paths
+}
+
+func leaves(v *index.BigmapValue) map[string]any {
+	m := make(map[string]any)
+	_ = v.Walk("", func(path string, val any) error {
+		m[path] = val
+		return nil
+	})
+	return m
+}
+
+func (d *Diff) add(kd KeyDiff) {
+	switch kd.Type {
+	case ChangeAdded:
+		d.Added++
+	case ChangeRemoved:
+		d.Removed++
+	case ChangeChanged:
+		d.Changed++
+	}
+	d.Keys = append(d.Keys, kd)
+}
+
+func (d *Diff) sort() {
+	sort.SliceStable(d.Keys, func(i, j int) bool { return d.Keys[i].Key < d.Keys[j].Key })
+}
+
+// WriteJSON writes the diff as indented JSON.
+func (d Diff) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// WriteText writes a unified diff style text rendering.
+func (d Diff) WriteText(w io.Writer) error {
+	_, err := io.WriteString(w, d.String())
+	return err
+}
+
+func (d Diff) String() string {
+	var b strings.Builder
+	if d.FromHeight > 0 || d.ToHeight > 0 {
+		fmt.Fprintf(&b, "bigmap %d height %d..%d", d.FromId, d.FromHeight, d.ToHeight)
+	} else {
+		fmt.Fprintf(&b, "bigmap %d..%d", d.FromId, d.ToId)
+	}
+	fmt.Fprintf(&b, ": %d added, %d removed, %d changed\n", d.Added, d.Removed, d.Changed)
+	for _, k := range d.Keys {
+		switch k.Type {
+		case ChangeAdded:
+			fmt.Fprintf(&b, "+ %s = %s\n", k.Key, render(k.After))
+		case ChangeRemoved:
+			fmt.Fprintf(&b, "- %s = %s\n", k.Key, render(k.Before))
+		case ChangeChanged:
+			fmt.Fprintf(&b, "~ %s\n", k.Key)
+			for _, p := range k.Paths {
+				name := p.Path
+				if name == "" {
+					name = "."
+				}
+				switch {
+				case p.Before == nil:
+					fmt.Fprintf(&b, "    + %s: %s\n", name, render(p.After))
+				case p.After == nil:
+					fmt.Fprintf(&b, "    - %s: %s\n", name, render(p.Before))
+				default:
+					fmt.Fprintf(&b, "    ~ %s: %s -> %s\n", name, render(p.Before), render(p.After))
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+func render(v any) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case nil:
+		return "null"
+	default:
+		buf, _ := json.Marshal(val)
+		return string(buf)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package bigmap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mavryk-network/mvgo/micheline"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+// Builder reconstructs historic bigmap state by replaying update rows from
+// the bigmap_updates table.
+type Builder struct {
+	api     ContractAPI
+	factory StoreFactory
+	batch   int
+	inlined bool
+}
+
+func NewBuilder(api ContractAPI) *Builder {
+	return &Builder{
+		api:     api,
+		factory: MemStoreFactory,
+		batch:   1000,
+	}
+}
+
+// WithStore sets the store used for new snapshots, e.g. FileStoreFactory
+// for bigmaps that do not fit into memory.
+func (b *Builder) WithStore(f StoreFactory) *Builder {
+	b.factory = f
+	return b
+}
+
+// WithInlineBigmaps makes GetStorageAt replace bigmap ids in storage by
+// their full content at the requested height.
+func (b *Builder) WithInlineBigmaps(enable bool) *Builder {
+	b.inlined = enable
+	return b
+}
+
+func (b *Builder) WithBatchSize(n int) *Builder {
+	if n > 0 {
+		b.batch = n
+	}
+	return b
+}
+
+// BigmapSnapshot returns the state of bigmap id after all updates at height.
+// Copies from other bigmaps are resolved recursively.
+func (b *Builder) BigmapSnapshot(ctx context.Context, id, height int64) (*Snapshot, error) {
+	return b.build(ctx, id, height, 0)
+}
+
+func (b *Builder) build(ctx context.Context, id, height int64, maxRow uint64) (*Snapshot, error) {
+	store, err := b.factory(id)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{
+		Id:    id,
+		b:     b,
+		store: store,
+	}
+	if err := s.replay(ctx, height, maxRow); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Snapshot is the key/value state of a single bigmap at Height. Keys and
+// values are typed through KeyType and ValueType which are known after the
+// bigmap's alloc or copy row has been replayed.
+type Snapshot struct {
+	Id        int64
+	Height    int64
+	KeyType   Type
+	ValueType Type
+	IsRemoved bool // bigmap was deleted at or before Height
+
+	b       *Builder
+	store   Store
+	lastRow uint64
+}
+
+// Advance replays all updates after the current snapshot height up to and
+// including height.
+func (s *Snapshot) Advance(ctx context.Context, height int64) error {
+	if height < s.Height {
+		return fmt.Errorf("bigmap %d: cannot rewind snapshot from %d to %d", s.Id, s.Height, height)
+	}
+	return s.replay(ctx, height, 0)
+}
+
+// replay applies update rows in row order. When maxRow is non-zero only rows
+// before maxRow are used which is required to resolve copies that happen in
+// the middle of a block.
+func (s *Snapshot) replay(ctx context.Context, height int64, maxRow uint64) error {
+	for {
+		q := s.b.api.NewBigmapUpdateQuery().
+			AndEqual("bigmap_id", s.Id).
+			AndLte("height", height).
+			WithCursor(s.lastRow).
+			WithLimit(s.b.batch).
+			Asc()
+		if maxRow > 0 {
+			q = q.AndLt("row_id", maxRow)
+		}
+		res, err := q.Run(ctx)
+		if err != nil {
+			return err
+		}
+		for _, r := range res.Rows() {
+			if err := s.apply(ctx, r); err != nil {
+				return err
+			}
+			s.lastRow = r.RowId
+		}
+		if res.Len() < s.b.batch {
+			break
+		}
+	}
+	s.Height = height
+	return nil
+}
+
+func (s *Snapshot) apply(ctx context.Context, r *BigmapUpdateRow) error {
+	switch r.Action {
+	case DiffActionAlloc:
+		s.KeyType, _ = r.KeyType()
+		s.ValueType, _ = r.ValueType()
+		s.IsRemoved = false
+		return s.store.Clear()
+
+	case DiffActionCopy:
+		s.KeyType, _ = r.KeyType()
+		s.ValueType, _ = r.ValueType()
+		s.IsRemoved = false
+		if err := s.store.Clear(); err != nil {
+			return err
+		}
+		src, err := s.b.build(ctx, int64(r.KeyId), r.Height, r.RowId)
+		if err != nil {
+			return fmt.Errorf("bigmap %d: copy from %d: %v", s.Id, r.KeyId, err)
+		}
+		defer src.discard()
+		return src.store.Range(func(e *Entry) error {
+			return s.store.Put(e)
+		})
+
+	case DiffActionUpdate:
+		return s.store.Put(&Entry{
+			Hash:   s.hash(r),
+			Key:    r.Key,
+			Value:  r.Value,
+			Height: r.Height,
+		})
+
+	case DiffActionRemove:
+		if !r.Key.IsValid() {
+			// the entire bigmap was removed
+			s.IsRemoved = true
+			return s.store.Clear()
+		}
+		return s.store.Delete(s.hash(r))
+	}
+	return nil
+}
+
+func (s *Snapshot) hash(r *BigmapUpdateRow) ExprHash {
+	if r.Hash.IsValid() {
+		return r.Hash
+	}
+	if s.KeyType.IsValid() {
+		if k, err := NewKey(s.KeyType, r.Key); err == nil {
+			return k.Hash()
+		}
+	}
+	buf, _ := r.Key.MarshalBinary()
+	return micheline.KeyHash(buf)
+}
+
+func (s *Snapshot) Len() int {
+	return s.store.Len()
+}
+
+// Get returns the entry for key or nil when key does not exist.
+func (s *Snapshot) Get(key BigmapKey) (*Entry, error) {
+	return s.store.Get(key.Hash())
+}
+
+// GetHash returns the entry for key hash h or nil when h does not exist.
+func (s *Snapshot) GetHash(h ExprHash) (*Entry, error) {
+	return s.store.Get(h)
+}
+
+func (s *Snapshot) Range(fn func(*Entry) error) error {
+	return s.store.Range(fn)
+}
+
+// Key returns the typed key of e.
+func (s *Snapshot) Key(e *Entry) (BigmapKey, error) {
+	return NewKey(s.KeyType, e.Key)
+}
+
+// Value returns the typed value of e.
+func (s *Snapshot) Value(e *Entry) Value {
+	return NewValue(s.ValueType, e.Value)
+}
+
+// Unmarshal decodes the value of e into a Go type.
+func (s *Snapshot) Unmarshal(e *Entry, val any) error {
+	v := s.Value(e)
+	return v.Unmarshal(val)
+}
+
+// Map returns all entries decoded like API bigmap values, keyed by the
+// string representation of each key.
+func (s *Snapshot) Map() (map[string]any, error) {
+	m := make(map[string]any, s.Len())
+	err := s.store.Range(func(e *Entry) error {
+		k, err := s.Key(e)
+		if err != nil {
+			return err
+		}
+		v := s.Value(e)
+		val, err := v.Map()
+		if err != nil {
+			return err
+		}
+		m[k.String()] = val
+		return nil
+	})
+	return m, err
+}
+
+// discard drops temporary snapshots used to resolve copies.
+func (s *Snapshot) discard() {
+	if r, ok := s.store.(interface{ Remove() error }); ok {
+		r.Remove()
+	} else {
+		s.store.Clear()
+	}
+	s.store.Close()
+}
+
+// Close releases the snapshot store. On-disk data is kept.
+func (s *Snapshot) Close() error {
+	return s.store.Close()
+}
+
+// bigmapValue decodes e into the same form the API returns bigmap values.
+func (s *Snapshot) bigmapValue(e *Entry) (*index.BigmapValue, error) {
+	k, err := s.Key(e)
+	if err != nil {
+		return nil, err
+	}
+	var mk index.MultiKey
+	buf, err := k.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, &mk); err != nil {
+		return nil, err
+	}
+	v := s.Value(e)
+	val, err := v.Map()
+	if err != nil {
+		return nil, err
+	}
+	return &index.BigmapValue{
+		BigmapId: s.Id,
+		Hash:     e.Hash,
+		Height:   e.Height,
+		Key:      mk,
+		Value:    val,
+	}, nil
+}
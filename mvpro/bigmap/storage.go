@@ -0,0 +1,268 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package bigmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mavryk-network/mvgo/micheline"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+var (
+	ErrNotOriginated = errors.New("contract not originated at height")
+	ErrNoScript      = errors.New("contract has no script")
+)
+
+// GetStorageAt returns the storage of contract addr after all operations at
+// height. Storage is taken from the last successful call at or before
+// height or from the origination script when the contract was not called
+// yet. Like ContractAPI.GetStorage bigmaps are returned as ids which are
+// valid at height. Use ValueAt to read single keys at height. With
+// WithInlineBigmaps bigmap ids are replaced by their full content which is
+// rebuilt from bigmap updates, so bigmaps render as maps instead.
+func (b *Builder) GetStorageAt(ctx context.Context, addr Address, height int64) (*ContractValue, error) {
+	script, err := b.api.GetScript(ctx, addr, NewQuery().WithPrim())
+	if err != nil {
+		return nil, fmt.Errorf("%s: loading script: %w", addr, err)
+	}
+	if script.Script == nil {
+		return nil, fmt.Errorf("%s: %w", addr, ErrNoScript)
+	}
+	op, err := b.lastCall(ctx, addr, height)
+	if err != nil {
+		return nil, fmt.Errorf("%s: loading calls: %w", addr, err)
+	}
+	var prim Prim
+	if op != nil {
+		prim, err = op.DecodeStoragePrim(false)
+		if err != nil {
+			return nil, fmt.Errorf("%s: op %s: %w", addr, op.Hash, err)
+		}
+	} else {
+		c, err := b.api.Get(ctx, addr, NewQuery())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", addr, err)
+		}
+		if c.FirstSeen > height {
+			return nil, fmt.Errorf("%s: %w %d", addr, ErrNotOriginated, height)
+		}
+		if !script.Script.Storage.IsValid() {
+			return nil, fmt.Errorf("%s: %w", addr, index.ErrNoStorage)
+		}
+		prim = script.Script.Storage
+	}
+
+	typ := script.Script.StorageType()
+	if b.inlined {
+		prim, err = b.inline(ctx, typ.Prim, prim, height)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", addr, err)
+		}
+	}
+	v := NewValue(typ, prim)
+	val, err := v.Map()
+	if err != nil {
+		return nil, fmt.Errorf("%s: decoding storage: %w", addr, err)
+	}
+	return &ContractValue{
+		Value: val,
+		Prim:  &prim,
+	}, nil
+}
+
+// lastCall returns the last successful op at or before height that carries
+// storage of addr or nil when there is none.
+func (b *Builder) lastCall(ctx context.Context, addr Address, height int64) (*index.Op, error) {
+	params := NewQuery().
+		AndLte("height", height).
+		WithStorage().
+		WithPrim().
+		WithLimit(uint(b.batch)).
+		Desc()
+	for {
+		list, err := b.api.ListCalls(ctx, addr, params)
+		if err != nil {
+			return nil, err
+		}
+		var last *index.Op
+		for _, o := range list {
+			for _, op := range o.Content() {
+				if !op.IsSuccess || op.Storage == nil || op.Height > height || !op.Receiver.Equal(addr) {
+					continue
+				}
+				if last == nil || op.Id > last.Id {
+					last = op
+				}
+			}
+		}
+		if last != nil || len(list) < b.batch {
+			return last, nil
+		}
+		params = params.WithCursor(list.Cursor())
+	}
+}
+
+// inline replaces bigmap ids in val by the bigmap content at height. Comb
+// pairs are unfolded to binary pairs on the way.
+func (b *Builder) inline(ctx context.Context, typ, val Prim, height int64) (Prim, error) {
+	switch typ.OpCode {
+	case micheline.T_BIG_MAP:
+		if val.Type != micheline.PrimInt {
+			return val, nil
+		}
+		return b.bigmapAt(ctx, val.Int.Int64(), height)
+
+	case micheline.T_PAIR:
+		if len(typ.Args) > 2 {
+			typ = micheline.NewPairType(typ.Args[0], micheline.NewCombPairType(typ.Args[1:]...))
+		}
+		args := val.Args
+		if len(args) > 2 {
+			args = []Prim{args[0], micheline.NewCombPair(args[1:]...)}
+		}
+		if len(typ.Args) != 2 || len(args) != 2 {
+			return val, nil
+		}
+		l, err := b.inline(ctx, typ.Args[0], args[0], height)
+		if err != nil {
+			return val, err
+		}
+		r, err := b.inline(ctx, typ.Args[1], args[1], height)
+		if err != nil {
+			return val, err
+		}
+		return micheline.NewPair(l, r), nil
+
+	case micheline.T_OPTION:
+		if val.OpCode != micheline.D_SOME || len(val.Args) == 0 {
+			return val, nil
+		}
+		v, err := b.inline(ctx, typ.Args[0], val.Args[0], height)
+		if err != nil {
+			return val, err
+		}
+		return micheline.NewOption(v), nil
+
+	case micheline.T_OR:
+		var t Prim
+		switch val.OpCode {
+		case micheline.D_LEFT:
+			t = typ.Args[0]
+		case micheline.D_RIGHT:
+			t = typ.Args[1]
+		default:
+			return val, nil
+		}
+		if len(val.Args) == 0 {
+			return val, nil
+		}
+		v, err := b.inline(ctx, t, val.Args[0], height)
+		if err != nil {
+			return val, err
+		}
+		res := val
+		res.Args = []Prim{v}
+		return res, nil
+
+	case micheline.T_LIST, micheline.T_SET:
+		return b.inlineSeq(ctx, val, func(p Prim) (Prim, error) {
+			return b.inline(ctx, typ.Args[0], p, height)
+		})
+
+	case micheline.T_MAP:
+		return b.inlineSeq(ctx, val, func(p Prim) (Prim, error) {
+			if p.OpCode != micheline.D_ELT || len(p.Args) != 2 {
+				return p, nil
+			}
+			v, err := b.inline(ctx, typ.Args[1], p.Args[1], height)
+			if err != nil {
+				return p, err
+			}
+			return micheline.NewMapElem(p.Args[0], v), nil
+		})
+	}
+	return val, nil
+}
+
+// inlineSeq applies fn to all elements of a sequence value.
+func (b *Builder) inlineSeq(ctx context.Context, val Prim, fn func(Prim) (Prim, error)) (Prim, error) {
+	if val.Type != micheline.PrimSequence || len(val.Args) == 0 {
+		return val, nil
+	}
+	res := val
+	res.Args = make([]Prim, len(val.Args))
+	for i, p := range val.Args {
+		v, err := fn(p)
+		if err != nil {
+			return val, err
+		}
+		res.Args[i] = v
+	}
+	return res, nil
+}
+
+// bigmapAt returns the content of bigmap id at height as sorted sequence
+// of Elt pairs.
+func (b *Builder) bigmapAt(ctx context.Context, id, height int64) (Prim, error) {
+	s, err := b.BigmapSnapshot(ctx, id, height)
+	if err != nil {
+		return Prim{}, fmt.Errorf("bigmap %d: %w", id, err)
+	}
+	defer s.discard()
+	elts := make([]Prim, 0, s.Len())
+	err = s.Range(func(e *Entry) error {
+		elts = append(elts, micheline.NewMapElem(e.Key, e.Value))
+		return nil
+	})
+	if err != nil {
+		return Prim{}, fmt.Errorf("bigmap %d: %w", id, err)
+	}
+	return micheline.NewMap(elts...), nil
+}
+
+// ValueAt returns the raw value of key in bigmap id after all updates at
+// height without replaying the entire bigmap. Keys inherited through a
+// bigmap copy are looked up in the copy's source.
+func (b *Builder) ValueAt(ctx context.Context, id int64, key BigmapKey, height int64) (Prim, bool, error) {
+	return b.valueAt(ctx, id, key.Hash(), height, 0)
+}
+
+// valueAt looks up key hash h in bigmap id. When maxRow is non-zero only
+// rows before maxRow are considered.
+func (b *Builder) valueAt(ctx context.Context, id int64, h ExprHash, height int64, maxRow uint64) (Prim, bool, error) {
+	last := func(q *index.BigmapUpdateQuery) (*BigmapUpdateRow, error) {
+		q = q.AndEqual("bigmap_id", id).AndLte("height", height).WithLimit(1).Desc()
+		if maxRow > 0 {
+			q = q.AndLt("row_id", maxRow)
+		}
+		res, err := q.Run(ctx)
+		if err != nil || res.Len() == 0 {
+			return nil, err
+		}
+		return res.Rows()[0], nil
+	}
+	upd, err := last(b.api.NewBigmapUpdateQuery().AndEqual("hash", h))
+	if err != nil {
+		return Prim{}, false, fmt.Errorf("bigmap %d: %w", id, err)
+	}
+	// the latest alloc or copy drops all keys written before
+	base, err := last(b.api.NewBigmapUpdateQuery().AndIn("action", DiffActionAlloc, DiffActionCopy))
+	if err != nil {
+		return Prim{}, false, fmt.Errorf("bigmap %d: %w", id, err)
+	}
+	switch {
+	case upd != nil && (base == nil || upd.RowId > base.RowId):
+		if upd.Action != DiffActionUpdate {
+			return Prim{}, false, nil
+		}
+		return upd.Value, true, nil
+	case base != nil && base.Action == DiffActionCopy:
+		return b.valueAt(ctx, int64(base.KeyId), h, base.Height, base.RowId)
+	default:
+		return Prim{}, false, nil
+	}
+}
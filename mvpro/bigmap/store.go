@@ -0,0 +1,235 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package bigmap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Entry is a single bigmap key/value pair in raw Micheline form. Height is
+// the block height of the last update.
+type Entry struct {
+	Hash   ExprHash `json:"hash"`
+	Key    Prim     `json:"key"`
+	Value  Prim     `json:"value"`
+	Height int64    `json:"height"`
+}
+
+// Store holds the key/value state of a single bigmap snapshot. Range visits
+// entries in key hash order.
+type Store interface {
+	Get(ExprHash) (*Entry, error) // nil when the key does not exist
+	Put(*Entry) error
+	Delete(ExprHash) error
+	Clear() error
+	Len() int
+	Range(func(*Entry) error) error
+	Close() error
+}
+
+// StoreFactory creates an empty store for bigmap id.
+type StoreFactory func(id int64) (Store, error)
+
+// MemStoreFactory keeps snapshots in memory.
+func MemStoreFactory(int64) (Store, error) {
+	return NewMemStore(), nil
+}
+
+// FileStoreFactory keeps snapshots on disk below dir, one subdirectory per
+// bigmap and snapshot.
+func FileStoreFactory(dir string) StoreFactory {
+	var (
+		mu  sync.Mutex
+		seq int
+	)
+	return func(id int64) (Store, error) {
+		mu.Lock()
+		seq++
+		n := seq
+		mu.Unlock()
+		return NewFileStore(filepath.Join(dir, fmt.Sprintf("%d-%d", id, n)))
+	}
+}
+
+type MemStore struct {
+	m map[string]*Entry
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{m: make(map[string]*Entry)}
+}
+
+func (s *MemStore) Get(h ExprHash) (*Entry, error) {
+	return s.m[h.String()], nil
+}
+
+func (s *MemStore) Put(e *Entry) error {
+	s.m[e.Hash.String()] = e
+	return nil
+}
+
+func (s *MemStore) Delete(h ExprHash) error {
+	delete(s.m, h.String())
+	return nil
+}
+
+func (s *MemStore) Clear() error {
+	s.m = make(map[string]*Entry)
+	return nil
+}
+
+func (s *MemStore) Len() int {
+	return len(s.m)
+}
+
+func (s *MemStore) Range(fn func(*Entry) error) error {
+	keys := make([]string, 0, len(s.m))
+	for k := range s.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn(s.m[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) Close() error {
+	return nil
+}
+
+// FileStore keeps one JSON file per key in a directory so that large
+// bigmaps can be reconstructed without holding all values in memory.
+// Key hashes are base58 encoded and therefore safe to use as file names.
+type FileStore struct {
+	dir string
+	n   int
+}
+
+// NewFileStore opens or creates a file store in dir. Existing entries
+// are kept.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{dir: dir}
+	for _, v := range names {
+		if strings.HasSuffix(v.Name(), ".json") {
+			s.n++
+		}
+	}
+	return s, nil
+}
+
+func (s *FileStore) path(h ExprHash) string {
+	return filepath.Join(s.dir, h.String()+".json")
+}
+
+func (s *FileStore) Get(h ExprHash) (*Entry, error) {
+	return s.read(s.path(h))
+}
+
+func (s *FileStore) read(name string) (*Entry, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	e := &Entry{}
+	if err := json.Unmarshal(buf, e); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return e, nil
+}
+
+func (s *FileStore) Put(e *Entry) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	name := s.path(e.Hash)
+	_, err = os.Stat(name)
+	isNew := errors.Is(err, os.ErrNotExist)
+	if err := os.WriteFile(name, buf, 0644); err != nil {
+		return err
+	}
+	if isNew {
+		s.n++
+	}
+	return nil
+}
+
+func (s *FileStore) Delete(h ExprHash) error {
+	err := os.Remove(s.path(h))
+	switch {
+	case err == nil:
+		s.n--
+		return nil
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	default:
+		return err
+	}
+}
+
+func (s *FileStore) Clear() error {
+	if err := os.RemoveAll(s.dir); err != nil {
+		return err
+	}
+	s.n = 0
+	return os.MkdirAll(s.dir, 0755)
+}
+
+func (s *FileStore) Len() int {
+	return s.n
+}
+
+func (s *FileStore) Range(fn func(*Entry) error) error {
+	names, err := os.ReadDir(s.dir) // sorted by file name
+	if err != nil {
+		return err
+	}
+	for _, v := range names {
+		if !strings.HasSuffix(v.Name(), ".json") {
+			continue
+		}
+		e, err := s.read(filepath.Join(s.dir, v.Name()))
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close keeps files on disk. Call Remove to delete them.
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// Remove deletes the store directory and all entries.
+func (s *FileStore) Remove() error {
+	s.n = 0
+	return os.RemoveAll(s.dir)
+}
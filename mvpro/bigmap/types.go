@@ -0,0 +1,36 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package bigmap
+
+import (
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvgo/micheline"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+type (
+	Address  = mavryk.Address
+	ExprHash = mavryk.ExprHash
+
+	Prim      = micheline.Prim
+	Type      = micheline.Type
+	Value     = micheline.Value
+	BigmapKey = micheline.Key
+
+	ContractAPI     = index.ContractAPI
+	ContractValue   = index.ContractValue
+	BigmapUpdateRow = index.BigmapUpdateRow
+)
+
+var (
+	NewType  = micheline.NewType
+	NewValue = micheline.NewValue
+	NewKey   = micheline.NewKey
+	NewQuery = index.NewQuery
+
+	DiffActionAlloc  = micheline.DiffActionAlloc
+	DiffActionCopy   = micheline.DiffActionCopy
+	DiffActionUpdate = micheline.DiffActionUpdate
+	DiffActionRemove = micheline.DiffActionRemove
+)
@@ -0,0 +1,507 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package cluster
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/echa/log"
+)
+
+// Heuristic names the evidence that links two addresses.
+type Heuristic string
+
+const (
+	HeuristicCreator Heuristic = "creator" // contract and its originator
+	HeuristicFunding Heuristic = "funding" // first funded by the same source in a short window
+	HeuristicPayout  Heuristic = "payout"  // payout address declared by a baker
+	HeuristicAlias   Heuristic = "alias"   // same alias name in metadata
+	HeuristicManual  Heuristic = "manual"  // added by the caller
+)
+
+// Class is a coarse account category.
+type Class string
+
+const (
+	ClassBaker     Class = "baker"
+	ClassContract  Class = "contract"
+	ClassDelegator Class = "delegator"
+	ClassWallet    Class = "wallet"
+	ClassGhost     Class = "ghost" // seen but never funded
+	ClassUnknown   Class = "unknown"
+)
+
+// Classify returns the class of acc. Nil accounts are unknown.
+func Classify(acc *Account) Class {
+	switch {
+	case acc == nil:
+		return ClassUnknown
+	case acc.IsBaker:
+		return ClassBaker
+	case acc.IsContract:
+		return ClassContract
+	case acc.IsDelegated:
+		return ClassDelegator
+	case !acc.IsFunded && acc.FirstIn == 0:
+		return ClassGhost
+	default:
+		return ClassWallet
+	}
+}
+
+// Link is a piece of evidence that A and B are controlled by the same
+// entity. Weight is the probability that the link is correct.
+type Link struct {
+	A         Address   `json:"a"`
+	B         Address   `json:"b"`
+	Heuristic Heuristic `json:"heuristic"`
+	Weight    float64   `json:"weight"`
+	Reason    string    `json:"reason"`
+}
+
+// Funding is the first incoming transfer of an address.
+type Funding struct {
+	Address Address   `json:"address"`
+	Source  Address   `json:"source"`
+	Height  int64     `json:"height"`
+	Time    time.Time `json:"time"`
+	Amount  float64   `json:"amount"`
+}
+
+// Member is an address in a cluster. Confidence combines the weights of
+// all links of the address, Reasons lists them.
+type Member struct {
+	Address    Address  `json:"address"`
+	Class      Class    `json:"class"`
+	Alias      string   `json:"alias,omitempty"`
+	Confidence float64  `json:"confidence"`
+	Reasons    []string `json:"reasons"`
+}
+
+// Cluster is a group of addresses believed to be controlled by one entity.
+// Confidence is the lowest member confidence, i.e. the weakest evidence
+// that holds the cluster together.
+type Cluster struct {
+	Id         string      `json:"id"`
+	Label      string      `json:"label,omitempty"`
+	Kind       string      `json:"kind,omitempty"`
+	Confidence float64     `json:"confidence"`
+	Members    []Member    `json:"members"`
+	Heuristics []Heuristic `json:"heuristics"`
+	Links      []Link      `json:"links"`
+}
+
+// Explain returns a human readable explanation of the cluster.
+func (c *Cluster) Explain() string {
+	var b strings.Builder
+	name := c.Label
+	if name == "" {
+		name = c.Id
+	}
+	fmt.Fprintf(&b, "%s: %d addresses, confidence %.2f\n", name, len(c.Members), c.Confidence)
+	for _, l := range c.Links {
+		fmt.Fprintf(&b, "  %s - %s: %s (%s, %.2f)\n", l.A, l.B, l.Reason, l.Heuristic, l.Weight)
+	}
+	return b.String()
+}
+
+// Contains reports whether addr is a member of c.
+func (c *Cluster) Contains(addr Address) bool {
+	for _, m := range c.Members {
+		if m.Address.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Clusterer collects accounts, metadata and funding events and groups
+// addresses by heuristics. Each heuristic produces weighted links, links
+// below the minimum weight are kept as evidence but do not merge clusters.
+// Sources that fund or create more than a fan-out limit of addresses are
+// treated as services (exchanges, factories) and ignored.
+type Clusterer struct {
+	accounts  map[Address]*Account
+	meta      map[Address]*Metadata
+	fundings  []Funding
+	manual    []Link
+	window    time.Duration
+	minWeight float64
+	maxFanout int
+	weights   map[Heuristic]float64
+	log       log.Logger
+}
+
+func NewClusterer() *Clusterer {
+	return &Clusterer{
+		accounts:  make(map[Address]*Account),
+		meta:      make(map[Address]*Metadata),
+		window:    24 * time.Hour,
+		minWeight: 0.5,
+		maxFanout: 50,
+		weights: map[Heuristic]float64{
+			HeuristicCreator: 0.8,
+			HeuristicFunding: 0.6,
+			HeuristicPayout:  0.95,
+			HeuristicAlias:   0.9,
+		},
+		log: log.Disabled,
+	}
+}
+
+// WithWindow sets the time window for the common funding heuristic.
+func (c *Clusterer) WithWindow(d time.Duration) *Clusterer {
+	c.window = d
+	return c
+}
+
+// WithMinWeight sets the weight a link needs to merge clusters.
+func (c *Clusterer) WithMinWeight(w float64) *Clusterer {
+	c.minWeight = w
+	return c
+}
+
+// WithMaxFanout sets how many addresses a creator or funding source may
+// link before it is considered a service.
+func (c *Clusterer) WithMaxFanout(n int) *Clusterer {
+	c.maxFanout = n
+	return c
+}
+
+// WithWeight overrides the link weight of heuristic h.
+func (c *Clusterer) WithWeight(h Heuristic, w float64) *Clusterer {
+	c.weights[h] = w
+	return c
+}
+
+func (c *Clusterer) WithLogger(l log.Logger) *Clusterer {
+	c.log = l
+	return c
+}
+
+// AddAccounts adds account data used for classification and the creator
+// heuristic.
+func (c *Clusterer) AddAccounts(accs ...*Account) *Clusterer {
+	for _, a := range accs {
+		if a != nil {
+			c.accounts[a.Address] = a
+		}
+	}
+	return c
+}
+
+// AddMetadata adds wallet metadata used for aliases and payouts.
+func (c *Clusterer) AddMetadata(mds ...Metadata) *Clusterer {
+	for i := range mds {
+		if mds[i].TokenId != nil {
+			continue
+		}
+		md := mds[i]
+		c.meta[md.Address] = &md
+	}
+	return c
+}
+
+// AddFunding adds first funding events.
+func (c *Clusterer) AddFunding(f ...Funding) *Clusterer {
+	c.fundings = append(c.fundings, f...)
+	return c
+}
+
+// AddLink adds a manual link, e.g. from an investigation.
+func (c *Clusterer) AddLink(a, b Address, weight float64, reason string) *Clusterer {
+	c.manual = append(c.manual, Link{A: a, B: b, Heuristic: HeuristicManual, Weight: weight, Reason: reason})
+	return c
+}
+
+// Links returns the evidence produced by all heuristics.
+func (c *Clusterer) Links() []Link {
+	var links []Link
+	links = append(links, c.creatorLinks()...)
+	links = append(links, c.fundingLinks()...)
+	links = append(links, c.payoutLinks()...)
+	links = append(links, c.aliasLinks()...)
+	links = append(links, c.manual...)
+	return links
+}
+
+// creatorLinks links contracts to their originator. Originators that are
+// contracts themselves (factories) or created too many contracts are
+// skipped.
+func (c *Clusterer) creatorLinks() []Link {
+	byCreator := make(map[Address][]Address)
+	for _, a := range c.accounts {
+		if a.IsContract && a.Creator != nil && a.Creator.IsValid() {
+			byCreator[*a.Creator] = append(byCreator[*a.Creator], a.Address)
+		}
+	}
+	var links []Link
+	for creator, contracts := range byCreator {
+		if len(contracts) > c.maxFanout {
+			c.log.Debugf("cluster: skip creator %s with %d contracts", creator, len(contracts))
+			continue
+		}
+		if creator.IsContract() {
+			continue
+		}
+		for _, v := range contracts {
+			links = append(links, Link{
+				A:         creator,
+				B:         v,
+				Heuristic: HeuristicCreator,
+				Weight:    c.weights[HeuristicCreator],
+				Reason:    fmt.Sprintf("contract originated by %s", creator),
+			})
+		}
+	}
+	return links
+}
+
+// fundingLinks links addresses whose first funding came from the same
+// source within the configured window. The source itself is not linked
+// because it is often a service.
+func (c *Clusterer) fundingLinks() []Link {
+	bySource := make(map[Address][]Funding)
+	for _, f := range c.fundings {
+		if !f.Source.IsValid() || c.isService(f.Source) {
+			continue
+		}
+		bySource[f.Source] = append(bySource[f.Source], f)
+	}
+	var links []Link
+	for src, list := range bySource {
+		if len(list) > c.maxFanout {
+			c.log.Debugf("cluster: skip funding source %s with %d targets", src, len(list))
+			continue
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+		for i := 1; i < len(list); i++ {
+			prev, cur := list[i-1], list[i]
+			dt := cur.Time.Sub(prev.Time)
+			if dt > c.window {
+				continue
+			}
+			// closer funding is stronger evidence
+			w := c.weights[HeuristicFunding] * (1 - 0.5*float64(dt)/float64(c.window+1))
+			links = append(links, Link{
+				A:         prev.Address,
+				B:         cur.Address,
+				Heuristic: HeuristicFunding,
+				Weight:    w,
+				Reason:    fmt.Sprintf("first funded by %s within %s", src, dt.Round(time.Second)),
+			})
+		}
+	}
+	return links
+}
+
+// payoutLinks links bakers to their declared payout addresses.
+func (c *Clusterer) payoutLinks() []Link {
+	var links []Link
+	for addr, md := range c.meta {
+		if !md.Has("payout") {
+			continue
+		}
+		for _, p := range *md.Payout() {
+			if !p.IsValid() || p.Equal(addr) {
+				continue
+			}
+			links = append(links, Link{
+				A:         addr,
+				B:         p,
+				Heuristic: HeuristicPayout,
+				Weight:    c.weights[HeuristicPayout],
+				Reason:    "declared payout address",
+			})
+		}
+	}
+	return links
+}
+
+// aliasLinks links addresses with the same alias name.
+func (c *Clusterer) aliasLinks() []Link {
+	byName := make(map[string][]Address)
+	for addr, md := range c.meta {
+		if name := aliasKey(md); name != "" {
+			byName[name] = append(byName[name], addr)
+		}
+	}
+	var links []Link
+	for _, list := range byName {
+		sort.Slice(list, func(i, j int) bool { return list[i].String() < list[j].String() })
+		for i := 1; i < len(list); i++ {
+			links = append(links, Link{
+				A:         list[0],
+				B:         list[i],
+				Heuristic: HeuristicAlias,
+				Weight:    c.weights[HeuristicAlias],
+				Reason:    fmt.Sprintf("shared alias %q", c.meta[list[0]].Alias().Name),
+			})
+		}
+	}
+	return links
+}
+
+func aliasKey(md *Metadata) string {
+	if !md.Has("alias") {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(md.Alias().Name))
+}
+
+func (c *Clusterer) alias(addr Address) (name, kind string) {
+	if md, ok := c.meta[addr]; ok && md.Has("alias") {
+		a := md.Alias()
+		return a.Name, a.Kind
+	}
+	return "", ""
+}
+
+// isService reports whether addr is a known exchange or other service.
+func (c *Clusterer) isService(addr Address) bool {
+	_, kind := c.alias(addr)
+	switch strings.ToLower(kind) {
+	case "exchange", "bridge", "service", "faucet":
+		return true
+	}
+	return false
+}
+
+// Run groups addresses and returns clusters with at least two members,
+// largest first.
+func (c *Clusterer) Run() []*Cluster {
+	links := c.Links()
+	uf := newUnionFind()
+	for _, l := range links {
+		if l.Weight >= c.minWeight {
+			uf.union(l.A, l.B)
+		}
+	}
+	groups := make(map[Address][]Address)
+	for a := range uf.parent {
+		root := uf.find(a)
+		groups[root] = append(groups[root], a)
+	}
+	linksByRoot := make(map[Address][]Link)
+	for _, l := range links {
+		if l.Weight < c.minWeight {
+			continue
+		}
+		root := uf.find(l.A)
+		linksByRoot[root] = append(linksByRoot[root], l)
+	}
+
+	res := make([]*Cluster, 0, len(groups))
+	for root, addrs := range groups {
+		if len(addrs) < 2 {
+			continue
+		}
+		res = append(res, c.build(addrs, linksByRoot[root]))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if len(res[i].Members) != len(res[j].Members) {
+			return len(res[i].Members) > len(res[j].Members)
+		}
+		return res[i].Id < res[j].Id
+	})
+	return res
+}
+
+func (c *Clusterer) build(addrs []Address, links []Link) *Cluster {
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].String() < addrs[j].String() })
+	sort.SliceStable(links, func(i, j int) bool { return links[i].Weight > links[j].Weight })
+	cl := &Cluster{
+		Id:         "c-" + addrs[0].String(),
+		Confidence: 1,
+		Members:    make([]Member, 0, len(addrs)),
+		Links:      links,
+	}
+	seen := make(map[Heuristic]bool)
+	for _, l := range links {
+		if !seen[l.Heuristic] {
+			seen[l.Heuristic] = true
+			cl.Heuristics = append(cl.Heuristics, l.Heuristic)
+		}
+	}
+	var labelWeight float64
+	for _, a := range addrs {
+		miss := 1.0
+		m := Member{Address: a, Class: Classify(c.accounts[a])}
+		for _, l := range links {
+			if l.A.Equal(a) || l.B.Equal(a) {
+				miss *= 1 - l.Weight
+				m.Reasons = append(m.Reasons, l.Reason)
+			}
+		}
+		m.Confidence = round(1 - miss)
+		m.Alias, _ = c.alias(a)
+		cl.Confidence = math.Min(cl.Confidence, m.Confidence)
+		cl.Members = append(cl.Members, m)
+
+		// label by the most confident aliased member, bakers first
+		if m.Alias != "" {
+			w := m.Confidence
+			if m.Class == ClassBaker {
+				w += 1
+			}
+			if w > labelWeight {
+				labelWeight = w
+				cl.Label = m.Alias
+				_, cl.Kind = c.alias(a)
+			}
+		}
+	}
+	if cl.Kind == "" {
+		for _, m := range cl.Members {
+			if m.Class == ClassBaker {
+				cl.Kind = string(ClassBaker)
+				break
+			}
+		}
+	}
+	return cl
+}
+
+func round(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
+
+type unionFind struct {
+	parent map[Address]Address
+}
+
+func newUnionFind() *unionFind {
+	return &unionFind{parent: make(map[Address]Address)}
+}
+
+func (u *unionFind) find(a Address) Address {
+	p, ok := u.parent[a]
+	if !ok {
+		u.parent[a] = a
+		return a
+	}
+	if p == a {
+		return a
+	}
+	root := u.find(p)
+	u.parent[a] = root
+	return root
+}
+
+func (u *unionFind) union(a, b Address) {
+	ra, rb := u.find(a), u.find(b)
+	if ra == rb {
+		return
+	}
+	// deterministic roots
+	if ra.String() < rb.String() {
+		u.parent[rb] = ra
+	} else {
+		u.parent[ra] = rb
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package cluster
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SourceAuto   = "auto"
+	SourceManual = "manual"
+)
+
+// Label attributes an address to an entity.
+type Label struct {
+	Address    Address     `json:"address"`
+	Cluster    string      `json:"cluster,omitempty"`
+	Label      string      `json:"label,omitempty"`
+	Kind       string      `json:"kind,omitempty"`
+	Class      Class       `json:"class,omitempty"`
+	Confidence float64     `json:"confidence"`
+	Heuristics []Heuristic `json:"heuristics,omitempty"`
+	Source     string      `json:"source"`
+	Updated    time.Time   `json:"updated"`
+}
+
+// LabelSet is a set of labels by address.
+type LabelSet map[Address]Label
+
+// Labels converts clusters into per-address labels.
+func Labels(clusters []*Cluster) LabelSet {
+	now := time.Now().UTC().Truncate(time.Second)
+	s := make(LabelSet)
+	for _, c := range clusters {
+		for _, m := range c.Members {
+			s[m.Address] = Label{
+				Address:    m.Address,
+				Cluster:    c.Id,
+				Label:      c.Label,
+				Kind:       c.Kind,
+				Class:      m.Class,
+				Confidence: m.Confidence,
+				Heuristics: c.Heuristics,
+				Source:     SourceAuto,
+				Updated:    now,
+			}
+		}
+	}
+	return s
+}
+
+// Set adds a manual label which is never replaced by automatic labels.
+func (s LabelSet) Set(addr Address, label, kind string) {
+	s[addr] = Label{
+		Address:    addr,
+		Label:      label,
+		Kind:       kind,
+		Confidence: 1,
+		Source:     SourceManual,
+		Updated:    time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// Merge adds labels from o. Manual labels are kept, otherwise the label
+// with higher confidence wins.
+func (s LabelSet) Merge(o LabelSet) LabelSet {
+	for addr, l := range o {
+		cur, ok := s[addr]
+		switch {
+		case !ok:
+			s[addr] = l
+		case cur.Source == SourceManual && l.Source != SourceManual:
+		case l.Source == SourceManual && cur.Source != SourceManual:
+			s[addr] = l
+		case l.Confidence >= cur.Confidence:
+			s[addr] = l
+		}
+	}
+	return s
+}
+
+// List returns labels sorted by cluster and address.
+func (s LabelSet) List() []Label {
+	list := make([]Label, 0, len(s))
+	for _, l := range s {
+		list = append(list, l)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Cluster != list[j].Cluster {
+			return list[i].Cluster < list[j].Cluster
+		}
+		return list[i].Address.String() < list[j].Address.String()
+	})
+	return list
+}
+
+// WriteJSON writes labels as a JSON array.
+func (s LabelSet) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s.List())
+}
+
+// WriteCSV writes one row per address.
+func (s LabelSet) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"address", "cluster", "label", "kind", "class", "confidence", "heuristics", "source", "updated"})
+	for _, l := range s.List() {
+		h := make([]string, len(l.Heuristics))
+		for i, v := range l.Heuristics {
+			h[i] = string(v)
+		}
+		cw.Write([]string{
+			l.Address.String(),
+			l.Cluster,
+			l.Label,
+			l.Kind,
+			string(l.Class),
+			strconv.FormatFloat(l.Confidence, 'f', 3, 64),
+			strings.Join(h, ";"),
+			l.Source,
+			l.Updated.Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadLabels reads labels written by WriteJSON.
+func ReadLabels(r io.Reader) (LabelSet, error) {
+	var list []Label
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return nil, err
+	}
+	s := make(LabelSet, len(list))
+	for _, l := range list {
+		if !l.Address.IsValid() {
+			return nil, fmt.Errorf("invalid label address")
+		}
+		s[l.Address] = l
+	}
+	return s, nil
+}
+
+// LoadLabels reads a label file. A missing file yields an empty set.
+func LoadLabels(name string) (LabelSet, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(LabelSet), nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	s, err := ReadLabels(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return s, nil
+}
+
+// SaveFile writes labels to file name atomically.
+func (s LabelSet) SaveFile(name string) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := s.WriteJSON(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+// Load fetches accounts and first funding transfers of addrs through the
+// account API and all wallet metadata through the metadata API. md may be
+// nil to skip the alias and payout heuristics.
+func (c *Clusterer) Load(ctx context.Context, accounts AccountAPI, md MetadataAPI, addrs ...Address) error {
+	for _, addr := range addrs {
+		acc, err := accounts.Get(ctx, addr, index.NewQuery())
+		if err != nil {
+			return fmt.Errorf("account %s: %w", addr, err)
+		}
+		c.AddAccounts(acc)
+		if acc.FirstIn <= 0 {
+			continue
+		}
+		f, ok, err := firstFunding(ctx, accounts, acc)
+		if err != nil {
+			return fmt.Errorf("funding %s: %w", addr, err)
+		}
+		if ok {
+			c.AddFunding(f)
+		}
+	}
+	if md != nil {
+		list, err := md.List(ctx)
+		if err != nil {
+			return fmt.Errorf("metadata: %w", err)
+		}
+		c.AddMetadata(list...)
+	}
+	c.log.Debugf("cluster: loaded %d accounts, %d fundings, %d metadata", len(c.accounts), len(c.fundings), len(c.meta))
+	return nil
+}
+
+// firstFunding returns the largest incoming balance flow at the first
+// inbound height of acc.
+func firstFunding(ctx context.Context, api AccountAPI, acc *Account) (Funding, bool, error) {
+	res, err := api.NewFlowQuery().
+		AndEqual("address", acc.Address).
+		AndEqual("height", acc.FirstIn).
+		AndGt("amount_in", 0).
+		WithLimit(100).
+		Run(ctx)
+	if err != nil {
+		return Funding{}, false, err
+	}
+	var (
+		best  Funding
+		found bool
+	)
+	for _, f := range res.Rows() {
+		if f.Kind != "balance" || !f.CounterParty.IsValid() || f.AmountIn <= best.Amount {
+			continue
+		}
+		best = Funding{
+			Address: acc.Address,
+			Source:  f.CounterParty,
+			Height:  f.Height,
+			Time:    f.Timestamp,
+			Amount:  f.AmountIn,
+		}
+		found = true
+	}
+	return best, found, nil
+}
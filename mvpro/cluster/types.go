@@ -0,0 +1,17 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package cluster
+
+import (
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+type (
+	Address     = mavryk.Address
+	Account     = index.Account
+	Metadata    = index.Metadata
+	AccountAPI  = index.AccountAPI
+	MetadataAPI = index.MetadataAPI
+)
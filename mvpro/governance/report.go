@@ -0,0 +1,217 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package governance
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SupermajorityPct is the share of yay stake over yay and nay stake required
+// for a ballot period to succeed.
+const SupermajorityPct = 80.0
+
+// PeriodKinds lists voting period names in election stage order.
+var PeriodKinds = []string{"proposal", "exploration", "cooldown", "promotion", "adoption"}
+
+type Outcome string
+
+const (
+	OutcomeUnknown     Outcome = ""
+	OutcomePassing     Outcome = "passing"      // would pass if the period ended now
+	OutcomeFailing     Outcome = "failing"      // would fail if the period ended now
+	OutcomeCertainPass Outcome = "certain_pass" // passes even if all undecided stake votes against
+	OutcomeCertainFail Outcome = "certain_fail" // fails even if all undecided stake votes in favor
+	OutcomePassed      Outcome = "passed"
+	OutcomeFailed      Outcome = "failed"
+)
+
+type ProposalStatus struct {
+	Hash      string  `json:"hash"`
+	Source    Address `json:"source"`
+	Stake     float64 `json:"stake"`
+	Voters    int64   `json:"voters"`
+	StakePct  float64 `json:"stake_pct"`
+	IsLeading bool    `json:"is_leading"`
+}
+
+// Report summarizes the state of a single voting period.
+type Report struct {
+	ElectionId     int              `json:"election_id"`
+	Stage          int              `json:"stage"`
+	Period         string           `json:"period"`
+	IsOpen         bool             `json:"is_open"`
+	StartHeight    int64            `json:"start_height"`
+	EndHeight      int64            `json:"end_height"`
+	StartTime      time.Time        `json:"start_time"`
+	EndTime        time.Time        `json:"end_time"`
+	EligibleVoters int              `json:"eligible_voters"`
+	EligibleStake  float64          `json:"eligible_stake"`
+	TurnoutVoters  int              `json:"turnout_voters"`
+	TurnoutStake   float64          `json:"turnout_stake"`
+	TurnoutPct     float64          `json:"turnout_pct"`
+	QuorumPct      float64          `json:"quorum_pct"`
+	QuorumReached  bool             `json:"quorum_reached"`
+	YayStake       float64          `json:"yay_stake"`
+	NayStake       float64          `json:"nay_stake"`
+	PassStake      float64          `json:"pass_stake"`
+	YayPct         float64          `json:"yay_pct"`
+	Supermajority  bool             `json:"supermajority"`
+	UndecidedStake float64          `json:"undecided_stake"`
+	Undecided      []Voter          `json:"undecided"`
+	Proposals      []ProposalStatus `json:"proposals,omitempty"`
+	Outcome        Outcome          `json:"outcome"`
+}
+
+// Analyze builds a report for election stage from the period's vote summary
+// and the list of eligible voters. QuorumPct on the API is expressed in
+// hundredths of a percent.
+func Analyze(e *Election, stage int, voters []Voter) (*Report, error) {
+	if stage < 0 || stage >= len(PeriodKinds) {
+		return nil, fmt.Errorf("invalid election stage %d", stage)
+	}
+	kind := PeriodKinds[stage]
+	v := e.Period(kind)
+	if v == nil {
+		return nil, fmt.Errorf("election %d has no %s period", e.Id, kind)
+	}
+	r := &Report{
+		ElectionId:  e.Id,
+		Stage:       stage,
+		Period:      kind,
+		IsOpen:      v.IsOpen,
+		StartHeight: v.StartHeight,
+		EndHeight:   v.EndHeight,
+		StartTime:   v.StartTime,
+		EndTime:     v.EndTime,
+		QuorumPct:   float64(v.QuorumPct) / 100,
+		YayStake:    v.YayStake,
+		NayStake:    v.NayStake,
+		PassStake:   v.PassStake,
+		Undecided:   make([]Voter, 0),
+	}
+	for _, vv := range voters {
+		r.EligibleVoters++
+		r.EligibleStake += vv.Stake
+		if vv.HasVoted {
+			r.TurnoutVoters++
+			r.TurnoutStake += vv.Stake
+		} else {
+			r.UndecidedStake += vv.Stake
+			r.Undecided = append(r.Undecided, vv)
+		}
+	}
+	sort.SliceStable(r.Undecided, func(i, j int) bool {
+		return r.Undecided[i].Stake > r.Undecided[j].Stake
+	})
+	r.TurnoutPct = pct(r.TurnoutStake, r.EligibleStake)
+
+	switch kind {
+	case "proposal":
+		r.analyzeProposals(v)
+	case "cooldown":
+		// no vote
+	default:
+		r.analyzeBallots(v)
+	}
+	return r, nil
+}
+
+func (r *Report) analyzeBallots(v *Vote) {
+	r.QuorumReached = r.TurnoutPct >= r.QuorumPct
+	r.YayPct = pct(r.YayStake, r.YayStake+r.NayStake)
+	r.Supermajority = r.YayPct >= SupermajorityPct
+
+	if !r.IsOpen {
+		if v.IsFailed || v.NoQuorum || v.NoMajority {
+			r.Outcome = OutcomeFailed
+		} else {
+			r.Outcome = OutcomePassed
+		}
+		return
+	}
+
+	// best case: all undecided vote yay, worst case: all undecided vote nay
+	quorumMax := pct(r.TurnoutStake+r.UndecidedStake, r.EligibleStake) >= r.QuorumPct
+	bestPct := pct(r.YayStake+r.UndecidedStake, r.YayStake+r.NayStake+r.UndecidedStake)
+	worstPct := pct(r.YayStake, r.YayStake+r.NayStake+r.UndecidedStake)
+	switch {
+	case !quorumMax || bestPct < SupermajorityPct:
+		r.Outcome = OutcomeCertainFail
+	case r.QuorumReached && worstPct >= SupermajorityPct:
+		r.Outcome = OutcomeCertainPass
+	case r.QuorumReached && r.Supermajority:
+		r.Outcome = OutcomePassing
+	default:
+		r.Outcome = OutcomeFailing
+	}
+}
+
+func (r *Report) analyzeProposals(v *Vote) {
+	r.Proposals = make([]ProposalStatus, 0, len(v.Proposals))
+	for _, p := range v.Proposals {
+		r.Proposals = append(r.Proposals, ProposalStatus{
+			Hash:     p.Hash,
+			Source:   p.SourceAddress,
+			Stake:    p.Stake,
+			Voters:   p.Voters,
+			StakePct: pct(p.Stake, r.EligibleStake),
+		})
+	}
+	sort.SliceStable(r.Proposals, func(i, j int) bool {
+		return r.Proposals[i].Stake > r.Proposals[j].Stake
+	})
+	var isDraw bool
+	if len(r.Proposals) > 0 {
+		r.Proposals[0].IsLeading = true
+		isDraw = len(r.Proposals) > 1 && r.Proposals[0].Stake == r.Proposals[1].Stake
+		r.QuorumReached = r.Proposals[0].StakePct >= r.QuorumPct
+	}
+
+	if !r.IsOpen {
+		if v.IsFailed || v.NoProposal || v.NoQuorum || v.IsDraw {
+			r.Outcome = OutcomeFailed
+		} else {
+			r.Outcome = OutcomePassed
+		}
+		return
+	}
+
+	switch {
+	case len(r.Proposals) == 0:
+		r.Outcome = OutcomeFailing
+	case r.QuorumReached && !isDraw:
+		r.Outcome = OutcomePassing
+	default:
+		r.Outcome = OutcomeFailing
+	}
+}
+
+// String renders a short plain text summary.
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Election %d %s period (%d-%d) open=%t\n", r.ElectionId, r.Period, r.StartHeight, r.EndHeight, r.IsOpen)
+	fmt.Fprintf(&b, "  Turnout    %6.2f%% of %.0f stake (%d/%d voters), quorum %.2f%% reached=%t\n",
+		r.TurnoutPct, r.EligibleStake, r.TurnoutVoters, r.EligibleVoters, r.QuorumPct, r.QuorumReached)
+	if len(r.Proposals) > 0 {
+		for _, p := range r.Proposals {
+			fmt.Fprintf(&b, "  Proposal   %s %6.2f%% (%d voters) leading=%t\n", p.Hash, p.StakePct, p.Voters, p.IsLeading)
+		}
+	} else if r.Period != "proposal" && r.Period != "cooldown" {
+		fmt.Fprintf(&b, "  Ballots    yay %.0f nay %.0f pass %.0f, yay %.2f%% supermajority=%t\n",
+			r.YayStake, r.NayStake, r.PassStake, r.YayPct, r.Supermajority)
+	}
+	fmt.Fprintf(&b, "  Undecided  %.0f stake in %d voters\n", r.UndecidedStake, len(r.Undecided))
+	fmt.Fprintf(&b, "  Outcome    %s\n", r.Outcome)
+	return b.String()
+}
+
+func pct(x, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return x * 100 / total
+}
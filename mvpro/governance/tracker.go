@@ -0,0 +1,165 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package governance
+
+import (
+	"context"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventPeriodStarted  EventType = "period_started"
+	EventPeriodClosed   EventType = "period_closed"
+	EventBallot         EventType = "ballot"
+	EventProposal       EventType = "proposal"
+	EventUpvote         EventType = "upvote"
+	EventQuorumReached  EventType = "quorum_reached"
+	EventSupermajority  EventType = "supermajority"
+	EventOutcomeChanged EventType = "outcome_changed"
+)
+
+// Event signals a change in an open voting period. Ballot and Proposal are
+// set depending on event type, Report always holds the latest state.
+type Event struct {
+	Type     EventType       `json:"type"`
+	Ballot   *Ballot         `json:"ballot,omitempty"`
+	Proposal *ProposalStatus `json:"proposal,omitempty"`
+	Report   *Report         `json:"report"`
+}
+
+type EventHandler func(Event)
+
+// Tracker polls an election and emits change events while its current
+// voting period is open.
+type Tracker struct {
+	api      ExplorerAPI
+	id       int
+	interval time.Duration
+	handler  EventHandler
+	last     *Report
+	ballots  map[uint64]bool
+}
+
+func NewTracker(api ExplorerAPI, id int) *Tracker {
+	return &Tracker{
+		api:      api,
+		id:       id,
+		interval: time.Minute,
+		handler:  func(Event) {},
+		ballots:  make(map[uint64]bool),
+	}
+}
+
+func (t *Tracker) WithInterval(d time.Duration) *Tracker {
+	t.interval = d
+	return t
+}
+
+func (t *Tracker) WithHandler(fn EventHandler) *Tracker {
+	t.handler = fn
+	return t
+}
+
+// Report returns the most recent report or nil before the first update.
+func (t *Tracker) Report() *Report {
+	return t.last
+}
+
+// Run polls until the election is closed or ctx is canceled.
+func (t *Tracker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		e, err := t.Update(ctx)
+		if err != nil {
+			return err
+		}
+		if !e.IsOpen {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Update loads the current election state once and emits events for all
+// changes since the previous update.
+func (t *Tracker) Update(ctx context.Context) (*Election, error) {
+	e, err := t.api.GetElection(ctx, t.id)
+	if err != nil {
+		return nil, err
+	}
+	stage := e.NumPeriods - 1
+	if stage < 0 {
+		return e, nil
+	}
+	voters, err := t.api.ListVoters(ctx, t.id, stage)
+	if err != nil {
+		return nil, err
+	}
+	r, err := Analyze(e, stage, voters)
+	if err != nil {
+		return nil, err
+	}
+	prev := t.last
+	t.last = r
+
+	if prev == nil || prev.Stage != r.Stage {
+		t.ballots = make(map[uint64]bool)
+		t.handler(Event{Type: EventPeriodStarted, Report: r})
+		prev = nil
+	}
+
+	if r.Period != "proposal" && r.Period != "cooldown" {
+		ballots, err := t.api.ListBallots(ctx, t.id, stage)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range ballots {
+			if t.ballots[b.RowId] {
+				continue
+			}
+			t.ballots[b.RowId] = true
+			t.handler(Event{Type: EventBallot, Ballot: b, Report: r})
+		}
+	}
+
+	if prev != nil {
+		t.diffProposals(prev, r)
+		if r.QuorumReached && !prev.QuorumReached {
+			t.handler(Event{Type: EventQuorumReached, Report: r})
+		}
+		if r.Supermajority != prev.Supermajority {
+			t.handler(Event{Type: EventSupermajority, Report: r})
+		}
+		if r.Outcome != prev.Outcome {
+			t.handler(Event{Type: EventOutcomeChanged, Report: r})
+		}
+		if !r.IsOpen && prev.IsOpen {
+			t.handler(Event{Type: EventPeriodClosed, Report: r})
+		}
+	}
+	return e, nil
+}
+
+func (t *Tracker) diffProposals(prev, r *Report) {
+	known := make(map[string]ProposalStatus, len(prev.Proposals))
+	for _, p := range prev.Proposals {
+		known[p.Hash] = p
+	}
+	for i, p := range r.Proposals {
+		old, ok := known[p.Hash]
+		switch {
+		case !ok:
+			t.handler(Event{Type: EventProposal, Proposal: &r.Proposals[i], Report: r})
+		case p.Stake != old.Stake || p.Voters != old.Voters:
+			t.handler(Event{Type: EventUpvote, Proposal: &r.Proposals[i], Report: r})
+		}
+	}
+}
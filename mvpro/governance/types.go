@@ -0,0 +1,21 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package governance
+
+import (
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+type (
+	Address = mavryk.Address
+
+	Election    = index.Election
+	Vote        = index.Vote
+	Voter       = index.Voter
+	Ballot      = index.Ballot
+	BallotList  = index.BallotList
+	Proposal    = index.Proposal
+	ExplorerAPI = index.ExplorerAPI
+)
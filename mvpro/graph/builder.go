@@ -0,0 +1,343 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package graph
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/echa/log"
+	"github.com/mavryk-network/mvgo/mavryk"
+)
+
+// Filter reports whether an address should be excluded from the graph.
+// md is nil for addresses without metadata.
+type Filter func(addr Address, md *Metadata) bool
+
+// Builder expands a graph from seed addresses over balance flows and
+// token transfers.
+type Builder struct {
+	accounts     AccountAPI
+	wallets      WalletAPI
+	hops         int
+	from, to     time.Time
+	maxNeighbors int
+	maxNodes     int
+	batch        int
+	meta         map[Address]*Metadata
+	exclude      map[Address]bool
+	filters      []Filter
+	log          log.Logger
+}
+
+// NewBuilder creates a graph builder. accounts is used for tez flows and
+// wallets for token transfers, either may be nil.
+func NewBuilder(accounts AccountAPI, wallets WalletAPI) *Builder {
+	return &Builder{
+		accounts:     accounts,
+		wallets:      wallets,
+		hops:         1,
+		maxNeighbors: 100,
+		maxNodes:     5000,
+		batch:        500,
+		meta:         make(map[Address]*Metadata),
+		exclude:      make(map[Address]bool),
+		log:          log.Disabled,
+	}
+}
+
+// WithHops sets the number of hops to expand from seeds, 1 by default.
+func (b *Builder) WithHops(n int) *Builder {
+	b.hops = n
+	return b
+}
+
+// WithWindow limits transfers to [from, to]. Zero times are open ends.
+func (b *Builder) WithWindow(from, to time.Time) *Builder {
+	b.from, b.to = from, to
+	return b
+}
+
+// WithMaxNeighbors sets the number of distinct counterparties above which
+// a non-seed address is treated as a hub and not expanded further.
+func (b *Builder) WithMaxNeighbors(n int) *Builder {
+	b.maxNeighbors = n
+	return b
+}
+
+// WithMaxNodes stops expansion once the graph reaches n nodes.
+func (b *Builder) WithMaxNodes(n int) *Builder {
+	b.maxNodes = n
+	return b
+}
+
+// WithBatchSize sets the page size of API queries.
+func (b *Builder) WithBatchSize(n int) *Builder {
+	b.batch = n
+	return b
+}
+
+// WithMetadata adds address metadata used for node labels and filters.
+func (b *Builder) WithMetadata(list ...Metadata) *Builder {
+	for i := range list {
+		b.meta[list[i].Address] = &list[i]
+	}
+	return b
+}
+
+// WithFilter adds a custom address filter.
+func (b *Builder) WithFilter(f Filter) *Builder {
+	b.filters = append(b.filters, f)
+	return b
+}
+
+// Exclude removes addrs from the graph.
+func (b *Builder) Exclude(addrs ...Address) *Builder {
+	for _, a := range addrs {
+		b.exclude[a] = true
+	}
+	return b
+}
+
+// ExcludeBakers removes addresses with baker metadata or a validator alias.
+func (b *Builder) ExcludeBakers() *Builder {
+	return b.WithFilter(func(_ Address, md *Metadata) bool {
+		if md == nil {
+			return false
+		}
+		if md.Has("baker") {
+			return true
+		}
+		if md.Has("alias") {
+			switch strings.ToLower(md.Alias().Kind) {
+			case "validator", "baker":
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// ExcludeKinds removes addresses whose alias kind matches one of kinds,
+// e.g. exchange or bridge.
+func (b *Builder) ExcludeKinds(kinds ...string) *Builder {
+	set := make(map[string]bool, len(kinds))
+	for _, k := range kinds {
+		set[strings.ToLower(k)] = true
+	}
+	return b.WithFilter(func(_ Address, md *Metadata) bool {
+		return md != nil && md.Has("alias") && set[strings.ToLower(md.Alias().Kind)]
+	})
+}
+
+func (b *Builder) WithLogger(l log.Logger) *Builder {
+	b.log = l
+	return b
+}
+
+func (b *Builder) excluded(addr Address) bool {
+	if b.exclude[addr] {
+		return true
+	}
+	md := b.meta[addr]
+	for _, f := range b.filters {
+		if f(addr, md) {
+			return true
+		}
+	}
+	return false
+}
+
+// Build expands the graph breadth first from seeds. Transfers are
+// recorded from the side that is expanded first, so each transfer is
+// counted once. Edges between addresses at the last hop are not loaded.
+func (b *Builder) Build(ctx context.Context, seeds ...Address) (*Graph, error) {
+	g := New()
+	frontier := make([]Address, 0, len(seeds))
+	for _, a := range seeds {
+		if !a.IsValid() || g.Node(a) != nil {
+			continue
+		}
+		b.addNode(g, a, 0).Seed = true
+		frontier = append(frontier, a)
+	}
+	expanded := make(map[Address]bool)
+	for hop := 0; hop < b.hops && len(frontier) > 0; hop++ {
+		var next []Address
+		for _, addr := range frontier {
+			if b.maxNodes > 0 && g.NumNodes() >= b.maxNodes {
+				b.log.Warnf("graph: node limit %d reached at hop %d", b.maxNodes, hop)
+				return g, nil
+			}
+			list, err := b.transfers(ctx, addr)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", addr, err)
+			}
+			expanded[addr] = true
+
+			// keep only transfers to addresses not yet expanded
+			peers := make(map[Address]bool)
+			keep := list[:0]
+			for _, t := range list {
+				peer := t.From
+				if peer.Equal(addr) {
+					peer = t.To
+				}
+				if peer.Equal(addr) || expanded[peer] || b.excluded(peer) {
+					continue
+				}
+				peers[peer] = true
+				keep = append(keep, t)
+			}
+
+			// hubs only connect to nodes already in the graph
+			n := g.Node(addr)
+			if !n.Seed && b.maxNeighbors > 0 && len(peers) > b.maxNeighbors {
+				n.Hub = true
+				b.log.Debugf("graph: %s is a hub with %d peers", addr, len(peers))
+			}
+			for _, t := range keep {
+				peer := t.From
+				if peer.Equal(addr) {
+					peer = t.To
+				}
+				if g.Node(peer) == nil {
+					if n.Hub {
+						continue
+					}
+					b.addNode(g, peer, hop+1)
+					next = append(next, peer)
+				}
+				g.Add(t)
+			}
+		}
+		b.log.Debugf("graph: hop %d done, %d nodes %d edges", hop, g.NumNodes(), g.NumEdges())
+		frontier = next
+	}
+	return g, nil
+}
+
+func (b *Builder) addNode(g *Graph, addr Address, hop int) *Node {
+	n := g.AddNode(addr, hop)
+	if md := b.meta[addr]; md != nil && md.Has("alias") {
+		a := md.Alias()
+		n.Label, n.Kind = a.Name, a.Kind
+	}
+	return n
+}
+
+func (b *Builder) inWindow(t time.Time) bool {
+	return (b.from.IsZero() || !t.Before(b.from)) && (b.to.IsZero() || !t.After(b.to))
+}
+
+// transfers loads all tez and token transfers of addr.
+func (b *Builder) transfers(ctx context.Context, addr Address) ([]Transfer, error) {
+	var list []Transfer
+	if b.accounts != nil {
+		flows, err := b.flows(ctx, addr)
+		if err != nil {
+			return nil, fmt.Errorf("loading flows: %w", err)
+		}
+		for _, f := range flows {
+			if f.IsFee || f.IsBurned || !f.CounterParty.IsValid() || !b.inWindow(f.Timestamp) {
+				continue
+			}
+			t := Transfer{
+				Asset:  AssetTez,
+				Height: f.Height,
+				Time:   f.Timestamp,
+			}
+			switch {
+			case f.AmountOut > 0:
+				t.From, t.To, t.Amount = addr, f.CounterParty, f.AmountOut
+			case f.AmountIn > 0:
+				t.From, t.To, t.Amount = f.CounterParty, addr, f.AmountIn
+			default:
+				continue
+			}
+			list = append(list, t)
+		}
+	}
+	if b.wallets != nil {
+		events, err := b.tokenEvents(ctx, addr)
+		if err != nil {
+			return nil, fmt.Errorf("loading token events: %w", err)
+		}
+		for _, ev := range events {
+			if ev.EventType != "transfer" || !ev.Sender.IsValid() || !ev.Receiver.IsValid() || !b.inWindow(ev.Time) {
+				continue
+			}
+			if !ev.Sender.Equal(addr) && !ev.Receiver.Equal(addr) {
+				continue
+			}
+			list = append(list, Transfer{
+				From:   ev.Sender,
+				To:     ev.Receiver,
+				Asset:  mavryk.NewToken(ev.Contract, ev.TokenId).String(),
+				Symbol: ev.Symbol,
+				Amount: ev.Amount.Float64(-ev.Decimals),
+				Height: ev.Block,
+				Time:   ev.Time,
+			})
+		}
+	}
+	return list, nil
+}
+
+func (b *Builder) flows(ctx context.Context, addr Address) ([]*Flow, error) {
+	q := b.accounts.NewFlowQuery().
+		AndEqual("address", addr).
+		AndEqual("kind", "balance").
+		WithLimit(b.batch).
+		Asc()
+	if !b.from.IsZero() || !b.to.IsZero() {
+		from, to := b.timeRange()
+		q.AndRange("time", from, to)
+	}
+	var res []*Flow
+	for {
+		r, err := q.Run(ctx)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r.Rows()...)
+		if r.Len() < b.batch {
+			return res, nil
+		}
+		q.WithCursor(r.Cursor())
+	}
+}
+
+func (b *Builder) tokenEvents(ctx context.Context, addr Address) ([]*TokenEvent, error) {
+	params := NewQuery().
+		AndEqual("type", "transfer").
+		WithLimit(uint(b.batch)).
+		Asc()
+	if !b.from.IsZero() || !b.to.IsZero() {
+		from, to := b.timeRange()
+		params = params.AndRange("time", from, to)
+	}
+	var res []*TokenEvent
+	for {
+		list, err := b.wallets.ListTokenEvents(ctx, addr, params)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, list...)
+		if len(list) < b.batch {
+			return res, nil
+		}
+		params = params.WithCursor(list[len(list)-1].Id)
+	}
+}
+
+func (b *Builder) timeRange() (time.Time, time.Time) {
+	from, to := b.from, b.to
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	return from, to
+}
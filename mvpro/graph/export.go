@@ -0,0 +1,276 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package graph
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"time"
+)
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// WriteCSV writes an edge list with one row per address pair and asset.
+func (g *Graph) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"source", "target", "asset", "symbol", "volume", "count", "first_height", "last_height", "first_seen", "last_seen"})
+	for _, e := range g.Edges() {
+		cw.Write([]string{
+			e.Source.String(),
+			e.Target.String(),
+			e.Asset,
+			e.Symbol,
+			formatFloat(e.Volume),
+			strconv.Itoa(e.Count),
+			strconv.FormatInt(e.FirstHeight, 10),
+			strconv.FormatInt(e.LastHeight, 10),
+			formatTime(e.FirstSeen),
+			formatTime(e.LastSeen),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteNodesCSV writes the node list matching WriteCSV.
+func (g *Graph) WriteNodesCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"address", "label", "kind", "hop", "seed", "hub", "volume_in", "volume_out", "count_in", "count_out"})
+	for _, n := range g.Nodes() {
+		cw.Write([]string{
+			n.Address.String(),
+			n.Label,
+			n.Kind,
+			strconv.Itoa(n.Hop),
+			strconv.FormatBool(n.Seed),
+			strconv.FormatBool(n.Hub),
+			formatFloat(n.VolumeIn),
+			formatFloat(n.VolumeOut),
+			strconv.Itoa(n.CountIn),
+			strconv.Itoa(n.CountOut),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// attribute declares a node or edge attribute shared by both XML formats.
+type attribute struct {
+	id, typ string
+	node    func(*Node) string
+	edge    func(*Edge) string
+}
+
+var nodeAttrs = []attribute{
+	{id: "label", typ: "string", node: func(n *Node) string { return n.Label }},
+	{id: "kind", typ: "string", node: func(n *Node) string { return n.Kind }},
+	{id: "hop", typ: "int", node: func(n *Node) string { return strconv.Itoa(n.Hop) }},
+	{id: "seed", typ: "boolean", node: func(n *Node) string { return strconv.FormatBool(n.Seed) }},
+	{id: "hub", typ: "boolean", node: func(n *Node) string { return strconv.FormatBool(n.Hub) }},
+	{id: "volume_in", typ: "double", node: func(n *Node) string { return formatFloat(n.VolumeIn) }},
+	{id: "volume_out", typ: "double", node: func(n *Node) string { return formatFloat(n.VolumeOut) }},
+}
+
+var edgeAttrs = []attribute{
+	{id: "asset", typ: "string", edge: func(e *Edge) string { return e.Asset }},
+	{id: "symbol", typ: "string", edge: func(e *Edge) string { return e.Symbol }},
+	{id: "volume", typ: "double", edge: func(e *Edge) string { return formatFloat(e.Volume) }},
+	{id: "count", typ: "int", edge: func(e *Edge) string { return strconv.Itoa(e.Count) }},
+	{id: "first_seen", typ: "string", edge: func(e *Edge) string { return formatTime(e.FirstSeen) }},
+	{id: "last_seen", typ: "string", edge: func(e *Edge) string { return formatTime(e.LastSeen) }},
+}
+
+type graphmlKey struct {
+	Id   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphmlNode struct {
+	Id   string        `xml:"id,attr"`
+	Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+	Id     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphmlData `xml:"data"`
+}
+
+type graphmlDoc struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   struct {
+		Id          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphmlNode `xml:"node"`
+		Edges       []graphmlEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+// WriteGraphML writes the graph in GraphML format.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	doc := graphmlDoc{Xmlns: "http://graphml.graphdrawing.org/xmlns"}
+	for _, a := range nodeAttrs {
+		doc.Keys = append(doc.Keys, graphmlKey{Id: "n_" + a.id, For: "node", Name: a.id, Type: a.typ})
+	}
+	for _, a := range edgeAttrs {
+		doc.Keys = append(doc.Keys, graphmlKey{Id: "e_" + a.id, For: "edge", Name: a.id, Type: a.typ})
+	}
+	doc.Graph.Id = "G"
+	doc.Graph.EdgeDefault = "directed"
+	for _, n := range g.Nodes() {
+		gn := graphmlNode{Id: n.Address.String()}
+		for _, a := range nodeAttrs {
+			if v := a.node(n); v != "" {
+				gn.Data = append(gn.Data, graphmlData{Key: "n_" + a.id, Value: v})
+			}
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gn)
+	}
+	for i, e := range g.Edges() {
+		ge := graphmlEdge{
+			Id:     "e" + strconv.Itoa(i),
+			Source: e.Source.String(),
+			Target: e.Target.String(),
+		}
+		for _, a := range edgeAttrs {
+			if v := a.edge(e); v != "" {
+				ge.Data = append(ge.Data, graphmlData{Key: "e_" + a.id, Value: v})
+			}
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, ge)
+	}
+	return writeXML(w, doc)
+}
+
+type gexfAttr struct {
+	Id    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfAttrs struct {
+	Class string     `xml:"class,attr"`
+	Attrs []gexfAttr `xml:"attribute"`
+}
+
+type gexfValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfNode struct {
+	Id     string      `xml:"id,attr"`
+	Label  string      `xml:"label,attr"`
+	Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	Id     string      `xml:"id,attr"`
+	Source string      `xml:"source,attr"`
+	Target string      `xml:"target,attr"`
+	Weight string      `xml:"weight,attr"`
+	Label  string      `xml:"label,attr,omitempty"`
+	Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+type gexfDoc struct {
+	XMLName xml.Name `xml:"gexf"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Graph   struct {
+		Mode            string      `xml:"mode,attr"`
+		DefaultEdgeType string      `xml:"defaultedgetype,attr"`
+		Attributes      []gexfAttrs `xml:"attributes"`
+		Nodes           []gexfNode  `xml:"nodes>node"`
+		Edges           []gexfEdge  `xml:"edges>edge"`
+	} `xml:"graph"`
+}
+
+// gexfType maps attribute types to GEXF type names.
+func gexfType(typ string) string {
+	if typ == "int" {
+		return "integer"
+	}
+	return typ
+}
+
+// WriteGEXF writes the graph in GEXF 1.3 format. Edge weights are the
+// transfer volume.
+func (g *Graph) WriteGEXF(w io.Writer) error {
+	doc := gexfDoc{Xmlns: "http://gexf.net/1.3", Version: "1.3"}
+	doc.Graph.Mode = "static"
+	doc.Graph.DefaultEdgeType = "directed"
+	na := gexfAttrs{Class: "node"}
+	for _, a := range nodeAttrs {
+		na.Attrs = append(na.Attrs, gexfAttr{Id: a.id, Title: a.id, Type: gexfType(a.typ)})
+	}
+	ea := gexfAttrs{Class: "edge"}
+	for _, a := range edgeAttrs {
+		ea.Attrs = append(ea.Attrs, gexfAttr{Id: a.id, Title: a.id, Type: gexfType(a.typ)})
+	}
+	doc.Graph.Attributes = []gexfAttrs{na, ea}
+	for _, n := range g.Nodes() {
+		label := n.Label
+		if label == "" {
+			label = n.Address.String()
+		}
+		gn := gexfNode{Id: n.Address.String(), Label: label}
+		for _, a := range nodeAttrs {
+			if v := a.node(n); v != "" {
+				gn.Values = append(gn.Values, gexfValue{For: a.id, Value: v})
+			}
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gn)
+	}
+	for i, e := range g.Edges() {
+		ge := gexfEdge{
+			Id:     strconv.Itoa(i),
+			Source: e.Source.String(),
+			Target: e.Target.String(),
+			Weight: formatFloat(e.Volume),
+			Label:  e.Symbol,
+		}
+		for _, a := range edgeAttrs {
+			if v := a.edge(e); v != "" {
+				ge.Values = append(ge.Values, gexfValue{For: a.id, Value: v})
+			}
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, ge)
+	}
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
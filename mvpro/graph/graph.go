@@ -0,0 +1,190 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package graph
+
+import (
+	"sort"
+	"time"
+)
+
+// AssetTez is the asset name of native tez transfers.
+const AssetTez = "tez"
+
+// Node is an address in the graph.
+type Node struct {
+	Address   Address `json:"address"`
+	Label     string  `json:"label,omitempty"`
+	Kind      string  `json:"kind,omitempty"`
+	Hop       int     `json:"hop"`
+	Seed      bool    `json:"seed,omitempty"`
+	Hub       bool    `json:"hub,omitempty"`
+	VolumeIn  float64 `json:"volume_in"`
+	VolumeOut float64 `json:"volume_out"`
+	CountIn   int     `json:"count_in"`
+	CountOut  int     `json:"count_out"`
+}
+
+// Edge aggregates all transfers of one asset from Source to Target.
+// Token volumes are in token units, tez volumes in tez.
+type Edge struct {
+	Source      Address   `json:"source"`
+	Target      Address   `json:"target"`
+	Asset       string    `json:"asset"`
+	Symbol      string    `json:"symbol,omitempty"`
+	Volume      float64   `json:"volume"`
+	Count       int       `json:"count"`
+	FirstHeight int64     `json:"first_height"`
+	LastHeight  int64     `json:"last_height"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// Transfer is a single value movement between two addresses.
+type Transfer struct {
+	From   Address
+	To     Address
+	Asset  string
+	Symbol string
+	Amount float64
+	Height int64
+	Time   time.Time
+}
+
+type edgeKey struct {
+	src, dst Address
+	asset    string
+}
+
+// Graph is a directed multigraph with one edge per address pair and asset.
+type Graph struct {
+	nodes map[Address]*Node
+	edges map[edgeKey]*Edge
+}
+
+func New() *Graph {
+	return &Graph{
+		nodes: make(map[Address]*Node),
+		edges: make(map[edgeKey]*Edge),
+	}
+}
+
+// Node returns the node for addr or nil.
+func (g *Graph) Node(addr Address) *Node {
+	return g.nodes[addr]
+}
+
+// AddNode adds addr at hop distance if it does not exist yet.
+func (g *Graph) AddNode(addr Address, hop int) *Node {
+	n, ok := g.nodes[addr]
+	if !ok {
+		n = &Node{Address: addr, Hop: hop}
+		g.nodes[addr] = n
+	} else if hop < n.Hop {
+		n.Hop = hop
+	}
+	return n
+}
+
+// Add aggregates t into its edge. Both end nodes must exist.
+func (g *Graph) Add(t Transfer) {
+	src, dst := g.nodes[t.From], g.nodes[t.To]
+	if src == nil || dst == nil {
+		return
+	}
+	key := edgeKey{t.From, t.To, t.Asset}
+	e, ok := g.edges[key]
+	if !ok {
+		e = &Edge{
+			Source:      t.From,
+			Target:      t.To,
+			Asset:       t.Asset,
+			Symbol:      t.Symbol,
+			FirstHeight: t.Height,
+			LastHeight:  t.Height,
+			FirstSeen:   t.Time,
+			LastSeen:    t.Time,
+		}
+		g.edges[key] = e
+	}
+	e.Volume += t.Amount
+	e.Count++
+	if t.Height < e.FirstHeight {
+		e.FirstHeight, e.FirstSeen = t.Height, t.Time
+	}
+	if t.Height > e.LastHeight {
+		e.LastHeight, e.LastSeen = t.Height, t.Time
+	}
+	if t.Asset == AssetTez {
+		src.VolumeOut += t.Amount
+		dst.VolumeIn += t.Amount
+	}
+	src.CountOut++
+	dst.CountIn++
+}
+
+// NumNodes returns the number of nodes.
+func (g *Graph) NumNodes() int {
+	return len(g.nodes)
+}
+
+// NumEdges returns the number of edges.
+func (g *Graph) NumEdges() int {
+	return len(g.edges)
+}
+
+// Nodes returns all nodes ordered by hop and address.
+func (g *Graph) Nodes() []*Node {
+	list := make([]*Node, 0, len(g.nodes))
+	for _, n := range g.nodes {
+		list = append(list, n)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Hop != list[j].Hop {
+			return list[i].Hop < list[j].Hop
+		}
+		return list[i].Address.String() < list[j].Address.String()
+	})
+	return list
+}
+
+// Edges returns all edges ordered by source, target and asset.
+func (g *Graph) Edges() []*Edge {
+	list := make([]*Edge, 0, len(g.edges))
+	for _, e := range g.edges {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if s, t := a.Source.String(), b.Source.String(); s != t {
+			return s < t
+		}
+		if s, t := a.Target.String(), b.Target.String(); s != t {
+			return s < t
+		}
+		return a.Asset < b.Asset
+	})
+	return list
+}
+
+// Prune removes edges with fewer than minCount transfers or less than
+// minVolume tez. Token edges are only checked against minCount. Nodes
+// without remaining edges are removed unless they are seeds.
+func (g *Graph) Prune(minVolume float64, minCount int) *Graph {
+	for k, e := range g.edges {
+		if e.Count < minCount || (e.Asset == AssetTez && e.Volume < minVolume) {
+			delete(g.edges, k)
+		}
+	}
+	used := make(map[Address]bool, len(g.nodes))
+	for k := range g.edges {
+		used[k.src] = true
+		used[k.dst] = true
+	}
+	for a, n := range g.nodes {
+		if !n.Seed && !used[a] {
+			delete(g.nodes, a)
+		}
+	}
+	return g
+}
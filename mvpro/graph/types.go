@@ -0,0 +1,26 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package graph
+
+import (
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvpro-go/internal/client"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+	"github.com/mavryk-network/mvpro-go/mvpro/token"
+	"github.com/mavryk-network/mvpro-go/mvpro/wallet"
+)
+
+type (
+	Query = client.Query
+
+	Address    = mavryk.Address
+	Flow       = index.Flow
+	Metadata   = index.Metadata
+	TokenEvent = token.TokenEvent
+
+	AccountAPI = index.AccountAPI
+	WalletAPI  = wallet.WalletAPI
+)
+
+var NewQuery = client.NewQuery
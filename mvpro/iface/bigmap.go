@@ -0,0 +1,180 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package iface
+
+import (
+	"sort"
+	"strings"
+
+	m "github.com/mavryk-network/mvgo/micheline"
+)
+
+// Role is the purpose of a bigmap in a token or metadata standard.
+type Role string
+
+const (
+	RoleLedger        Role = "ledger"
+	RoleOperators     Role = "operators"
+	RoleAllowances    Role = "allowances"
+	RoleMetadata      Role = "metadata"
+	RoleTokenMetadata Role = "token_metadata"
+	RolePermits       Role = "permits"
+)
+
+// Shape is the key/value layout of a ledger bigmap.
+type Shape string
+
+const (
+	ShapeSingle    Shape = "address->nat"           // FA1.2, FA2 single asset
+	ShapeAllowance Shape = "address->pair(nat,map)" // FA1.2 with embedded allowances
+	ShapeMulti     Shape = "pair(address,nat)->nat" // FA2 multi asset
+	ShapeMultiRev  Shape = "pair(nat,address)->nat" // FA2 multi asset, id first
+	ShapeNFT       Shape = "nat->address"           // FA2 NFT
+	ShapeOperators Shape = "pair(address,address,nat)->unit"
+)
+
+// BigmapRole is a bigmap identified by name and type.
+type BigmapRole struct {
+	Name       string  `json:"name"`
+	Id         int64   `json:"id"`
+	Role       Role    `json:"role"`
+	Shape      Shape   `json:"shape,omitempty"`
+	Confidence float64 `json:"confidence"`
+	Type       Type    `json:"-"`
+}
+
+// better reports whether r is a better candidate for its role than b.
+func (r BigmapRole) better(b BigmapRole) bool {
+	switch {
+	case r.Confidence != b.Confidence:
+		return r.Confidence > b.Confidence
+	case r.Name != b.Name:
+		return r.Name < b.Name
+	default:
+		return r.Id < b.Id
+	}
+}
+
+var roleNames = map[string]Role{
+	"ledger":         RoleLedger,
+	"balances":       RoleLedger,
+	"accounts":       RoleLedger,
+	"tokens":         RoleLedger,
+	"holders":        RoleLedger,
+	"operators":      RoleOperators,
+	"allowances":     RoleAllowances,
+	"approvals":      RoleAllowances,
+	"metadata":       RoleMetadata,
+	"token_metadata": RoleTokenMetadata,
+	"permits":        RolePermits,
+}
+
+// flatten returns the leaf opcodes of nested pair types.
+func flatten(p Prim) []m.OpCode {
+	if p.OpCode != m.T_PAIR {
+		return []m.OpCode{p.OpCode}
+	}
+	var res []m.OpCode
+	for _, a := range p.Args {
+		res = append(res, flatten(a)...)
+	}
+	return res
+}
+
+func isOps(have []m.OpCode, want ...m.OpCode) bool {
+	if len(have) != len(want) {
+		return false
+	}
+	for i := range have {
+		if have[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isStringBytesMap reports whether p is a map string bytes.
+func isStringBytesMap(p Prim) bool {
+	return (p.OpCode == m.T_MAP || p.OpCode == m.T_BIG_MAP) &&
+		len(p.Args) == 2 &&
+		p.Args[0].OpCode == m.T_STRING &&
+		p.Args[1].OpCode == m.T_BYTES
+}
+
+// classify derives role and shape of a bigmap from its key and value types.
+func classify(typ Type) (Role, Shape) {
+	key, val := flatten(typ.Left().Prim), typ.Right().Prim
+	vops := flatten(val)
+	switch {
+	case isOps(key, m.T_ADDRESS) && isOps(vops, m.T_NAT):
+		return RoleLedger, ShapeSingle
+	case isOps(key, m.T_ADDRESS) && (isOps(vops, m.T_NAT, m.T_MAP) || isOps(vops, m.T_MAP, m.T_NAT)):
+		return RoleLedger, ShapeAllowance
+	case isOps(key, m.T_ADDRESS, m.T_NAT) && isOps(vops, m.T_NAT):
+		return RoleLedger, ShapeMulti
+	case isOps(key, m.T_NAT, m.T_ADDRESS) && isOps(vops, m.T_NAT):
+		return RoleLedger, ShapeMultiRev
+	case isOps(key, m.T_NAT) && isOps(vops, m.T_ADDRESS):
+		return RoleLedger, ShapeNFT
+	case isOps(key, m.T_ADDRESS, m.T_ADDRESS, m.T_NAT) && isOps(vops, m.T_UNIT):
+		return RoleOperators, ShapeOperators
+	case isOps(key, m.T_ADDRESS, m.T_ADDRESS) && isOps(vops, m.T_NAT):
+		return RoleAllowances, ""
+	case isOps(key, m.T_STRING) && isOps(vops, m.T_BYTES):
+		return RoleMetadata, ""
+	case isOps(key, m.T_NAT) && val.OpCode == m.T_PAIR && isOps(vops, m.T_NAT, m.T_MAP) && isStringBytesMap(val.Args[len(val.Args)-1]):
+		return RoleTokenMetadata, ""
+	}
+	return "", ""
+}
+
+// detectBigmaps assigns roles to all bigmaps of a script. A name and type
+// match has full confidence, a type match alone 0.6 and a name match alone
+// 0.4. For each role only the best candidate is kept, ties are broken by
+// name and bigmap id.
+func detectBigmaps(s *ContractScript) []BigmapRole {
+	types := s.BigmapTypes
+	ids := s.BigmapNames
+	if s.Script != nil {
+		if len(types) == 0 {
+			types = s.Script.BigmapTypes()
+		}
+		if len(ids) == 0 {
+			ids = s.Script.Bigmaps()
+		}
+	}
+	best := make(map[Role]BigmapRole)
+	for name, typ := range types {
+		named := roleNames[strings.ToLower(name)]
+		role, shape := classify(typ)
+		r := BigmapRole{
+			Name:  name,
+			Id:    ids[name],
+			Shape: shape,
+			Type:  typ,
+		}
+		switch {
+		case named != "" && named == role:
+			r.Role, r.Confidence = role, 1
+		case named == RolePermits:
+			r.Role, r.Confidence = named, 0.8
+		case role != "":
+			r.Role, r.Confidence = role, 0.6
+		case named != "":
+			r.Role, r.Confidence = named, 0.4
+		default:
+			continue
+		}
+		if b, ok := best[r.Role]; ok && !r.better(b) {
+			continue
+		}
+		best[r.Role] = r
+	}
+	list := make([]BigmapRole, 0, len(best))
+	for _, r := range best {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Role < list[j].Role })
+	return list
+}
@@ -0,0 +1,358 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package iface
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	m "github.com/mavryk-network/mvgo/micheline"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+// Standard is a contract interface standard or a known contract pattern.
+type Standard string
+
+const (
+	StdManager  Standard = "manager"
+	StdDelegate Standard = "set_delegate"
+	StdFA1      Standard = "fa1"
+	StdFA12     Standard = "fa1.2"
+	StdFA2      Standard = "fa2"
+	StdTzip16   Standard = "tzip-16"
+	StdTzip17   Standard = "tzip-17"
+	StdDex      Standard = "dex"
+	StdFarm     Standard = "farm"
+)
+
+// entrypoint standards and their micheline interface specs
+var interfaces = []struct {
+	std   Standard
+	iface m.Interface
+}{
+	{StdManager, m.IManager},
+	{StdDelegate, m.ISetDelegate},
+	{StdFA1, m.ITzip5},
+	{StdFA12, m.ITzip7},
+	{StdFA2, m.ITzip12},
+}
+
+// on-chain views defined by TZIP-12
+var fa2Views = []string{"get_balance", "total_supply", "all_tokens", "is_operator", "token_metadata"}
+
+// Match is a detected standard with a confidence between 0 and 1 and the
+// evidence it is based on.
+type Match struct {
+	Standard   Standard `json:"standard"`
+	Name       string   `json:"name,omitempty"`
+	Confidence float64  `json:"confidence"`
+	Evidence   []string `json:"evidence"`
+	Missing    []string `json:"missing,omitempty"`
+}
+
+func (x *Match) add(conf float64, format string, args ...any) {
+	x.Confidence += conf
+	if x.Confidence > 1 {
+		x.Confidence = 1
+	}
+	x.Evidence = append(x.Evidence, fmt.Sprintf(format, args...))
+}
+
+// Report is the result of interface detection on a contract script.
+type Report struct {
+	Address     Address      `json:"address"`
+	Standards   []Match      `json:"standards"`
+	Entrypoints []string     `json:"entrypoints"`
+	Views       []string     `json:"views,omitempty"`
+	Bigmaps     []BigmapRole `json:"bigmaps,omitempty"`
+}
+
+// Get returns the match for std.
+func (r *Report) Get(std Standard) (Match, bool) {
+	for _, v := range r.Standards {
+		if v.Standard == std {
+			return v, true
+		}
+	}
+	return Match{}, false
+}
+
+// Is reports whether std was detected.
+func (r *Report) Is(std Standard) bool {
+	_, ok := r.Get(std)
+	return ok
+}
+
+// Bigmap returns the bigmap with role.
+func (r *Report) Bigmap(role Role) (BigmapRole, bool) {
+	for _, v := range r.Bigmaps {
+		if v.Role == role {
+			return v, true
+		}
+	}
+	return BigmapRole{}, false
+}
+
+// Pattern describes a contract family by entrypoint names. Each group
+// lists alternative names of which at least one must exist.
+type Pattern struct {
+	Standard Standard
+	Name     string
+	Groups   [][]string
+}
+
+// DefaultPatterns returns entrypoint patterns of common DEX and farm
+// contracts.
+func DefaultPatterns() []Pattern {
+	return []Pattern{
+		{StdDex, "quipuswap", [][]string{
+			{"tezToTokenPayment"},
+			{"tokenToTezPayment"},
+			{"investLiquidity"},
+			{"divestLiquidity"},
+		}},
+		{StdDex, "liquidity_baking", [][]string{
+			{"xtzToToken"},
+			{"tokenToXtz"},
+			{"addLiquidity"},
+			{"removeLiquidity"},
+		}},
+		{StdDex, "cfmm", [][]string{
+			{"swap", "Swap", "tokenToToken", "token_to_token"},
+			{"addLiquidity", "AddLiquidity", "add_liquidity"},
+			{"removeLiquidity", "RemoveLiquidity", "remove_liquidity"},
+		}},
+		{StdFarm, "farm", [][]string{
+			{"deposit", "stake", "Stake", "Deposit"},
+			{"withdraw", "unstake", "Unstake", "Withdraw"},
+			{"harvest", "claim", "Harvest", "Claim"},
+		}},
+	}
+}
+
+// Detector determines standard compliance from contract scripts.
+type Detector struct {
+	patterns []Pattern
+	minConf  float64
+}
+
+func NewDetector() *Detector {
+	return &Detector{
+		patterns: DefaultPatterns(),
+		minConf:  0.5,
+	}
+}
+
+// WithPatterns adds custom contract patterns.
+func (d *Detector) WithPatterns(p ...Pattern) *Detector {
+	d.patterns = append(d.patterns, p...)
+	return d
+}
+
+// WithMinConfidence drops matches below c, 0.5 by default.
+func (d *Detector) WithMinConfidence(c float64) *Detector {
+	d.minConf = c
+	return d
+}
+
+// Load fetches the script of addr and runs detection.
+func (d *Detector) Load(ctx context.Context, api ContractAPI, addr Address) (*Report, error) {
+	s, err := api.GetScript(ctx, addr, index.NewQuery().WithPrim())
+	if err != nil {
+		return nil, fmt.Errorf("loading script %s: %w", addr, err)
+	}
+	r := d.Detect(s)
+	r.Address = addr
+	return r, nil
+}
+
+// Detect runs detection with default settings.
+func Detect(s *ContractScript) *Report {
+	return NewDetector().Detect(s)
+}
+
+// Detect analyzes entrypoints, views and bigmaps of s. Entrypoint types
+// can only be compared when the script is available, otherwise standards
+// are matched by entrypoint names with lower confidence.
+func (d *Detector) Detect(s *ContractScript) *Report {
+	r := &Report{}
+	eps := s.Entrypoints
+	if s.Script != nil {
+		if e, err := s.Script.Entrypoints(true); err == nil && len(e) > 0 {
+			eps = e
+		}
+	}
+	for name := range eps {
+		r.Entrypoints = append(r.Entrypoints, name)
+	}
+	sort.Strings(r.Entrypoints)
+	views := s.Views
+	if len(views) == 0 && s.Script != nil {
+		views, _ = s.Script.Views(false, false)
+	}
+	for name := range views {
+		r.Views = append(r.Views, name)
+	}
+	sort.Strings(r.Views)
+	r.Bigmaps = detectBigmaps(s)
+
+	var list []Match
+	for _, v := range interfaces {
+		list = append(list, matchInterface(v.std, v.iface, eps))
+	}
+	list = append(list, r.tzip16(), r.tzip17(eps))
+	for _, p := range d.patterns {
+		list = append(list, matchPattern(p, eps))
+	}
+	r.refineFA(list)
+
+	for _, x := range list {
+		if x.Confidence >= d.minConf && x.Confidence > 0 {
+			r.Standards = append(r.Standards, x)
+		}
+	}
+	sort.SliceStable(r.Standards, func(i, j int) bool {
+		return r.Standards[i].Confidence > r.Standards[j].Confidence
+	})
+	return r
+}
+
+// matchInterface compares entrypoints against a micheline interface spec.
+// Strict type and annotation matches score 1, type matches 0.9 and name
+// matches 0.7 without and 0.4 with conflicting types.
+func matchInterface(std Standard, iface m.Interface, eps Entrypoints) Match {
+	x := Match{Standard: std, Name: iface.String()}
+	var (
+		found, typed int
+		specs        = m.InterfaceSpecs[iface]
+	)
+	for _, spec := range specs {
+		name := spec.GetVarAnnoAny()
+		ep, ok := eps[name]
+		if !ok {
+			x.Missing = append(x.Missing, name)
+			continue
+		}
+		found++
+		if ep.Prim != nil {
+			typed++
+		}
+	}
+	switch {
+	case found < len(specs):
+		if found*2 >= len(specs) {
+			x.add(0.3*float64(found)/float64(len(specs)), "%d of %d entrypoints", found, len(specs))
+		}
+	case typed < found:
+		x.add(0.7, "entrypoint names match")
+	case iface.MatchesStrict(eps):
+		x.add(1, "entrypoint types and annotations match")
+	case iface.Matches(eps):
+		x.add(0.9, "entrypoint types match")
+	default:
+		x.add(0.4, "entrypoint names match with different types")
+	}
+	return x
+}
+
+// matchPattern matches entrypoint name patterns.
+func matchPattern(p Pattern, eps Entrypoints) Match {
+	x := Match{Standard: p.Standard, Name: p.Name}
+	var found int
+	for _, g := range p.Groups {
+		var ok bool
+		for _, name := range g {
+			if _, ok = eps[name]; ok {
+				break
+			}
+		}
+		if ok {
+			found++
+		} else {
+			x.Missing = append(x.Missing, g[0])
+		}
+	}
+	if found == len(p.Groups) && found > 0 {
+		x.add(0.7, "entrypoints match %s pattern", p.Name)
+	}
+	return x
+}
+
+// tzip16 detects a contract metadata bigmap.
+func (r *Report) tzip16() Match {
+	x := Match{Standard: StdTzip16}
+	if b, ok := r.Bigmap(RoleMetadata); ok {
+		x.add(b.Confidence, "metadata bigmap %q", b.Name)
+	} else {
+		x.Missing = append(x.Missing, "metadata")
+	}
+	return x
+}
+
+// tzip17 detects permit entrypoints and storage.
+func (r *Report) tzip17(eps Entrypoints) Match {
+	x := Match{Standard: StdTzip17}
+	ep, ok := eps["permit"]
+	if !ok {
+		x.Missing = append(x.Missing, "permit")
+		return x
+	}
+	if ep.Prim != nil && ep.Prim.OpCode != m.T_LIST {
+		x.add(0.4, "permit entrypoint with unexpected type")
+	} else {
+		x.add(0.6, "permit entrypoint")
+	}
+	_, ok1 := eps["setExpiry"]
+	_, ok2 := eps["set_expiry"]
+	if ok1 || ok2 {
+		x.add(0.2, "setExpiry entrypoint")
+	} else {
+		x.Missing = append(x.Missing, "setExpiry")
+	}
+	if b, ok := r.Bigmap(RolePermits); ok {
+		x.add(0.2, "permits bigmap %q", b.Name)
+	}
+	return x
+}
+
+// refineFA adds storage and view evidence to token standard matches.
+func (r *Report) refineFA(list []Match) {
+	ledger, hasLedger := r.Bigmap(RoleLedger)
+	for i := range list {
+		x := &list[i]
+		if x.Confidence == 0 {
+			continue
+		}
+		switch x.Standard {
+		case StdFA1, StdFA12:
+			if hasLedger && (ledger.Shape == ShapeSingle || ledger.Shape == ShapeAllowance) {
+				x.add(0.1, "ledger bigmap %q %s", ledger.Name, ledger.Shape)
+			}
+			if b, ok := r.Bigmap(RoleAllowances); ok {
+				x.add(0.05, "allowances bigmap %q", b.Name)
+			}
+		case StdFA2:
+			if hasLedger && ledger.Shape != ShapeAllowance && ledger.Shape != "" {
+				x.add(0.1, "ledger bigmap %q %s", ledger.Name, ledger.Shape)
+			}
+			if b, ok := r.Bigmap(RoleOperators); ok {
+				x.add(0.05, "operators bigmap %q", b.Name)
+			}
+			if b, ok := r.Bigmap(RoleTokenMetadata); ok {
+				x.add(0.05, "token_metadata bigmap %q", b.Name)
+			}
+			for _, v := range fa2Views {
+				if r.hasView(v) {
+					x.add(0.02, "view %s", v)
+				}
+			}
+		}
+	}
+}
+
+func (r *Report) hasView(name string) bool {
+	i := sort.SearchStrings(r.Views, name)
+	return i < len(r.Views) && r.Views[i] == name
+}
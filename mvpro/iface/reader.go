@@ -0,0 +1,137 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package iface
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mavryk-network/mvgo/mavryk"
+	m "github.com/mavryk-network/mvgo/micheline"
+	"github.com/mavryk-network/mvpro-go/internal/client"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+var (
+	ErrNoLedger         = errors.New("no ledger bigmap")
+	ErrNoTokenMetadata  = errors.New("no token_metadata bigmap")
+	ErrUnsupportedShape = errors.New("unsupported ledger shape")
+	ErrNotFound         = errors.New("token metadata not found")
+)
+
+// Reader reads balances and token metadata from the bigmaps identified by
+// a detection report.
+type Reader struct {
+	api    ContractAPI
+	report *Report
+}
+
+func NewReader(api ContractAPI, r *Report) *Reader {
+	return &Reader{
+		api:    api,
+		report: r,
+	}
+}
+
+// Balance returns the ledger balance of owner. The token id is ignored
+// for single asset ledgers. NFT ledgers return 1 when owner holds the
+// token and 0 otherwise.
+func (r *Reader) Balance(ctx context.Context, owner Address, id Z) (Z, error) {
+	b, ok := r.report.Bigmap(RoleLedger)
+	if !ok || b.Shape == "" {
+		return mavryk.Zero, ErrNoLedger
+	}
+	var key Prim
+	switch b.Shape {
+	case ShapeSingle, ShapeAllowance:
+		key = m.NewAddress(owner)
+	case ShapeMulti:
+		key = m.NewPair(m.NewAddress(owner), m.NewZ(id))
+	case ShapeMultiRev:
+		key = m.NewPair(m.NewZ(id), m.NewAddress(owner))
+	case ShapeNFT:
+		key = m.NewZ(id)
+	default:
+		return mavryk.Zero, fmt.Errorf("%w %s", ErrUnsupportedShape, b.Shape)
+	}
+	val, ok, err := r.value(ctx, b, key)
+	if err != nil || !ok {
+		return mavryk.Zero, err
+	}
+	switch b.Shape {
+	case ShapeNFT:
+		var a Address
+		switch val.Type {
+		case m.PrimBytes:
+			err = a.Decode(val.Bytes)
+		case m.PrimString:
+			a, err = mavryk.ParseAddress(val.String)
+		}
+		if err != nil {
+			return mavryk.Zero, fmt.Errorf("ledger %d: invalid owner: %w", b.Id, err)
+		}
+		if a.Equal(owner) {
+			return mavryk.NewZ(1), nil
+		}
+		return mavryk.Zero, nil
+	case ShapeAllowance:
+		for _, v := range val.Args {
+			if v.Type == m.PrimInt {
+				return mavryk.NewBigZ(v.Int), nil
+			}
+		}
+		return mavryk.Zero, fmt.Errorf("ledger %d: no balance in value", b.Id)
+	default:
+		if val.Type != m.PrimInt {
+			return mavryk.Zero, fmt.Errorf("ledger %d: unexpected value type", b.Id)
+		}
+		return mavryk.NewBigZ(val.Int), nil
+	}
+}
+
+// TokenMetadata returns the TZIP-12 token_info map of token id with byte
+// values converted to strings.
+func (r *Reader) TokenMetadata(ctx context.Context, id Z) (map[string]string, error) {
+	b, ok := r.report.Bigmap(RoleTokenMetadata)
+	if !ok {
+		return nil, ErrNoTokenMetadata
+	}
+	val, ok, err := r.value(ctx, b, m.NewZ(id))
+	if err != nil {
+		return nil, err
+	}
+	if !ok || len(val.Args) == 0 {
+		return nil, ErrNotFound
+	}
+	info := val.Args[len(val.Args)-1]
+	res := make(map[string]string, len(info.Args))
+	for _, elt := range info.Args {
+		if elt.OpCode != m.D_ELT || len(elt.Args) != 2 {
+			continue
+		}
+		res[elt.Args[0].String] = string(elt.Args[1].Bytes)
+	}
+	return res, nil
+}
+
+// value reads key from bigmap b. Missing keys are not an error.
+func (r *Reader) value(ctx context.Context, b BigmapRole, key Prim) (Prim, bool, error) {
+	k, err := m.NewKey(b.Type.Left(), key)
+	if err != nil {
+		return Prim{}, false, err
+	}
+	v, err := r.api.GetBigmapValue(ctx, b.Id, k.Hash().String(), index.NewQuery().WithPrim())
+	if err != nil {
+		if e, ok := client.IsErrHttp(err); ok && e.StatusCode() == http.StatusNotFound {
+			return Prim{}, false, nil
+		}
+		return Prim{}, false, fmt.Errorf("bigmap %d: %w", b.Id, err)
+	}
+	if v.ValuePrim == nil {
+		return Prim{}, false, nil
+	}
+	return *v.ValuePrim, true, nil
+}
@@ -0,0 +1,20 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package iface
+
+import (
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvgo/micheline"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+type (
+	Address        = mavryk.Address
+	Z              = mavryk.Z
+	Prim           = micheline.Prim
+	Type           = micheline.Type
+	Entrypoints    = micheline.Entrypoints
+	ContractScript = index.ContractScript
+	ContractAPI    = index.ContractAPI
+)
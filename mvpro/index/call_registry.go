@@ -0,0 +1,239 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var ErrNoCallType = errors.New("no registered call type")
+
+// Interface tags as reported in Contract.Interfaces.
+const (
+	InterfaceFA1_2 = "TZIP-007"
+	InterfaceFA2   = "TZIP-012"
+)
+
+// FA1.2 entrypoint parameters.
+type FA12Transfer struct {
+	From  Address `json:"from"`
+	To    Address `json:"to"`
+	Value Z       `json:"value"`
+}
+
+type FA12Approve struct {
+	Spender Address `json:"spender"`
+	Value   Z       `json:"value"`
+}
+
+// FA2 entrypoint parameters.
+type FA2Transfer []FA2TransferBatch
+
+type FA2TransferBatch struct {
+	From Address         `json:"from_"`
+	Txs  []FA2TransferTx `json:"txs"`
+}
+
+type FA2TransferTx struct {
+	To      Address `json:"to_"`
+	TokenId Z       `json:"token_id"`
+	Amount  Z       `json:"amount"`
+}
+
+type FA2UpdateOperators []FA2OperatorUpdate
+
+// FA2OperatorUpdate is a union, exactly one of Add or Remove is set.
+type FA2OperatorUpdate struct {
+	Add    *FA2Operator `json:"add_operator,omitempty"`
+	Remove *FA2Operator `json:"remove_operator,omitempty"`
+}
+
+type FA2Operator struct {
+	Owner    Address `json:"owner"`
+	Operator Address `json:"operator"`
+	TokenId  Z       `json:"token_id"`
+}
+
+// CallRegistry maps contract code hashes, interface hashes and interface
+// tags to Go types for entrypoint parameters. Lookups try the most specific
+// key first, i.e. code hash, then interface hash, then interface tags.
+// Interface hash and tags of a contract are learned via AddContract.
+type CallRegistry struct {
+	sync.RWMutex
+	byCode    map[string]map[string]reflect.Type
+	byIface   map[string]map[string]reflect.Type
+	byTag     map[string]map[string]reflect.Type
+	contracts map[string]*callContract // by code hash and address
+}
+
+type callContract struct {
+	ifaceHash string
+	tags      []string
+}
+
+// DefaultCallRegistry is used by Op.DecodeCall and comes preloaded with
+// standard FA1.2 and FA2 entrypoint types.
+var DefaultCallRegistry = NewCallRegistry().WithStandardTypes()
+
+func NewCallRegistry() *CallRegistry {
+	return &CallRegistry{
+		byCode:    make(map[string]map[string]reflect.Type),
+		byIface:   make(map[string]map[string]reflect.Type),
+		byTag:     make(map[string]map[string]reflect.Type),
+		contracts: make(map[string]*callContract),
+	}
+}
+
+// WithStandardTypes registers FA1.2 transfer/approve and FA2
+// transfer/update_operators parameter types.
+func (r *CallRegistry) WithStandardTypes() *CallRegistry {
+	r.RegisterInterface(InterfaceFA1_2, "transfer", FA12Transfer{})
+	r.RegisterInterface(InterfaceFA1_2, "approve", FA12Approve{})
+	r.RegisterInterface(InterfaceFA2, "transfer", FA2Transfer{})
+	r.RegisterInterface(InterfaceFA2, "update_operators", FA2UpdateOperators{})
+	return r
+}
+
+// RegisterCodeHash registers the type of typ's value for calls to entrypoint
+// on all contracts with code hash.
+func (r *CallRegistry) RegisterCodeHash(hash []byte, entrypoint string, typ any) *CallRegistry {
+	r.register(r.byCode, hex.EncodeToString(hash), entrypoint, typ)
+	return r
+}
+
+// RegisterInterfaceHash registers typ for entrypoint on all contracts
+// with interface hash.
+func (r *CallRegistry) RegisterInterfaceHash(hash []byte, entrypoint string, typ any) *CallRegistry {
+	r.register(r.byIface, hex.EncodeToString(hash), entrypoint, typ)
+	return r
+}
+
+// RegisterInterface registers typ for entrypoint on all contracts that
+// implement interface tag. FA1.2 and FA2 are accepted as aliases for
+// TZIP-007 and TZIP-012.
+func (r *CallRegistry) RegisterInterface(tag, entrypoint string, typ any) *CallRegistry {
+	r.register(r.byTag, normalizeInterface(tag), entrypoint, typ)
+	return r
+}
+
+func (r *CallRegistry) register(m map[string]map[string]reflect.Type, key, entrypoint string, typ any) {
+	t := reflect.TypeOf(typ)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		panic(fmt.Errorf("call registry: nil type for entrypoint %s", entrypoint))
+	}
+	r.Lock()
+	defer r.Unlock()
+	eps, ok := m[key]
+	if !ok {
+		eps = make(map[string]reflect.Type)
+		m[key] = eps
+	}
+	eps[entrypoint] = t
+}
+
+// AddContract makes interface hash and tags of c known to the registry so
+// that calls can be matched by the op's code hash or receiver.
+func (r *CallRegistry) AddContract(c *Contract) *CallRegistry {
+	cc := &callContract{
+		ifaceHash: hex.EncodeToString(c.InterfaceHash),
+		tags:      make([]string, 0, len(c.Interfaces)),
+	}
+	for _, v := range c.Interfaces {
+		cc.tags = append(cc.tags, normalizeInterface(v))
+	}
+	r.Lock()
+	defer r.Unlock()
+	if len(c.CodeHash) > 0 {
+		r.contracts[hex.EncodeToString(c.CodeHash)] = cc
+	}
+	r.contracts[c.Address.String()] = cc
+	return r
+}
+
+// Lookup returns the registered type for a call to entrypoint.
+func (r *CallRegistry) Lookup(o *Op) (reflect.Type, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	code := hex.EncodeToString(o.CodeHash)
+	if t, ok := r.byCode[code][o.Entrypoint]; ok {
+		return t, true
+	}
+	cc, ok := r.contracts[code]
+	if !ok {
+		cc, ok = r.contracts[o.Receiver.String()]
+	}
+	if !ok {
+		return nil, false
+	}
+	if t, ok := r.byIface[cc.ifaceHash][o.Entrypoint]; ok {
+		return t, true
+	}
+	for _, tag := range cc.tags {
+		if t, ok := r.byTag[tag][o.Entrypoint]; ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// DecodeCall decodes call parameters of o into a new value of the registered
+// type and returns a pointer to it. Contract types must be known to the op,
+// e.g. via OpAPI.ResolveTypes.
+func (r *CallRegistry) DecodeCall(o *Op) (any, error) {
+	if o.Entrypoint == "" {
+		return nil, ErrNoParams
+	}
+	t, ok := r.Lookup(o)
+	if !ok {
+		return nil, fmt.Errorf("op %s entrypoint %s: %w", o.Hash, o.Entrypoint, ErrNoCallType)
+	}
+	params, err := o.DecodeParams(true, 0)
+	if err != nil {
+		return nil, err
+	}
+	val := reflect.New(t)
+	if err := params.Unmarshal(val.Interface()); err != nil {
+		return nil, fmt.Errorf("op %s entrypoint %s: %v", o.Hash, o.Entrypoint, err)
+	}
+	return val.Interface(), nil
+}
+
+// DecodeCall decodes call parameters into the type registered with
+// DefaultCallRegistry. The result is a pointer, e.g. *FA2Transfer.
+func (o *Op) DecodeCall() (any, error) {
+	return DefaultCallRegistry.DecodeCall(o)
+}
+
+// DecodeCallAs decodes call parameters of o into T using r and fails when
+// the registered type is not T.
+func DecodeCallAs[T any](r *CallRegistry, o *Op) (*T, error) {
+	v, err := r.DecodeCall(o)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := v.(*T)
+	if !ok {
+		return nil, fmt.Errorf("op %s entrypoint %s: decoded type %T is not %T", o.Hash, o.Entrypoint, v, t)
+	}
+	return t, nil
+}
+
+func normalizeInterface(tag string) string {
+	switch strings.ToUpper(tag) {
+	case "FA1.2", "FA12", "FA1_2":
+		return InterfaceFA1_2
+	case "FA2":
+		return InterfaceFA2
+	default:
+		return tag
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ChainParams converts between heights, cycles and wall-clock time across
+// protocol upgrades. Protocol deployments and their configs are resolved
+// through ProtocolParams. Time estimates are anchored at the chain tip and
+// use the minimal block delay of each protocol.
+type ChainParams struct {
+	mu      sync.Mutex
+	params  *ProtocolParams
+	deps    []Deployment    // deployments the derived data is built from
+	gen     int             // generation of deps
+	cycles  []cycleSegment  // built from all configs on demand
+	delays  []time.Duration // block delay per deployment
+	tip     *Tip
+	tipTime time.Time // local time the tip was loaded
+	maxAge  time.Duration
+}
+
+// cycleSegment is a height range with constant cycle length.
+type cycleSegment struct {
+	height int64 // first block
+	cycle  int64 // cycle of first block
+	length int64 // blocks per cycle
+}
+
+func NewChainParams(api ExplorerAPI) *ChainParams {
+	return &ChainParams{
+		params: NewProtocolParams(api),
+		maxAge: time.Minute,
+	}
+}
+
+// WithProtocolParams shares cached protocol parameters with other users,
+// e.g. a CostModel.
+func (p *ChainParams) WithProtocolParams(pp *ProtocolParams) *ChainParams {
+	p.params = pp
+	p.deps, p.gen, p.cycles, p.delays = nil, 0, nil, nil
+	return p
+}
+
+// WithMaxTipAge sets how long the tip used to anchor time estimates is
+// cached.
+func (p *ChainParams) WithMaxTipAge(d time.Duration) *ChainParams {
+	p.maxAge = d
+	return p
+}
+
+// Load reloads protocol deployments and drops all derived data. Configs of
+// known deployments stay cached.
+func (p *ChainParams) Load(ctx context.Context) error {
+	return p.params.Load(ctx)
+}
+
+// Deployments returns all known protocol deployments in activation order.
+func (p *ChainParams) Deployments(ctx context.Context) ([]Deployment, error) {
+	return p.params.Deployments(ctx)
+}
+
+// ProtocolAt returns the protocol deployment active at height.
+func (p *ChainParams) ProtocolAt(ctx context.Context, height int64) (Deployment, error) {
+	return p.params.ProtocolAt(ctx, height)
+}
+
+// ConfigAt returns protocol parameters active at height.
+func (p *ChainParams) ConfigAt(ctx context.Context, height int64) (*Config, error) {
+	return p.params.ConfigAt(ctx, height)
+}
+
+func (p *ChainParams) find(height int64) int {
+	for i, v := range p.deps {
+		if height >= v.StartHeight && (v.EndHeight < 0 || height <= v.EndHeight) {
+			return i
+		}
+	}
+	return -1
+}
+
+// build loads configs of all deployments and derives cycle segments and
+// block delays.
+func (p *ChainParams) build(ctx context.Context) error {
+	deps, gen, err := p.params.deployments(ctx)
+	if err != nil {
+		return err
+	}
+	if gen != p.gen {
+		// deployments were reloaded
+		p.deps, p.gen, p.cycles, p.delays = deps, gen, nil, nil
+	}
+	if p.cycles != nil {
+		return nil
+	}
+	cycles := make([]cycleSegment, 0)
+	delays := make([]time.Duration, len(p.deps))
+	for i, dep := range p.deps {
+		c, err := p.params.Config(ctx, dep)
+		if err != nil {
+			return err
+		}
+		delays[i] = time.Duration(c.MinimalBlockDelay) * time.Second
+		if c.BlocksPerCycle <= 0 {
+			// genesis and bootstrap protocols
+			continue
+		}
+		if len(cycles) == 0 {
+			// cycle 0 starts at the first block after genesis
+			cycles = append(cycles, cycleSegment{height: 1, length: c.BlocksPerCycle})
+			continue
+		}
+		last := cycles[len(cycles)-1]
+		if last.length == c.BlocksPerCycle {
+			continue
+		}
+		// a new cycle length takes effect with a new cycle at activation
+		cycles = append(cycles, cycleSegment{
+			height: dep.StartHeight,
+			cycle:  last.cycle + (dep.StartHeight-1-last.height)/last.length + 1,
+			length: c.BlocksPerCycle,
+		})
+	}
+	if len(cycles) == 0 {
+		return fmt.Errorf("blocks per cycle unknown")
+	}
+	// protocols without block delay inherit the delay of their neighbours
+	for i := 1; i < len(delays); i++ {
+		if delays[i] == 0 {
+			delays[i] = delays[i-1]
+		}
+	}
+	for i := len(delays) - 2; i >= 0; i-- {
+		if delays[i] == 0 {
+			delays[i] = delays[i+1]
+		}
+	}
+	if len(delays) > 0 && delays[0] == 0 {
+		return fmt.Errorf("minimal block delay unknown")
+	}
+	p.cycles = cycles
+	p.delays = delays
+	return nil
+}
+
+// HeightToCycle returns the cycle containing height.
+func (p *ChainParams) HeightToCycle(ctx context.Context, height int64) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.build(ctx); err != nil {
+		return 0, err
+	}
+	if height < 1 {
+		return 0, nil
+	}
+	seg := p.cycles[0]
+	for _, v := range p.cycles[1:] {
+		if v.height > height {
+			break
+		}
+		seg = v
+	}
+	return seg.cycle + (height-seg.height)/seg.length, nil
+}
+
+// CycleBounds returns first and last height of cycle.
+func (p *ChainParams) CycleBounds(ctx context.Context, cycle int64) (start, end int64, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err = p.build(ctx); err != nil {
+		return
+	}
+	if cycle < 0 {
+		err = fmt.Errorf("invalid cycle %d", cycle)
+		return
+	}
+	i := 0
+	for j, v := range p.cycles {
+		if v.cycle > cycle {
+			break
+		}
+		i = j
+	}
+	seg := p.cycles[i]
+	start = seg.height + (cycle-seg.cycle)*seg.length
+	end = start + seg.length - 1
+	if i+1 < len(p.cycles) && end >= p.cycles[i+1].height {
+		end = p.cycles[i+1].height - 1
+	}
+	return
+}
+
+func (p *ChainParams) loadTip(ctx context.Context) error {
+	if p.tip != nil && time.Since(p.tipTime) < p.maxAge {
+		return nil
+	}
+	tip, err := p.params.api.GetTip(ctx)
+	if err != nil {
+		return err
+	}
+	p.tip = tip
+	p.tipTime = time.Now()
+	// a newer protocol may have been activated since deployments were loaded
+	if p.find(tip.Height) < 0 {
+		if err := p.params.Load(ctx); err != nil {
+			return err
+		}
+	}
+	return p.build(ctx)
+}
+
+// EstimateTime returns the block time at height. Past times are estimates
+// too, use the block API for exact timestamps.
+func (p *ChainParams) EstimateTime(ctx context.Context, height int64) (time.Time, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.loadTip(ctx); err != nil {
+		return time.Time{}, err
+	}
+	h0, t0 := p.tip.Height, p.tip.Timestamp
+	switch {
+	case height > h0:
+		return t0.Add(p.duration(h0, height)), nil
+	case height < h0:
+		return t0.Add(-p.duration(height, h0)), nil
+	default:
+		return t0, nil
+	}
+}
+
+// duration returns the estimated time between blocks from and to.
+func (p *ChainParams) duration(from, to int64) time.Duration {
+	var d time.Duration
+	for i, dep := range p.deps {
+		start, end := dep.StartHeight, dep.EndHeight
+		if end < 0 || i == len(p.deps)-1 {
+			end = to
+		}
+		lo, hi := max64(start, from+1), min64(end, to)
+		if hi >= lo {
+			d += time.Duration(hi-lo+1) * p.delays[i]
+		}
+	}
+	return d
+}
+
+// EstimateHeight returns the height of the block produced at or just before
+// t.
+func (p *ChainParams) EstimateHeight(ctx context.Context, t time.Time) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.loadTip(ctx); err != nil {
+		return 0, err
+	}
+	h, t0 := p.tip.Height, p.tip.Timestamp
+	i := p.find(h)
+	if i < 0 {
+		return 0, fmt.Errorf("no protocol deployment for height %d", h)
+	}
+	if !t.Before(t0) {
+		// future blocks are produced at the current protocol's delay
+		return h + int64(t.Sub(t0)/p.delays[i]), nil
+	}
+	// walk back through deployments
+	rem := t0.Sub(t)
+	for ; i >= 0; i-- {
+		dep, delay := p.deps[i], p.delays[i]
+		n := h - dep.StartHeight + 1 // blocks in this deployment up to h
+		span := time.Duration(n) * delay
+		if rem <= span || i == 0 {
+			h -= int64((rem + delay - 1) / delay)
+			if h < 0 {
+				h = 0
+			}
+			return h, nil
+		}
+		rem -= span
+		h = dep.StartHeight - 1
+	}
+	return 0, nil
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
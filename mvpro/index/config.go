@@ -21,7 +21,15 @@ type Config struct {
 	Decimals          int     `json:"decimals"`
 	MinimalStake      float64 `json:"minimal_stake"`
 	PreservedCycles   int64   `json:"preserved_cycles"`
+	BlocksPerCycle    int64   `json:"blocks_per_cycle"`
 	MinimalBlockDelay int     `json:"minimal_block_delay"`
+
+	// cost and limit parameters
+	CostPerByte                  int64 `json:"cost_per_byte"`
+	OriginationSize              int64 `json:"origination_size"`
+	HardGasLimitPerOperation     int64 `json:"hard_gas_limit_per_operation"`
+	HardGasLimitPerBlock         int64 `json:"hard_gas_limit_per_block"`
+	HardStorageLimitPerOperation int64 `json:"hard_storage_limit_per_operation"`
 }
 
 func (c *explorerClient) GetConfigHead(ctx context.Context) (*Config, error) {
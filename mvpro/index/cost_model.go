@@ -0,0 +1,55 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"context"
+)
+
+// CostModel computes operation costs with the protocol parameters in effect
+// at each op's height.
+type CostModel struct {
+	params *ProtocolParams
+}
+
+func NewCostModel(api ExplorerAPI) *CostModel {
+	return &CostModel{
+		params: NewProtocolParams(api),
+	}
+}
+
+// WithProtocolParams shares cached protocol parameters with other users.
+func (m *CostModel) WithProtocolParams(p *ProtocolParams) *CostModel {
+	m.params = p
+	return m
+}
+
+// Params returns protocol parameters active at height.
+func (m *CostModel) Params(ctx context.Context, height int64) (*Config, error) {
+	return m.params.ConfigAt(ctx, height)
+}
+
+// Costs returns costs for o based on params active at the op's height.
+func (m *CostModel) Costs(ctx context.Context, o *Op) (Costs, error) {
+	if err := m.Resolve(ctx, o); err != nil {
+		return Costs{}, err
+	}
+	return o.Costs(), nil
+}
+
+// Resolve sets cost params on ops and all their batch and internal
+// operations.
+func (m *CostModel) Resolve(ctx context.Context, ops ...*Op) error {
+	for _, op := range ops {
+		p, err := m.Params(ctx, op.Height)
+		if err != nil {
+			return err
+		}
+		op.WithCostParams(p)
+		for _, v := range op.Content() {
+			v.WithCostParams(p)
+		}
+	}
+	return nil
+}
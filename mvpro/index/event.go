@@ -4,9 +4,14 @@
 package index
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/mavryk-network/mvpro-go/internal/client"
 )
 
+var ErrNoPayload = errors.New("no event payload")
+
 type Event struct {
 	// table API only
 	RowId     uint64 `json:"row_id"`
@@ -22,6 +27,27 @@ type Event struct {
 	TypeHash string  `json:"type_hash"`
 }
 
+// Decode decodes the event payload against its declared type. The result
+// supports the same path accessors and Unmarshal as decoded storage.
+func (e Event) Decode() (*ContractValue, error) {
+	if !e.Type.IsValid() {
+		return nil, ErrNoType
+	}
+	if !e.Payload.IsValid() {
+		return nil, ErrNoPayload
+	}
+	val := NewValue(NewType(e.Type), e.Payload)
+	m, err := val.Map()
+	if err != nil {
+		return nil, fmt.Errorf("event %s %q: %v", e.Contract, e.Tag, err)
+	}
+	payload := e.Payload
+	return &ContractValue{
+		Value: m,
+		Prim:  &payload,
+	}, nil
+}
+
 type EventQuery = client.TableQuery[*Event]
 
 func (a contractClient) NewEventQuery() *EventQuery {
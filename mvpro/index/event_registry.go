@@ -0,0 +1,180 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var ErrNoEventType = errors.New("no registered event type")
+
+// EventRegistry maps contract address and event tag to Go types for event
+// payloads. Types registered with a zero contract address match the tag on
+// any contract and are used when no contract specific type exists.
+type EventRegistry struct {
+	sync.RWMutex
+	types map[eventKey]reflect.Type
+}
+
+type eventKey struct {
+	contract Address
+	tag      string
+}
+
+// DefaultEventRegistry is used by Op.DecodeEvents.
+var DefaultEventRegistry = NewEventRegistry()
+
+func NewEventRegistry() *EventRegistry {
+	return &EventRegistry{
+		types: make(map[eventKey]reflect.Type),
+	}
+}
+
+// Register registers the type of typ's value for events with tag emitted by
+// contract. Use a zero address to match all contracts.
+func (r *EventRegistry) Register(contract Address, tag string, typ any) *EventRegistry {
+	t := reflect.TypeOf(typ)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		panic(fmt.Errorf("event registry: nil type for tag %s", tag))
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.types[eventKey{contract, tag}] = t
+	return r
+}
+
+// Lookup returns the registered type for event e.
+func (r *EventRegistry) Lookup(e *Event) (reflect.Type, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	if t, ok := r.types[eventKey{e.Contract, e.Tag}]; ok {
+		return t, true
+	}
+	t, ok := r.types[eventKey{Address{}, e.Tag}]
+	return t, ok
+}
+
+// Decode decodes the payload of e into a new value of the registered type
+// and returns a pointer to it.
+func (r *EventRegistry) Decode(e *Event) (any, error) {
+	t, ok := r.Lookup(e)
+	if !ok {
+		return nil, fmt.Errorf("event %s %q: %w", e.Contract, e.Tag, ErrNoEventType)
+	}
+	cv, err := e.Decode()
+	if err != nil {
+		return nil, err
+	}
+	val := reflect.New(t)
+	if err := cv.Unmarshal(val.Interface()); err != nil {
+		return nil, fmt.Errorf("event %s %q: %v", e.Contract, e.Tag, err)
+	}
+	return val.Interface(), nil
+}
+
+// DecodedEvent is an event with its decoded payload. Value is a pointer to
+// the registered type. Op is set for events extracted from operations.
+type DecodedEvent struct {
+	Event *Event
+	Op    *Op
+	Value any
+}
+
+// DecodeList decodes all events in list that have a registered type and
+// skips all others.
+func (r *EventRegistry) DecodeList(list []*Event) ([]DecodedEvent, error) {
+	res := make([]DecodedEvent, 0, len(list))
+	for _, e := range list {
+		if _, ok := r.Lookup(e); !ok {
+			continue
+		}
+		v, err := r.Decode(e)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, DecodedEvent{Event: e, Value: v})
+	}
+	return res, nil
+}
+
+// DecodeOp decodes all registered events in o including batch and internal
+// operations.
+func (r *EventRegistry) DecodeOp(o *Op) ([]DecodedEvent, error) {
+	res := make([]DecodedEvent, 0)
+	for _, op := range o.Content() {
+		for i := range op.Events {
+			e := &op.Events[i]
+			if _, ok := r.Lookup(e); !ok {
+				continue
+			}
+			v, err := r.Decode(e)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, DecodedEvent{Event: e, Op: op, Value: v})
+		}
+	}
+	return res, nil
+}
+
+// DecodeEventAs decodes the payload of e using r and fails when the
+// registered type is not T.
+func DecodeEventAs[T any](r *EventRegistry, e *Event) (*T, error) {
+	v, err := r.Decode(e)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := v.(*T)
+	if !ok {
+		return nil, fmt.Errorf("event %s %q: decoded type %T is not %T", e.Contract, e.Tag, v, t)
+	}
+	return t, nil
+}
+
+// ListEvents returns all events emitted in o including batch and internal
+// operations. An empty tag matches all events.
+func (o *Op) ListEvents(tag string) []*Event {
+	res := make([]*Event, 0)
+	for _, op := range o.Content() {
+		for i := range op.Events {
+			if tag == "" || op.Events[i].Tag == tag {
+				res = append(res, &op.Events[i])
+			}
+		}
+	}
+	return res
+}
+
+// DecodeEvents decodes all events in o registered with DefaultEventRegistry.
+func (o *Op) DecodeEvents() ([]DecodedEvent, error) {
+	return DefaultEventRegistry.DecodeOp(o)
+}
+
+// ExtractEvents decodes all events in list with contract and tag into T.
+// A zero contract address matches all contracts. Events are decoded
+// directly into T, no registration is required.
+func ExtractEvents[T any](list []*Event, contract Address, tag string) ([]*T, error) {
+	res := make([]*T, 0)
+	for _, e := range list {
+		if e.Tag != tag || (contract.IsValid() && !e.Contract.Equal(contract)) {
+			continue
+		}
+		cv, err := e.Decode()
+		if err != nil {
+			return nil, err
+		}
+		t := new(T)
+		if err := cv.Unmarshal(t); err != nil {
+			return nil, fmt.Errorf("event %s %q: %v", e.Contract, e.Tag, err)
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
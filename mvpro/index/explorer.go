@@ -20,6 +20,7 @@ type ExplorerAPI interface {
 	ListBallots(context.Context, int, int) (BallotList, error)
 
 	NewChainQuery() *ChainQuery
+	NewSupplyQuery() *SupplyQuery
 }
 
 func NewExplorerAPI(c *client.Client) ExplorerAPI {
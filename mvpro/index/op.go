@@ -8,6 +8,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/mavryk-network/mvgo/mavryk"
@@ -30,6 +31,10 @@ type opClient struct {
 	client *client.Client
 }
 
+// DefaultStoragePrice is the burn per storage byte used when protocol
+// params are unknown.
+const DefaultStoragePrice = 0.000250
+
 type Costs struct {
 	Fee            float64 // the total fee paid
 	Burn           float64 // total amount burned (not included in fee)
@@ -121,6 +126,7 @@ type Op struct {
 	store   Type           // optional, may be decoded from script
 	eps     Entrypoints    // optional, may be decoded from script
 	bigmaps map[int64]Type // optional, may be decoded from script
+	price   float64        // optional, storage burn per byte from protocol params
 }
 
 func (o *Op) BlockId() BlockId {
@@ -200,8 +206,30 @@ func (o *Op) WithTypes(param, store Type, eps Entrypoints, b map[int64]Type) *Op
 	return o
 }
 
+// BigmapType returns the type of bigmap id when the op's script types have
+// been resolved.
+func (o Op) BigmapType(id int64) (Type, bool) {
+	typ, ok := o.bigmaps[id]
+	return typ, ok
+}
+
+// WithCostParams sets the storage price in effect at the op's height which
+// is used by Costs. See CostModel to resolve params for many ops.
+func (o *Op) WithCostParams(p *Config) *Op {
+	if p != nil && p.CostPerByte > 0 {
+		o.price = float64(p.CostPerByte) / math.Pow10(p.Decimals)
+	}
+	return o
+}
+
+// Costs splits burned funds into storage and allocation burn. Without
+// cost params DefaultStoragePrice is used.
 func (o Op) Costs() Costs {
-	storageBurn := float64(o.StoragePaid) * 0.000250
+	price := o.price
+	if price == 0 {
+		price = DefaultStoragePrice
+	}
+	storageBurn := float64(o.StoragePaid) * price
 	return Costs{
 		Fee:            o.Fee,
 		Burn:           o.Burned,
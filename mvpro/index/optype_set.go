@@ -0,0 +1,322 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// OpTypeSet is a set of operation types. Sets are values, all operations
+// return a new set. The string form is a comma separated list of type names
+// which makes sets usable as OpQuery.AndIn and AndNotIn filter values.
+type OpTypeSet [4]uint64
+
+// Predefined groups following protocol validation passes. Implicit types are
+// block level events that have no corresponding signed operation.
+var (
+	OpTypesConsensus = NewOpTypeSet(
+		OpTypeEndorsement,
+		OpTypePreendorsement,
+	)
+	OpTypesGovernance = NewOpTypeSet(
+		OpTypeProposal,
+		OpTypeBallot,
+	)
+	OpTypesDenunciation = NewOpTypeSet(
+		OpTypeDoubleBaking,
+		OpTypeDoubleEndorsement,
+		OpTypeDoublePreendorsement,
+	)
+	OpTypesAnonymous = NewOpTypeSet(
+		OpTypeNonceRevelation,
+		OpTypeVdfRevelation,
+		OpTypeActivation,
+		OpTypeDrainDelegate,
+	).Union(OpTypesDenunciation)
+	OpTypesRollup = NewOpTypeSet(
+		OpTypeRollupOrigination,
+		OpTypeRollupTransaction,
+	)
+	OpTypesStaking = NewOpTypeSet(
+		OpTypeStake,
+		OpTypeUnstake,
+		OpTypeFinalizeUnstake,
+		OpTypeSetDelegateParameters,
+	)
+	OpTypesManager = NewOpTypeSet(
+		OpTypeReveal,
+		OpTypeTransaction,
+		OpTypeOrigination,
+		OpTypeDelegation,
+		OpTypeRegisterConstant,
+		OpTypeDepositsLimit,
+		OpTypeIncreasePaidStorage,
+		OpTypeUpdateConsensusKey,
+		OpTypeTransferTicket,
+	).Union(OpTypesRollup).Union(OpTypesStaking)
+	OpTypesImplicit = NewOpTypeSet(
+		OpTypeBake,
+		OpTypeUnfreeze,
+		OpTypeInvoice,
+		OpTypeAirdrop,
+		OpTypeSeedSlash,
+		OpTypeMigration,
+		OpTypeSubsidy,
+		OpTypeDeposit,
+		OpTypeBonus,
+		OpTypeReward,
+		OpTypeStakeSlash,
+	)
+	// types that contracts can emit as internal operations
+	OpTypesInternal = NewOpTypeSet(
+		OpTypeTransaction,
+		OpTypeOrigination,
+		OpTypeDelegation,
+	)
+	OpTypesAll = OpTypesConsensus.
+			Union(OpTypesGovernance).
+			Union(OpTypesAnonymous).
+			Union(OpTypesManager).
+			Union(OpTypesImplicit)
+
+	opTypeGroups = map[string]OpTypeSet{
+		"consensus":    OpTypesConsensus,
+		"governance":   OpTypesGovernance,
+		"denunciation": OpTypesDenunciation,
+		"anonymous":    OpTypesAnonymous,
+		"rollup":       OpTypesRollup,
+		"staking":      OpTypesStaking,
+		"manager":      OpTypesManager,
+		"implicit":     OpTypesImplicit,
+		"internal":     OpTypesInternal,
+		"all":          OpTypesAll,
+	}
+)
+
+func NewOpTypeSet(types ...OpType) OpTypeSet {
+	var s OpTypeSet
+	for _, t := range types {
+		s[t>>6] |= 1 << (t & 63)
+	}
+	return s
+}
+
+// ParseOpTypeSet parses a comma separated list of type names. Group names
+// prefixed with @ like @manager expand to all group members and names
+// prefixed with - are removed, e.g. "@manager,-reveal".
+func ParseOpTypeSet(s string) (OpTypeSet, error) {
+	var set OpTypeSet
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		remove := strings.HasPrefix(v, "-")
+		v = strings.TrimPrefix(v, "-")
+		var add OpTypeSet
+		if strings.HasPrefix(v, "@") {
+			g, ok := opTypeGroups[v[1:]]
+			if !ok {
+				return OpTypeSet{}, fmt.Errorf("invalid operation type group '%s'", v)
+			}
+			add = g
+		} else {
+			t := ParseOpType(v)
+			if !t.IsValid() {
+				return OpTypeSet{}, fmt.Errorf("invalid operation type '%s'", v)
+			}
+			add = NewOpTypeSet(t)
+		}
+		if remove {
+			set = set.Difference(add)
+		} else {
+			set = set.Union(add)
+		}
+	}
+	return set, nil
+}
+
+func (s OpTypeSet) Add(types ...OpType) OpTypeSet {
+	return s.Union(NewOpTypeSet(types...))
+}
+
+func (s OpTypeSet) Remove(types ...OpType) OpTypeSet {
+	return s.Difference(NewOpTypeSet(types...))
+}
+
+func (s OpTypeSet) Contains(t OpType) bool {
+	return s[t>>6]&(1<<(t&63)) != 0
+}
+
+func (s OpTypeSet) Union(x OpTypeSet) OpTypeSet {
+	for i := range s {
+		s[i] |= x[i]
+	}
+	return s
+}
+
+func (s OpTypeSet) Intersect(x OpTypeSet) OpTypeSet {
+	for i := range s {
+		s[i] &= x[i]
+	}
+	return s
+}
+
+func (s OpTypeSet) Difference(x OpTypeSet) OpTypeSet {
+	for i := range s {
+		s[i] &^= x[i]
+	}
+	return s
+}
+
+// Complement returns all known operation types not in s.
+func (s OpTypeSet) Complement() OpTypeSet {
+	return OpTypesAll.Difference(s)
+}
+
+func (s OpTypeSet) IsEmpty() bool {
+	return s == OpTypeSet{}
+}
+
+func (s OpTypeSet) IsSubsetOf(x OpTypeSet) bool {
+	return s.Difference(x).IsEmpty()
+}
+
+func (s OpTypeSet) Len() int {
+	var n int
+	for _, v := range s {
+		n += bits.OnesCount64(v)
+	}
+	return n
+}
+
+// Types returns set members in ascending order.
+func (s OpTypeSet) Types() []OpType {
+	list := make([]OpType, 0, s.Len())
+	for i, v := range s {
+		for v != 0 {
+			n := bits.TrailingZeros64(v)
+			list = append(list, OpType(i<<6+n))
+			v &^= 1 << n
+		}
+	}
+	return list
+}
+
+func (s OpTypeSet) Strings() []string {
+	list := make([]string, 0, s.Len())
+	for _, t := range s.Types() {
+		list = append(list, t.String())
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (s OpTypeSet) String() string {
+	return strings.Join(s.Strings(), ",")
+}
+
+func (s OpTypeSet) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *OpTypeSet) UnmarshalText(data []byte) error {
+	v, err := ParseOpTypeSet(string(data))
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+// Match reports whether o or any of its batch and internal operations has
+// a type in s.
+func (s OpTypeSet) Match(o *Op) bool {
+	for _, v := range o.Content() {
+		if s.Contains(v.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns all ops in list with a type in s.
+func (s OpTypeSet) Filter(list OpList) OpList {
+	res := make(OpList, 0, len(list))
+	for _, o := range list {
+		if s.Contains(o.Type) {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+// Stream forwards all ops received from in with a type in s until in is
+// closed or ctx is canceled.
+func (s OpTypeSet) Stream(ctx context.Context, in <-chan *Op) <-chan *Op {
+	out := make(chan *Op)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case o, ok := <-in:
+				if !ok {
+					return
+				}
+				if !s.Contains(o.Type) {
+					continue
+				}
+				select {
+				case out <- o:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+func (t OpType) IsConsensus() bool {
+	return OpTypesConsensus.Contains(t)
+}
+
+func (t OpType) IsGovernance() bool {
+	return OpTypesGovernance.Contains(t)
+}
+
+func (t OpType) IsDenunciation() bool {
+	return OpTypesDenunciation.Contains(t)
+}
+
+func (t OpType) IsAnonymous() bool {
+	return OpTypesAnonymous.Contains(t)
+}
+
+func (t OpType) IsManager() bool {
+	return OpTypesManager.Contains(t)
+}
+
+func (t OpType) IsRollup() bool {
+	return OpTypesRollup.Contains(t)
+}
+
+func (t OpType) IsStaking() bool {
+	return OpTypesStaking.Contains(t)
+}
+
+func (t OpType) IsImplicit() bool {
+	return OpTypesImplicit.Contains(t)
+}
+
+// CanBeInternal reports whether contracts can emit t as internal operation.
+func (t OpType) CanBeInternal() bool {
+	return OpTypesInternal.Contains(t)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ProtocolParams resolves the protocol deployment and parameters in effect
+// at a given height. Both the deployment list and per-protocol configs are
+// cached, so each protocol is fetched only once. Share one instance between
+// users to avoid duplicate requests.
+type ProtocolParams struct {
+	mu      sync.Mutex
+	api     ExplorerAPI
+	deps    []Deployment
+	configs map[int]*Config // by deployment version
+	gen     int             // incremented when deployments are reloaded
+}
+
+func NewProtocolParams(api ExplorerAPI) *ProtocolParams {
+	return &ProtocolParams{
+		api:     api,
+		configs: make(map[int]*Config),
+	}
+}
+
+// Load reloads protocol deployments. Configs of known deployments stay
+// cached.
+func (p *ProtocolParams) Load(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.load(ctx)
+}
+
+func (p *ProtocolParams) load(ctx context.Context) error {
+	deps, err := p.api.ListProtocols(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(deps, func(i, j int) bool { return deps[i].StartHeight < deps[j].StartHeight })
+	p.deps = deps
+	p.gen++
+	return nil
+}
+
+// Deployments returns all known protocol deployments in activation order.
+func (p *ProtocolParams) Deployments(ctx context.Context) ([]Deployment, error) {
+	deps, _, err := p.deployments(ctx)
+	return deps, err
+}
+
+// deployments returns a copy of all deployments and the generation of the
+// list which changes on every reload.
+func (p *ProtocolParams) deployments(ctx context.Context) ([]Deployment, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.deps == nil {
+		if err := p.load(ctx); err != nil {
+			return nil, 0, err
+		}
+	}
+	return append([]Deployment{}, p.deps...), p.gen, nil
+}
+
+// ProtocolAt returns the protocol deployment active at height.
+func (p *ProtocolParams) ProtocolAt(ctx context.Context, height int64) (Deployment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.protocolAt(ctx, height)
+}
+
+func (p *ProtocolParams) protocolAt(ctx context.Context, height int64) (Deployment, error) {
+	if dep, ok := p.find(height); ok {
+		return dep, nil
+	}
+	// new protocol or first call
+	if err := p.load(ctx); err != nil {
+		return Deployment{}, err
+	}
+	if dep, ok := p.find(height); ok {
+		return dep, nil
+	}
+	return Deployment{}, fmt.Errorf("no protocol deployment for height %d", height)
+}
+
+func (p *ProtocolParams) find(height int64) (Deployment, bool) {
+	for _, v := range p.deps {
+		if height >= v.StartHeight && (v.EndHeight < 0 || height <= v.EndHeight) {
+			return v, true
+		}
+	}
+	return Deployment{}, false
+}
+
+// ConfigAt returns protocol parameters active at height.
+func (p *ProtocolParams) ConfigAt(ctx context.Context, height int64) (*Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dep, err := p.protocolAt(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+	return p.config(ctx, dep)
+}
+
+// Config returns protocol parameters of deployment dep.
+func (p *ProtocolParams) Config(ctx context.Context, dep Deployment) (*Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.config(ctx, dep)
+}
+
+func (p *ProtocolParams) config(ctx context.Context, dep Deployment) (*Config, error) {
+	if c, ok := p.configs[dep.Version]; ok {
+		return c, nil
+	}
+	c, err := p.api.GetConfigHeight(ctx, dep.StartHeight)
+	if err != nil {
+		return nil, err
+	}
+	p.configs[dep.Version] = c
+	return c, nil
+}
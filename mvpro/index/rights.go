@@ -93,6 +93,34 @@ func (r Rights) RightAt(height int64, typ RightType) (Right, bool) {
 	return Right{}, false
 }
 
+// Heights returns all block heights covered by this rights row at which
+// the baker holds a right of the requested type. Baking rights include
+// stolen blocks (baked without holding the right).
+func (r Rights) Heights(typ RightType) []int64 {
+	var (
+		set  util.HexBytes
+		list []int64
+	)
+	switch typ {
+	case RightTypeBaking:
+		set = r.Bake
+	case RightTypeEndorsing:
+		set = r.Endorse
+	default:
+		return nil
+	}
+	l := len(set) * 8
+	if typ == RightTypeBaking && len(r.Baked)*8 > l {
+		l = len(r.Baked) * 8
+	}
+	for i := 0; i < l; i++ {
+		if isSet(set, i) || (typ == RightTypeBaking && isSet(r.Baked, i)) {
+			list = append(list, r.Height+int64(i))
+		}
+	}
+	return list
+}
+
 type RightsList []*Rights
 
 func (l RightsList) Len() int {
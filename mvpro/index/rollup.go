@@ -4,10 +4,247 @@
 package index
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/mavryk-network/mvgo/mavryk"
 	"github.com/mavryk-network/mvgo/micheline"
+	"github.com/mavryk-network/mvpro-go/internal/client"
 )
 
+type RollupAPI interface {
+	Get(context.Context, Address, Query) (*Rollup, error)
+	ListCalls(context.Context, Address, Query) (OpList, error)
+	History(context.Context, Address, Query) (*RollupHistory, error)
+	ListCommitments(context.Context, Address, Query) ([]*RollupCommitment, error)
+	ListStakers(context.Context, Address, Query) ([]*RollupStaker, error)
+	ListGames(context.Context, Address, Query) ([]*RefutationGame, error)
+	ListOutboxMessages(context.Context, Address, Query) ([]*RollupOutboxMessage, error)
+}
+
+func NewRollupAPI(c *client.Client) RollupAPI {
+	return &rollupClient{client: c}
+}
+
+type rollupClient struct {
+	client *client.Client
+}
+
+type Rollup struct {
+	RowId                  uint64                        `json:"row_id,omitempty"`
+	Address                Address                       `json:"address"`
+	Creator                Address                       `json:"creator"`
+	PvmKind                mavryk.PvmKind                `json:"pvm_kind"`
+	ParametersTy           *micheline.Prim               `json:"parameters_ty,omitempty"`
+	FirstSeen              int64                         `json:"first_seen"`
+	LastSeen               int64                         `json:"last_seen"`
+	FirstSeenTime          time.Time                     `json:"first_seen_time"`
+	LastSeenTime           time.Time                     `json:"last_seen_time"`
+	InboxLevel             int64                         `json:"inbox_level"`
+	LastCommitment         *mavryk.SmartRollupCommitHash `json:"last_commitment,omitempty"`
+	LastCementedCommitment *mavryk.SmartRollupCommitHash `json:"last_cemented_commitment,omitempty"`
+	TotalStaked            float64                       `json:"total_staked"`
+	NStakers               int                           `json:"n_stakers"`
+	NCalls                 int64                         `json:"n_calls"`
+}
+
+func (c *rollupClient) Get(ctx context.Context, addr Address, params Query) (*Rollup, error) {
+	r := &Rollup{}
+	u := params.WithPath(fmt.Sprintf("/explorer/rollup/%s", addr)).Url()
+	if err := c.client.Get(ctx, u, nil, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *rollupClient) ListCalls(ctx context.Context, addr Address, params Query) (OpList, error) {
+	ops := make(OpList, 0)
+	u := params.WithPath(fmt.Sprintf("/explorer/rollup/%s/calls", addr)).Url()
+	if err := c.client.Get(ctx, u, nil, &ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// History fetches all rollup calls page by page in ascending order and
+// replays them. Use params to restrict the height range. The returned
+// history provides commitments, stakers, games and outbox messages from a
+// single replay, whereas each List method below replays all calls again.
+// Without a height or time bound in params the current last cemented
+// commitment is applied as well, otherwise cementation is taken from cement
+// calls inside the range only.
+func (c *rollupClient) History(ctx context.Context, addr Address, params Query) (*RollupHistory, error) {
+	const limit = 500
+	h := NewRollupHistory(addr)
+	params = params.Clone().WithLimit(limit).Asc()
+	for {
+		ops, err := c.ListCalls(ctx, addr, params)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.Add(ops...); err != nil {
+			return nil, err
+		}
+		if len(ops) < limit {
+			break
+		}
+		params = params.WithCursor(ops.Cursor())
+	}
+	if isRangeBound(params) {
+		return h, nil
+	}
+	// the explorer knows about implicit cementation which is not visible in
+	// operations since v17
+	r, err := c.Get(ctx, addr, NewQuery())
+	if err != nil {
+		return nil, fmt.Errorf("rollup %s: loading cementation state: %w", addr, err)
+	}
+	if r.LastCementedCommitment != nil {
+		h.Cement(*r.LastCementedCommitment, 0)
+	}
+	return h, nil
+}
+
+// isRangeBound reports whether params restrict calls by height or time.
+func isRangeBound(params Query) bool {
+	for key := range params.Query {
+		switch {
+		case key == "from", key == "to":
+			return true
+		case strings.HasPrefix(key, "height."), strings.HasPrefix(key, "time."):
+			return true
+		}
+	}
+	return false
+}
+
+func (c *rollupClient) ListCommitments(ctx context.Context, addr Address, params Query) ([]*RollupCommitment, error) {
+	h, err := c.History(ctx, addr, params)
+	if err != nil {
+		return nil, err
+	}
+	return h.Commitments(), nil
+}
+
+func (c *rollupClient) ListStakers(ctx context.Context, addr Address, params Query) ([]*RollupStaker, error) {
+	h, err := c.History(ctx, addr, params)
+	if err != nil {
+		return nil, err
+	}
+	return h.Stakers(), nil
+}
+
+func (c *rollupClient) ListGames(ctx context.Context, addr Address, params Query) ([]*RefutationGame, error) {
+	h, err := c.History(ctx, addr, params)
+	if err != nil {
+		return nil, err
+	}
+	return h.Games(), nil
+}
+
+func (c *rollupClient) ListOutboxMessages(ctx context.Context, addr Address, params Query) ([]*RollupOutboxMessage, error) {
+	h, err := c.History(ctx, addr, params)
+	if err != nil {
+		return nil, err
+	}
+	return h.Outbox(), nil
+}
+
+// Rollup call kinds as reported in the op's data field.
+const (
+	RollupCallOriginate     = "smart_rollup_originate"
+	RollupCallAddMessages   = "smart_rollup_add_messages"
+	RollupCallCement        = "smart_rollup_cement"
+	RollupCallPublish       = "smart_rollup_publish"
+	RollupCallRefute        = "smart_rollup_refute"
+	RollupCallTimeout       = "smart_rollup_timeout"
+	RollupCallExecuteOutbox = "smart_rollup_execute_outbox_message"
+	RollupCallRecoverBond   = "smart_rollup_recover_bond"
+)
+
+// RollupCall is a decoded smart rollup operation. Args points to the call
+// specific type, e.g. *SmartRollupPublish for publish calls.
+type RollupCall struct {
+	Op     *Op
+	Kind   string
+	Args   any
+	Result *SmartRollupResult
+}
+
+func newRollupArgs(kind string) (any, bool) {
+	switch kind {
+	case RollupCallOriginate:
+		return &SmartRollupOriginate{}, true
+	case RollupCallAddMessages:
+		return &SmartRollupAddMessages{}, true
+	case RollupCallCement:
+		return &SmartRollupCement{}, true
+	case RollupCallPublish:
+		return &SmartRollupPublish{}, true
+	case RollupCallRefute:
+		return &SmartRollupRefute{}, true
+	case RollupCallTimeout:
+		return &SmartRollupTimeout{}, true
+	case RollupCallExecuteOutbox:
+		return &SmartRollupExecuteOutboxMessage{}, true
+	case RollupCallRecoverBond:
+		return &SmartRollupRecoverBond{}, true
+	default:
+		return nil, false
+	}
+}
+
+// DecodeRollupCall decodes arguments and result of a smart rollup operation
+// into typed structs based on the call kind in the op's data field.
+func (o *Op) DecodeRollupCall() (*RollupCall, error) {
+	switch o.Type {
+	case OpTypeRollupOrigination:
+		call := &RollupCall{
+			Op:   o,
+			Kind: RollupCallOriginate,
+		}
+		if p, err := o.DecodeParams(true, 0); err == nil && len(p.Args) > 0 {
+			args := &SmartRollupOriginate{}
+			if err := json.Unmarshal(p.Args, args); err != nil {
+				return nil, fmt.Errorf("op %s %s: %v", o.Hash, call.Kind, err)
+			}
+			call.Args = args
+		}
+		return call, nil
+	case OpTypeRollupTransaction:
+	default:
+		return nil, fmt.Errorf("op %s: not a rollup operation", o.Hash)
+	}
+	p, err := o.DecodeParams(true, 0)
+	if err != nil {
+		return nil, err
+	}
+	kind := p.Kind
+	if p.Method != "" {
+		kind = strings.Trim(p.Method, `"`)
+	}
+	call := &RollupCall{
+		Op:   o,
+		Kind: kind,
+	}
+	if args, ok := newRollupArgs(kind); ok && len(p.Args) > 0 {
+		if err := json.Unmarshal(p.Args, args); err != nil {
+			return nil, fmt.Errorf("op %s %s: %v", o.Hash, kind, err)
+		}
+		call.Args = args
+	}
+	if len(p.Result) > 0 {
+		call.Result = &SmartRollupResult{}
+		if err := json.Unmarshal(p.Result, call.Result); err != nil {
+			return nil, fmt.Errorf("op %s %s result: %v", o.Hash, kind, err)
+		}
+	}
+	return call, nil
+}
+
 type SmartRollupResult struct {
 	Address          *mavryk.Address               `json:"address,omitempty"`
 	Size             *mavryk.Z                     `json:"size,omitempty"`
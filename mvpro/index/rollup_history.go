@@ -0,0 +1,342 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package index
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mavryk-network/mvgo/mavryk"
+)
+
+type RollupCommitment struct {
+	Hash        mavryk.SmartRollupCommitHash `json:"hash"`
+	Predecessor mavryk.SmartRollupCommitHash `json:"predecessor"`
+	State       mavryk.SmartRollupStateHash  `json:"compressed_state"`
+	InboxLevel  int64                        `json:"inbox_level"`
+	Ticks       Z                            `json:"number_of_ticks"`
+	PublishedAt int64                        `json:"published_at"`
+	Publishers  []Address                    `json:"publishers"`
+	IsCemented  bool                         `json:"is_cemented"`
+	CementedAt  int64                        `json:"cemented_at,omitempty"` // zero when unknown
+}
+
+type RollupStaker struct {
+	Address        Address                      `json:"address"`
+	Bond           float64                      `json:"bond"`
+	LostBond       float64                      `json:"lost_bond,omitempty"`
+	FirstSeen      int64                        `json:"first_seen"`
+	LastSeen       int64                        `json:"last_seen"`
+	LastCommitment mavryk.SmartRollupCommitHash `json:"last_commitment"`
+	NCommitments   int                          `json:"n_commitments"`
+	IsActive       bool                         `json:"is_active"`
+	IsSlashed      bool                         `json:"is_slashed"`
+	RecoveredAt    int64                        `json:"recovered_at,omitempty"`
+}
+
+// RefutationGame is a dispute between two stakers. Player is the staker who
+// started the game. Moves lists all refute and timeout operations in order.
+type RefutationGame struct {
+	Player             Address                       `json:"player"`
+	Opponent           Address                       `json:"opponent"`
+	PlayerCommitment   *mavryk.SmartRollupCommitHash `json:"player_commitment,omitempty"`
+	OpponentCommitment *mavryk.SmartRollupCommitHash `json:"opponent_commitment,omitempty"`
+	StartHeight        int64                         `json:"start_height"`
+	StartTime          time.Time                     `json:"start_time"`
+	EndHeight          int64                         `json:"end_height,omitempty"`
+	EndTime            time.Time                     `json:"end_time,omitempty"`
+	Winner             Address                       `json:"winner"`
+	Loser              Address                       `json:"loser"`
+	Reason             string                        `json:"reason,omitempty"`
+	IsEnded            bool                          `json:"is_ended"`
+	IsDraw             bool                          `json:"is_draw"`
+	Moves              []*Op                         `json:"-"`
+}
+
+type RollupOutboxMessage struct {
+	Hash               OpHash                       `json:"hash"`
+	Height             int64                        `json:"height"`
+	Time               time.Time                    `json:"time"`
+	Executor           Address                      `json:"executor"`
+	CementedCommitment mavryk.SmartRollupCommitHash `json:"cemented_commitment"`
+	IsSuccess          bool                         `json:"is_success"`
+	Transactions       []*Op                        `json:"-"` // executed outbox transactions
+}
+
+type gameKey [2]Address
+
+func newGameKey(a, b Address) gameKey {
+	if a.String() > b.String() {
+		a, b = b, a
+	}
+	return gameKey{a, b}
+}
+
+// RollupHistory reconstructs commitments, stakers, refutation games and
+// outbox executions of a single rollup by replaying its operations in
+// ascending order.
+type RollupHistory struct {
+	Rollup  Address
+	commits map[mavryk.SmartRollupCommitHash]*RollupCommitment
+	stakers map[Address]*RollupStaker
+	games   []*RefutationGame
+	open    map[gameKey]*RefutationGame
+	outbox  []*RollupOutboxMessage
+}
+
+func NewRollupHistory(rollup Address) *RollupHistory {
+	return &RollupHistory{
+		Rollup:  rollup,
+		commits: make(map[mavryk.SmartRollupCommitHash]*RollupCommitment),
+		stakers: make(map[Address]*RollupStaker),
+		open:    make(map[gameKey]*RefutationGame),
+	}
+}
+
+// Add replays ops including batch and internal operations. Failed and non
+// rollup operations and operations on other rollups are ignored.
+func (h *RollupHistory) Add(ops ...*Op) error {
+	for _, o := range ops {
+		for _, op := range o.Content() {
+			if op.Type != OpTypeRollupTransaction || !op.IsSuccess {
+				continue
+			}
+			if h.Rollup.IsValid() && op.Receiver.IsValid() && !op.Receiver.Equal(h.Rollup) {
+				continue
+			}
+			call, err := op.DecodeRollupCall()
+			if err != nil {
+				return err
+			}
+			h.apply(call)
+		}
+	}
+	return nil
+}
+
+func (h *RollupHistory) apply(c *RollupCall) {
+	op := c.Op
+	switch args := c.Args.(type) {
+	case *SmartRollupPublish:
+		if c.Result == nil || c.Result.StakedHash == nil {
+			return
+		}
+		hash := *c.Result.StakedHash
+		cm, ok := h.commits[hash]
+		if !ok {
+			cm = &RollupCommitment{
+				Hash:        hash,
+				Predecessor: args.Commitment.Predecessor,
+				State:       args.Commitment.CompressedState,
+				InboxLevel:  args.Commitment.InboxLevel,
+				Ticks:       args.Commitment.NumberOfTicks,
+				PublishedAt: c.Result.PublishedAtLevel,
+			}
+			if cm.PublishedAt == 0 {
+				cm.PublishedAt = op.Height
+			}
+			h.commits[hash] = cm
+		}
+		if !containsAddress(cm.Publishers, op.Sender) {
+			cm.Publishers = append(cm.Publishers, op.Sender)
+		}
+		s := h.staker(op.Sender, op.Height)
+		s.Bond += op.Deposit
+		s.LastCommitment = hash
+		s.NCommitments++
+		s.IsActive = true
+
+	case *SmartRollupCement:
+		hash := args.Commitment
+		if hash == nil && c.Result != nil {
+			hash = c.Result.Commitment
+		}
+		if hash != nil {
+			h.Cement(*hash, op.Height)
+		}
+
+	case *SmartRollupRefute:
+		g := h.game(op, op.Sender, args.Opponent)
+		if len(g.Moves) == 1 {
+			g.PlayerCommitment = args.Refutation.PlayerHash
+			g.OpponentCommitment = args.Refutation.OpponentHash
+		}
+		h.finish(g, c)
+
+	case *SmartRollupTimeout:
+		g := h.game(op, args.Stakers.Alice, args.Stakers.Bob)
+		h.finish(g, c)
+
+	case *SmartRollupRecoverBond:
+		if s, ok := h.stakers[args.Staker]; ok {
+			s.Bond = 0
+			s.IsActive = false
+			s.RecoveredAt = op.Height
+			s.LastSeen = op.Height
+		}
+
+	case *SmartRollupExecuteOutboxMessage:
+		h.outbox = append(h.outbox, &RollupOutboxMessage{
+			Hash:               op.Hash,
+			Height:             op.Height,
+			Time:               op.Timestamp,
+			Executor:           op.Sender,
+			CementedCommitment: args.CementedCommitment,
+			IsSuccess:          op.IsSuccess,
+			Transactions:       op.Internal,
+		})
+		// only cemented commitments can be used for outbox execution
+		h.Cement(args.CementedCommitment, 0)
+	}
+}
+
+// Cement marks commitment hash and all its predecessors as cemented. Use a
+// zero height when the cementation height is unknown.
+func (h *RollupHistory) Cement(hash mavryk.SmartRollupCommitHash, height int64) {
+	for {
+		cm, ok := h.commits[hash]
+		if !ok || cm.IsCemented {
+			return
+		}
+		cm.IsCemented = true
+		cm.CementedAt = height
+		hash = cm.Predecessor
+	}
+}
+
+func (h *RollupHistory) staker(addr Address, height int64) *RollupStaker {
+	s, ok := h.stakers[addr]
+	if !ok {
+		s = &RollupStaker{
+			Address:   addr,
+			FirstSeen: height,
+		}
+		h.stakers[addr] = s
+	}
+	s.LastSeen = height
+	return s
+}
+
+// game returns the open game between a and b or starts a new game with a
+// as player.
+func (h *RollupHistory) game(op *Op, a, b Address) *RefutationGame {
+	key := newGameKey(a, b)
+	g, ok := h.open[key]
+	if !ok {
+		g = &RefutationGame{
+			Player:      a,
+			Opponent:    b,
+			StartHeight: op.Height,
+			StartTime:   op.Timestamp,
+		}
+		h.open[key] = g
+		h.games = append(h.games, g)
+	}
+	g.Moves = append(g.Moves, op)
+	return g
+}
+
+// finish ends g when the game status of c reports a result. Winner and
+// loser are taken from the op when set, otherwise from the game status.
+func (h *RollupHistory) finish(g *RefutationGame, c *RollupCall) {
+	op := c.Op
+	var status string
+	var kind, reason string
+	var player *Address
+	if c.Result != nil && c.Result.GameStatus != nil {
+		status = c.Result.GameStatus.Status
+		kind = c.Result.GameStatus.Kind
+		reason = c.Result.GameStatus.Reason
+		player = c.Result.GameStatus.Player
+	}
+	if status != "ended" && kind == "" && !op.Loser.IsValid() {
+		return
+	}
+	g.IsEnded = true
+	g.EndHeight = op.Height
+	g.EndTime = op.Timestamp
+	g.Reason = reason
+	switch {
+	case op.Loser.IsValid():
+		g.Winner, g.Loser = op.Winner, op.Loser
+	case kind == "draw":
+		g.IsDraw = true
+	case player != nil:
+		g.Loser = *player
+		if g.Loser.Equal(g.Player) {
+			g.Winner = g.Opponent
+		} else {
+			g.Winner = g.Player
+		}
+	}
+	if g.IsDraw {
+		h.slash(g.Player, op.Height)
+		h.slash(g.Opponent, op.Height)
+	} else if g.Loser.IsValid() {
+		h.slash(g.Loser, op.Height)
+	}
+	delete(h.open, newGameKey(g.Player, g.Opponent))
+}
+
+func (h *RollupHistory) slash(addr Address, height int64) {
+	s, ok := h.stakers[addr]
+	if !ok {
+		return
+	}
+	s.LostBond += s.Bond
+	s.Bond = 0
+	s.IsActive = false
+	s.IsSlashed = true
+	s.LastSeen = height
+}
+
+// Commitments returns all published commitments sorted by inbox level.
+func (h *RollupHistory) Commitments() []*RollupCommitment {
+	list := make([]*RollupCommitment, 0, len(h.commits))
+	for _, v := range h.commits {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].InboxLevel == list[j].InboxLevel {
+			return list[i].PublishedAt < list[j].PublishedAt
+		}
+		return list[i].InboxLevel < list[j].InboxLevel
+	})
+	return list
+}
+
+// Stakers returns all stakers sorted by first publication.
+func (h *RollupHistory) Stakers() []*RollupStaker {
+	list := make([]*RollupStaker, 0, len(h.stakers))
+	for _, v := range h.stakers {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].FirstSeen == list[j].FirstSeen {
+			return list[i].Address.String() < list[j].Address.String()
+		}
+		return list[i].FirstSeen < list[j].FirstSeen
+	})
+	return list
+}
+
+// Games returns all refutation games in start order including games that
+// are still ongoing.
+func (h *RollupHistory) Games() []*RefutationGame {
+	return h.games
+}
+
+// Outbox returns all outbox message executions in order.
+func (h *RollupHistory) Outbox() []*RollupOutboxMessage {
+	return h.outbox
+}
+
+func containsAddress(list []Address, a Address) bool {
+	for _, v := range list {
+		if v.Equal(a) {
+			return true
+		}
+	}
+	return false
+}
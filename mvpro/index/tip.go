@@ -6,6 +6,8 @@ package index
 import (
 	"context"
 	"time"
+
+	"github.com/mavryk-network/mvpro-go/internal/client"
 )
 
 type Tip struct {
@@ -88,3 +90,9 @@ type Supply struct {
 	FrozenFees          float64   `json:"frozen_fees"`
 	FrozenBonds         float64   `json:"frozen_bonds"`
 }
+
+type SupplyQuery = client.TableQuery[*Supply]
+
+func (c *explorerClient) NewSupplyQuery() *SupplyQuery {
+	return client.NewTableQuery[*Supply](c.client, "supply")
+}
@@ -0,0 +1,329 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package ipfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mavryk-network/mvgo/base58"
+)
+
+var (
+	ErrInvalidCid     = errors.New("invalid cid")
+	ErrUnsupportedCid = errors.New("unsupported cid")
+	ErrCidMismatch    = errors.New("content does not match cid")
+)
+
+// Multicodec and multihash codes
+const (
+	CodecRaw    uint64 = 0x55
+	CodecDagPb  uint64 = 0x70
+	HashSha2256 uint64 = 0x12
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Cid is a content identifier. Only sha2-256 multihashes are supported
+// which covers all CIDs created by IPFS with default settings.
+type Cid struct {
+	Version uint64
+	Codec   uint64
+	Hash    uint64
+	Digest  []byte
+}
+
+// ParseCid parses a base58 CIDv0 (Qm...) or a base32 (b...) or base58
+// (z...) multibase encoded CIDv1. Leading ipfs:// and /ipfs/ prefixes and
+// trailing paths are stripped.
+func ParseCid(s string) (Cid, error) {
+	s = strings.TrimPrefix(s, "ipfs://")
+	s = strings.TrimPrefix(s, "/ipfs/")
+	if i := strings.IndexAny(s, "/?#"); i >= 0 {
+		s = s[:i]
+	}
+	var buf []byte
+	switch {
+	case len(s) == 46 && strings.HasPrefix(s, "Qm"):
+		buf = base58.Decode(s, nil)
+		c, err := parseMultihash(buf)
+		if err != nil {
+			return Cid{}, err
+		}
+		c.Codec = CodecDagPb
+		return c, nil
+	case len(s) > 1 && (s[0] == 'b' || s[0] == 'B'):
+		var err error
+		buf, err = b32.DecodeString(strings.ToUpper(s[1:]))
+		if err != nil {
+			return Cid{}, fmt.Errorf("%w %q: %v", ErrInvalidCid, s, err)
+		}
+	case len(s) > 1 && s[0] == 'z':
+		buf = base58.Decode(s[1:], nil)
+	default:
+		return Cid{}, fmt.Errorf("%w %q", ErrInvalidCid, s)
+	}
+	version, n := binary.Uvarint(buf)
+	if n <= 0 || version != 1 {
+		return Cid{}, fmt.Errorf("%w %q: bad version", ErrInvalidCid, s)
+	}
+	buf = buf[n:]
+	codec, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return Cid{}, fmt.Errorf("%w %q: bad codec", ErrInvalidCid, s)
+	}
+	c, err := parseMultihash(buf[n:])
+	if err != nil {
+		return Cid{}, err
+	}
+	c.Version = 1
+	c.Codec = codec
+	return c, nil
+}
+
+func parseMultihash(buf []byte) (Cid, error) {
+	code, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return Cid{}, fmt.Errorf("%w: bad multihash", ErrInvalidCid)
+	}
+	buf = buf[n:]
+	l, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) != l {
+		return Cid{}, fmt.Errorf("%w: bad multihash length", ErrInvalidCid)
+	}
+	return Cid{Hash: code, Digest: buf[n:]}, nil
+}
+
+func (c Cid) IsValid() bool {
+	return len(c.Digest) > 0
+}
+
+// Bytes returns the binary CID. Version 0 CIDs are bare multihashes.
+func (c Cid) Bytes() []byte {
+	buf := make([]byte, 0, len(c.Digest)+8)
+	if c.Version > 0 {
+		buf = binary.AppendUvarint(buf, c.Version)
+		buf = binary.AppendUvarint(buf, c.Codec)
+	}
+	buf = binary.AppendUvarint(buf, c.Hash)
+	buf = binary.AppendUvarint(buf, uint64(len(c.Digest)))
+	return append(buf, c.Digest...)
+}
+
+func (c Cid) String() string {
+	if !c.IsValid() {
+		return ""
+	}
+	if c.Version == 0 {
+		return base58.Encode(c.Bytes())
+	}
+	return "b" + strings.ToLower(b32.EncodeToString(c.Bytes()))
+}
+
+func (c Cid) Equal(x Cid) bool {
+	return c.Version == x.Version && c.Codec == x.Codec && c.Hash == x.Hash && bytes.Equal(c.Digest, x.Digest)
+}
+
+// Verify checks data against c. Raw CIDs hash data directly. DAG-PB CIDs
+// are rebuilt as UnixFS file with the default IPFS importer settings, i.e.
+// 256 KiB chunks in a balanced DAG with 174 links per node. Content added
+// with other settings fails to verify.
+func (c Cid) Verify(data []byte) error {
+	v, err := NewVerifier(c)
+	if err != nil {
+		return err
+	}
+	v.Write(data)
+	return v.Verify()
+}
+
+const (
+	chunkSize = 256 << 10
+	maxLinks  = 174
+)
+
+// Verifier checks streamed content against a CID without buffering more
+// than a single chunk.
+type Verifier struct {
+	cid    Cid
+	buf    []byte
+	leaves []dagLink
+	single []byte // digest of first chunk as single node
+	size   int64
+}
+
+type dagLink struct {
+	cid   []byte // binary cid
+	tsize uint64 // cumulative block size
+	fsize uint64 // file bytes below link
+}
+
+func NewVerifier(c Cid) (*Verifier, error) {
+	if c.Hash != HashSha2256 {
+		return nil, fmt.Errorf("%w: multihash 0x%x", ErrUnsupportedCid, c.Hash)
+	}
+	if c.Codec != CodecRaw && c.Codec != CodecDagPb {
+		return nil, fmt.Errorf("%w: codec 0x%x", ErrUnsupportedCid, c.Codec)
+	}
+	return &Verifier{
+		cid: c,
+		buf: make([]byte, 0, chunkSize),
+	}, nil
+}
+
+// Write implements io.Writer and never fails.
+func (v *Verifier) Write(p []byte) (int, error) {
+	n := len(p)
+	v.size += int64(n)
+	for len(p) > 0 {
+		k := chunkSize - len(v.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		v.buf = append(v.buf, p[:k]...)
+		p = p[k:]
+		if len(v.buf) == chunkSize {
+			v.flush()
+		}
+	}
+	return n, nil
+}
+
+// Size returns the number of bytes written.
+func (v *Verifier) Size() int64 {
+	return v.size
+}
+
+func (v *Verifier) flush() {
+	if len(v.leaves) == 0 {
+		// keep in case the file is exactly one chunk
+		v.single = singleNode(v.buf)
+	}
+	v.leaves = append(v.leaves, v.leaf(v.buf))
+	v.buf = v.buf[:0]
+}
+
+// leaf encodes a file chunk. CIDv1 DAGs use raw leaves, CIDv0 DAGs wrap
+// chunks into UnixFS file nodes.
+func (v *Verifier) leaf(data []byte) dagLink {
+	if v.cid.Version > 0 {
+		h := sha256.Sum256(data)
+		c := Cid{Version: 1, Codec: CodecRaw, Hash: HashSha2256, Digest: h[:]}
+		return dagLink{cid: c.Bytes(), tsize: uint64(len(data)), fsize: uint64(len(data))}
+	}
+	block := encodeNode(nil, encodeUnixfs(data, uint64(len(data)), nil))
+	return v.node(block, uint64(len(data)), 0)
+}
+
+func (v *Verifier) node(block []byte, fsize, linkSize uint64) dagLink {
+	h := sha256.Sum256(block)
+	c := Cid{Version: v.cid.Version, Codec: CodecDagPb, Hash: HashSha2256, Digest: h[:]}
+	return dagLink{cid: c.Bytes(), tsize: uint64(len(block)) + linkSize, fsize: fsize}
+}
+
+// Verify returns nil when all written content matches the CID.
+func (v *Verifier) Verify() error {
+	var got []byte
+	switch v.cid.Codec {
+	case CodecRaw:
+		if len(v.leaves) > 0 {
+			return fmt.Errorf("%w %s: raw block larger than %d bytes", ErrCidMismatch, v.cid, chunkSize)
+		}
+		h := sha256.Sum256(v.buf)
+		got = h[:]
+	default:
+		got = v.root()
+	}
+	if !bytes.Equal(got, v.cid.Digest) {
+		return fmt.Errorf("%w %s", ErrCidMismatch, v.cid)
+	}
+	return nil
+}
+
+// root builds the balanced DAG over all leaves and returns the root digest.
+func (v *Verifier) root() []byte {
+	switch {
+	case len(v.leaves) == 0:
+		return singleNode(v.buf)
+	case len(v.leaves) == 1 && len(v.buf) == 0:
+		return v.single
+	}
+	links := v.leaves
+	if len(v.buf) > 0 {
+		links = append(links, v.leaf(v.buf))
+	}
+	for len(links) > 1 {
+		next := make([]dagLink, 0, len(links)/maxLinks+1)
+		for i := 0; i < len(links); i += maxLinks {
+			j := i + maxLinks
+			if j > len(links) {
+				j = len(links)
+			}
+			next = append(next, v.parent(links[i:j]))
+		}
+		links = next
+	}
+	c := links[0].cid
+	return c[len(c)-sha256.Size:]
+}
+
+// singleNode returns the digest of a file that fits into a single chunk.
+// Such files are stored as a single UnixFS node.
+func singleNode(data []byte) []byte {
+	h := sha256.Sum256(encodeNode(nil, encodeUnixfs(data, uint64(len(data)), nil)))
+	return h[:]
+}
+
+func (v *Verifier) parent(links []dagLink) dagLink {
+	var fsize, lsize uint64
+	sizes := make([]uint64, len(links))
+	for i, l := range links {
+		fsize += l.fsize
+		lsize += l.tsize
+		sizes[i] = l.fsize
+	}
+	block := encodeNode(links, encodeUnixfs(nil, fsize, sizes))
+	return v.node(block, fsize, lsize)
+}
+
+// encodeUnixfs encodes a UnixFS file node (Type=File, Data, filesize,
+// blocksizes).
+func encodeUnixfs(data []byte, fsize uint64, blocksizes []uint64) []byte {
+	buf := []byte{0x08, 0x02}
+	if len(data) > 0 {
+		buf = appendBytes(buf, 0x12, data)
+	}
+	buf = append(buf, 0x18)
+	buf = binary.AppendUvarint(buf, fsize)
+	for _, s := range blocksizes {
+		buf = append(buf, 0x20)
+		buf = binary.AppendUvarint(buf, s)
+	}
+	return buf
+}
+
+// encodeNode encodes a DAG-PB node with links before data as required by
+// the canonical encoding.
+func encodeNode(links []dagLink, data []byte) []byte {
+	buf := make([]byte, 0, len(data)+len(links)*48+8)
+	for _, l := range links {
+		link := appendBytes(nil, 0x0a, l.cid)
+		link = append(link, 0x12, 0x00) // empty name
+		link = append(link, 0x18)
+		link = binary.AppendUvarint(link, l.tsize)
+		buf = appendBytes(buf, 0x12, link)
+	}
+	return appendBytes(buf, 0x0a, data)
+}
+
+func appendBytes(buf []byte, tag byte, data []byte) []byte {
+	buf = append(buf, tag)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
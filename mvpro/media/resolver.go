@@ -0,0 +1,204 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package media
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/mavryk-network/mvpro-go/internal/util"
+	"github.com/mavryk-network/mvpro-go/mvpro/ipfs"
+)
+
+var (
+	ErrNoMedia        = errors.New("no matching media")
+	ErrUnsupportedUri = errors.New("unsupported media uri")
+)
+
+// Blob is fetched media content. Data is only set for cached blobs.
+type Blob struct {
+	Media    Media  `json:"media"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	Verified bool   `json:"verified"`
+	Cached   bool   `json:"cached"`
+	Data     []byte `json:"-"`
+}
+
+// Resolver resolves TZIP-21 token media to a representation and fetches
+// its content through the IPFS API. Content of plain CIDs is verified
+// while streaming. Blobs up to a maximum size are cached by CID.
+type Resolver struct {
+	api      IpfsAPI
+	cache    *lru.TwoQueueCache[string, *Blob]
+	maxCache int64
+	verify   bool
+}
+
+func NewResolver(api IpfsAPI) *Resolver {
+	cache, _ := lru.New2Q[string, *Blob](1024)
+	return &Resolver{
+		api:      api,
+		cache:    cache,
+		maxCache: 1 << 20,
+		verify:   true,
+	}
+}
+
+// WithCacheSize sets the number of cached blobs. Zero disables caching.
+func (r *Resolver) WithCacheSize(n int) *Resolver {
+	if n <= 0 {
+		r.cache = nil
+		return r
+	}
+	r.cache, _ = lru.New2Q[string, *Blob](n)
+	return r
+}
+
+// WithMaxCacheItemSize sets the size of the largest cached blob.
+func (r *Resolver) WithMaxCacheItemSize(n int64) *Resolver {
+	r.maxCache = n
+	return r
+}
+
+// WithVerify enables or disables CID verification.
+func (r *Resolver) WithVerify(b bool) *Resolver {
+	r.verify = b
+	return r
+}
+
+// Resolve picks the best representation for req from the TZIP-21 contents
+// of m.
+func (r *Resolver) Resolve(m Metadata, req Request) (Media, error) {
+	if !m.Has("tz21") {
+		return Media{}, fmt.Errorf("%w for %s: no tz21 metadata", ErrNoMedia, m.ID())
+	}
+	med, ok := Select(m.Tz21(), req)
+	if !ok {
+		return Media{}, fmt.Errorf("%w for %s %s", ErrNoMedia, m.ID(), req.Role)
+	}
+	return med, nil
+}
+
+// Get resolves media for req and streams its content to w.
+func (r *Resolver) Get(ctx context.Context, m Metadata, req Request, w io.Writer) (*Blob, error) {
+	med, err := r.Resolve(m, req)
+	if err != nil {
+		return nil, err
+	}
+	return r.Fetch(ctx, med, w)
+}
+
+// Fetch streams the content of med to w. On ipfs.ErrCidMismatch content
+// has already been written and callers must discard it. Content below a
+// directory CID cannot be verified and is returned with Verified false.
+func (r *Resolver) Fetch(ctx context.Context, med Media, w io.Writer) (*Blob, error) {
+	key := med.Key()
+	if r.cache != nil {
+		if b, ok := r.cache.Get(key); ok {
+			if _, err := w.Write(b.Data); err != nil {
+				return nil, err
+			}
+			res := *b
+			res.Media = med
+			res.Cached = true
+			return &res, nil
+		}
+	}
+
+	blob := &Blob{Media: med, MimeType: med.MimeType}
+	var (
+		verifier *ipfs.Verifier
+		capture  = util.NewLimitedBuffer(r.maxCache)
+		writers  = []io.Writer{w, capture}
+	)
+	if r.verify && med.IsIpfs() && med.Path() == "" {
+		v, err := ipfs.NewVerifier(med.Cid)
+		if err == nil {
+			verifier = v
+			writers = append(writers, v)
+		}
+	}
+	counter := &countWriter{}
+	writers = append(writers, counter)
+	dst := io.MultiWriter(writers...)
+
+	switch {
+	case med.IsIpfs():
+		mime := med.MimeType
+		if mime == "" {
+			mime = "*/*"
+		}
+		if err := r.api.GetImage(ctx, "ipfs://"+key, mime, dst); err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(med.Uri, "data:"):
+		data, mime, err := decodeDataUri(med.Uri)
+		if err != nil {
+			return nil, err
+		}
+		if blob.MimeType == "" {
+			blob.MimeType = mime
+		}
+		if _, err := dst.Write(data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedUri, med.Uri)
+	}
+	blob.Size = counter.n
+
+	if verifier != nil {
+		if err := verifier.Verify(); err != nil {
+			return nil, err
+		}
+		blob.Verified = true
+	}
+	if r.cache != nil && !capture.Overflow() {
+		cached := *blob
+		cached.Data = capture.Bytes()
+		r.cache.Add(key, &cached)
+	}
+	return blob, nil
+}
+
+// Purge drops all cached blobs.
+func (r *Resolver) Purge() {
+	if r.cache != nil {
+		r.cache.Purge()
+	}
+}
+
+// decodeDataUri decodes RFC 2397 data uris.
+func decodeDataUri(uri string) ([]byte, string, error) {
+	head, body, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok {
+		return nil, "", fmt.Errorf("%w: malformed data uri", ErrUnsupportedUri)
+	}
+	mime, isBase64 := strings.CutSuffix(head, ";base64")
+	if i := strings.IndexByte(mime, ';'); i >= 0 {
+		mime = mime[:i]
+	}
+	if isBase64 {
+		data, err := base64.StdEncoding.DecodeString(body)
+		return data, mime, err
+	}
+	s, err := url.PathUnescape(body)
+	return []byte(s), mime, err
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
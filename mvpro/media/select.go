@@ -0,0 +1,236 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package media
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mavryk-network/mvpro-go/mvpro/ipfs"
+)
+
+// Role is the purpose of a media representation as defined by TZIP-21.
+type Role string
+
+const (
+	RoleArtifact  Role = "artifact"
+	RoleDisplay   Role = "display"
+	RoleThumbnail Role = "thumbnail"
+	RoleAlternate Role = "alternate" // format not referenced by any role uri
+)
+
+// fallbacks lists roles that may stand in for a requested role in order
+// of preference.
+var fallbacks = map[Role][]Role{
+	RoleArtifact:  {RoleArtifact},
+	RoleDisplay:   {RoleDisplay, RoleAlternate, RoleArtifact},
+	RoleThumbnail: {RoleThumbnail, RoleAlternate, RoleDisplay, RoleArtifact},
+}
+
+// Media is a single representation of a token's media. Properties come from
+// the matching TZIP-21 format entry if one exists. Unknown sizes are zero.
+type Media struct {
+	Role     Role   `json:"role"`
+	Uri      string `json:"uri"`
+	Cid      Cid    `json:"-"`
+	MimeType string `json:"mime_type,omitempty"`
+	FileSize int64  `json:"file_size,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// IsIpfs reports whether m is stored on IPFS.
+func (m Media) IsIpfs() bool {
+	return m.Cid.IsValid()
+}
+
+// IsImage reports whether m has an image mime type.
+func (m Media) IsImage() bool {
+	return strings.HasPrefix(m.MimeType, "image/")
+}
+
+// Path returns the path below the CID for directory uris like
+// ipfs://<cid>/image.png.
+func (m Media) Path() string {
+	s := strings.TrimPrefix(m.Uri, "ipfs://")
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		return s[i:]
+	}
+	return ""
+}
+
+// Key returns the cache key of m, its CID and path for IPFS media and its
+// uri otherwise.
+func (m Media) Key() string {
+	if m.IsIpfs() {
+		return m.Cid.String() + m.Path()
+	}
+	return m.Uri
+}
+
+// ListMedia returns all distinct media representations of tz. Role uris
+// come first, followed by formats that no role references.
+func ListMedia(tz *Tz21Metadata) []Media {
+	res := make([]Media, 0, len(tz.Formats)+3)
+	seen := make(map[string]int)
+	add := func(role Role, uri string) {
+		if uri == "" {
+			return
+		}
+		if _, ok := seen[uri]; ok {
+			return
+		}
+		seen[uri] = len(res)
+		m := Media{Role: role, Uri: uri}
+		m.Cid, _ = ipfs.ParseCid(uri)
+		res = append(res, m)
+	}
+	add(RoleArtifact, tz.ArtifactUri)
+	add(RoleDisplay, tz.DisplayUri)
+	add(RoleThumbnail, tz.ThumbnailUri)
+	for _, f := range tz.Formats {
+		add(RoleAlternate, f.Uri)
+		m := &res[seen[f.Uri]]
+		if f.MimeType != "" {
+			m.MimeType = f.MimeType
+		}
+		if f.FileSize > 0 {
+			m.FileSize = f.FileSize
+		}
+		if f.FileName != "" {
+			m.FileName = f.FileName
+		}
+		if f.Duration != "" {
+			m.Duration = f.Duration
+		}
+		if w, h, ok := parseDimensions(f.Dimensions.Value, f.Dimensions.Unit); ok {
+			m.Width, m.Height = w, h
+		}
+	}
+	return res
+}
+
+// parseDimensions reads pixel dimensions like 1920x1080.
+func parseDimensions(val, unit string) (int, int, bool) {
+	if unit != "" && unit != "px" {
+		return 0, 0, false
+	}
+	w, h, ok := strings.Cut(strings.ToLower(strings.TrimSpace(val)), "x")
+	if !ok {
+		return 0, 0, false
+	}
+	wi, err1 := strconv.Atoi(strings.TrimSpace(w))
+	hi, err2 := strconv.Atoi(strings.TrimSpace(h))
+	if err1 != nil || err2 != nil || wi <= 0 || hi <= 0 {
+		return 0, 0, false
+	}
+	return wi, hi, true
+}
+
+// Request describes the representation a caller wants.
+type Request struct {
+	Role     Role
+	Width    int      // minimum width in px, zero for any
+	Height   int      // minimum height in px, zero for any
+	MaxBytes int64    // maximum file size, zero for any
+	Mime     []string // accepted mime types or prefixes like image/, empty for any
+}
+
+func NewRequest(role Role) Request {
+	return Request{Role: role}
+}
+
+func (r Request) WithSize(w, h int) Request {
+	r.Width, r.Height = w, h
+	return r
+}
+
+func (r Request) WithMaxBytes(n int64) Request {
+	r.MaxBytes = n
+	return r
+}
+
+func (r Request) WithMime(types ...string) Request {
+	r.Mime = append(r.Mime, types...)
+	return r
+}
+
+func (r Request) accepts(m Media) bool {
+	if r.MaxBytes > 0 && m.FileSize > r.MaxBytes {
+		return false
+	}
+	if len(r.Mime) == 0 {
+		return true
+	}
+	if m.MimeType == "" {
+		// unknown type, let the gateway decide
+		return true
+	}
+	for _, v := range r.Mime {
+		if v == m.MimeType || (strings.HasSuffix(v, "/") && strings.HasPrefix(m.MimeType, v)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Request) covers(m Media) bool {
+	return m.Width >= r.Width && m.Height >= r.Height
+}
+
+// Select picks the best representation in tz for req. Candidates are
+// ranked by role with fallbacks from thumbnail to display and artifact.
+// When a size is requested the smallest candidate covering it wins,
+// otherwise the largest available one.
+func Select(tz *Tz21Metadata, req Request) (Media, bool) {
+	roles, ok := fallbacks[req.Role]
+	if !ok {
+		return Media{}, false
+	}
+	rank := make(map[Role]int, len(roles))
+	for i, r := range roles {
+		rank[r] = i
+	}
+	var (
+		best  Media
+		found bool
+	)
+	for _, m := range ListMedia(tz) {
+		if _, ok := rank[m.Role]; !ok || !req.accepts(m) {
+			continue
+		}
+		// alternates only stand in for images
+		if m.Role == RoleAlternate && !m.IsImage() {
+			continue
+		}
+		if !found || req.better(m, best, rank) {
+			best, found = m, true
+		}
+	}
+	return best, found
+}
+
+// better reports whether a is preferred over b.
+func (r Request) better(a, b Media, rank map[Role]int) bool {
+	if r.Width > 0 || r.Height > 0 {
+		ca, cb := r.covers(a), r.covers(b)
+		switch {
+		case ca && !cb:
+			return true
+		case !ca && cb:
+			return false
+		case ca && cb:
+			if pa, pb := a.Width*a.Height, b.Width*b.Height; pa != pb {
+				return pa < pb
+			}
+		default:
+			if pa, pb := a.Width*a.Height, b.Width*b.Height; pa != pb {
+				return pa > pb
+			}
+		}
+	}
+	return rank[a.Role] < rank[b.Role]
+}
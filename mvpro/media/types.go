@@ -0,0 +1,17 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package media
+
+import (
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+	"github.com/mavryk-network/mvpro-go/mvpro/ipfs"
+)
+
+type (
+	Metadata     = index.Metadata
+	Tz21Metadata = index.Tz21Metadata
+	Tz21Format   = index.Tz21Format
+	IpfsAPI      = ipfs.IpfsAPI
+	Cid          = ipfs.Cid
+)
@@ -0,0 +1,546 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mavryk-network/mvgo/mavryk"
+)
+
+// Schema is a compiled JSON schema. The supported subset of draft-07 covers
+// what metadata schemas use: type, enum, const, properties, required,
+// additionalProperties, items, string, number and array bounds, pattern,
+// format, allOf/anyOf/oneOf/not and local $ref to definitions.
+type Schema struct {
+	Types                []string
+	Properties           map[string]*Schema
+	Required             []string
+	AdditionalProperties *Schema
+	Items                *Schema
+	Enum                 []any
+	Const                any
+	HasConst             bool
+	Pattern              *regexp.Regexp
+	Format               string
+	MinLength            *int
+	MaxLength            *int
+	Minimum              *float64
+	Maximum              *float64
+	ExclusiveMinimum     *float64
+	ExclusiveMaximum     *float64
+	MinItems             *int
+	MaxItems             *int
+	UniqueItems          bool
+	AllOf                []*Schema
+	AnyOf                []*Schema
+	OneOf                []*Schema
+	Not                  *Schema
+	Ref                  string
+
+	always *bool   // boolean schema
+	root   *Schema // for $ref resolution
+	defs   map[string]*Schema
+}
+
+// Compile parses a JSON schema document.
+func Compile(buf []byte) (*Schema, error) {
+	var doc any
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return nil, err
+	}
+	root := &Schema{}
+	if err := root.compile(doc, root, ""); err != nil {
+		return nil, err
+	}
+	// resolve refs eagerly to report broken references at compile time
+	var check func(s *Schema) error
+	seen := make(map[*Schema]bool)
+	check = func(s *Schema) error {
+		if s == nil || seen[s] {
+			return nil
+		}
+		seen[s] = true
+		if s.Ref != "" {
+			if _, err := s.resolve(); err != nil {
+				return err
+			}
+		}
+		for _, v := range s.children() {
+			if err := check(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := check(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func (s *Schema) children() []*Schema {
+	list := make([]*Schema, 0)
+	for _, v := range s.Properties {
+		list = append(list, v)
+	}
+	for _, v := range s.defs {
+		list = append(list, v)
+	}
+	list = append(list, s.AllOf...)
+	list = append(list, s.AnyOf...)
+	list = append(list, s.OneOf...)
+	for _, v := range []*Schema{s.AdditionalProperties, s.Items, s.Not} {
+		if v != nil {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func (s *Schema) compile(doc any, root *Schema, path string) error {
+	s.root = root
+	switch v := doc.(type) {
+	case bool:
+		s.always = &v
+		return nil
+	case map[string]any:
+		return s.compileObject(v, root, path)
+	default:
+		return fmt.Errorf("schema %s: expected object or boolean", pointerOrRoot(path))
+	}
+}
+
+func (s *Schema) compileObject(m map[string]any, root *Schema, path string) error {
+	sub := func(key string, v any) (*Schema, error) {
+		c := &Schema{}
+		if err := c.compile(v, root, path+"/"+key); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	subList := func(key string, v any) ([]*Schema, error) {
+		arr, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("schema %s/%s: expected array", path, key)
+		}
+		list := make([]*Schema, len(arr))
+		for i, e := range arr {
+			c, err := sub(key+"/"+strconv.Itoa(i), e)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = c
+		}
+		return list, nil
+	}
+	var err error
+	for key, v := range m {
+		switch key {
+		case "type":
+			switch t := v.(type) {
+			case string:
+				s.Types = []string{t}
+			case []any:
+				for _, e := range t {
+					if str, ok := e.(string); ok {
+						s.Types = append(s.Types, str)
+					}
+				}
+			}
+		case "properties":
+			props, ok := v.(map[string]any)
+			if !ok {
+				return fmt.Errorf("schema %s/properties: expected object", path)
+			}
+			s.Properties = make(map[string]*Schema, len(props))
+			for name, p := range props {
+				if s.Properties[name], err = sub("properties/"+escapePointer(name), p); err != nil {
+					return err
+				}
+			}
+		case "definitions", "$defs":
+			defs, ok := v.(map[string]any)
+			if !ok {
+				return fmt.Errorf("schema %s/%s: expected object", path, key)
+			}
+			if s.defs == nil {
+				s.defs = make(map[string]*Schema)
+			}
+			for name, p := range defs {
+				if s.defs[key+"/"+name], err = sub(key+"/"+escapePointer(name), p); err != nil {
+					return err
+				}
+			}
+		case "required":
+			for _, e := range asList(v) {
+				if str, ok := e.(string); ok {
+					s.Required = append(s.Required, str)
+				}
+			}
+		case "additionalProperties":
+			if s.AdditionalProperties, err = sub(key, v); err != nil {
+				return err
+			}
+		case "items":
+			if s.Items, err = sub(key, v); err != nil {
+				return err
+			}
+		case "enum":
+			s.Enum = asList(v)
+		case "const":
+			s.Const, s.HasConst = v, true
+		case "pattern":
+			str, _ := v.(string)
+			if s.Pattern, err = regexp.Compile(str); err != nil {
+				return fmt.Errorf("schema %s/pattern: %v", path, err)
+			}
+		case "format":
+			s.Format, _ = v.(string)
+		case "minLength":
+			s.MinLength = asInt(v)
+		case "maxLength":
+			s.MaxLength = asInt(v)
+		case "minItems":
+			s.MinItems = asInt(v)
+		case "maxItems":
+			s.MaxItems = asInt(v)
+		case "uniqueItems":
+			s.UniqueItems, _ = v.(bool)
+		case "minimum":
+			s.Minimum = asFloat(v)
+		case "maximum":
+			s.Maximum = asFloat(v)
+		case "exclusiveMinimum":
+			s.ExclusiveMinimum = asFloat(v)
+		case "exclusiveMaximum":
+			s.ExclusiveMaximum = asFloat(v)
+		case "allOf":
+			if s.AllOf, err = subList(key, v); err != nil {
+				return err
+			}
+		case "anyOf":
+			if s.AnyOf, err = subList(key, v); err != nil {
+				return err
+			}
+		case "oneOf":
+			if s.OneOf, err = subList(key, v); err != nil {
+				return err
+			}
+		case "not":
+			if s.Not, err = sub(key, v); err != nil {
+				return err
+			}
+		case "$ref":
+			s.Ref, _ = v.(string)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) resolve() (*Schema, error) {
+	ref := s.Ref
+	if ref == "#" {
+		return s.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("schema: unsupported $ref %q", ref)
+	}
+	name := unescapePointer(strings.TrimPrefix(ref, "#/"))
+	if d, ok := s.root.defs[name]; ok {
+		return d, nil
+	}
+	return nil, fmt.Errorf("schema: unresolved $ref %q", ref)
+}
+
+// FieldError is a single validation failure. Pointer is a JSON pointer to
+// the offending value relative to the validated document.
+type FieldError struct {
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return pointerOrRoot(e.Pointer) + ": " + e.Message
+}
+
+// Validate checks a decoded JSON value against s and returns all errors.
+// Pointers in errors are prefixed with ptr.
+func (s *Schema) Validate(val any, ptr string) []FieldError {
+	var errs []FieldError
+	s.validate(val, ptr, &errs, 0)
+	return errs
+}
+
+// Valid reports whether val conforms to s.
+func (s *Schema) Valid(val any) bool {
+	return len(s.Validate(val, "")) == 0
+}
+
+const maxDepth = 64
+
+func (s *Schema) validate(val any, ptr string, errs *[]FieldError, depth int) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, FieldError{Pointer: ptr, Message: fmt.Sprintf(format, args...)})
+	}
+	if depth > maxDepth {
+		fail("schema recursion too deep")
+		return
+	}
+	if s.always != nil {
+		if !*s.always {
+			fail("value not allowed")
+		}
+		return
+	}
+	if s.Ref != "" {
+		r, err := s.resolve()
+		if err != nil {
+			fail("%v", err)
+			return
+		}
+		r.validate(val, ptr, errs, depth+1)
+		return
+	}
+	if len(s.Types) > 0 && !matchType(s.Types, val) {
+		fail("expected %s, got %s", strings.Join(s.Types, " or "), jsonType(val))
+		return
+	}
+	if len(s.Enum) > 0 {
+		var ok bool
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, val) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			fail("value %s not in enum", short(val))
+		}
+	}
+	if s.HasConst && !reflect.DeepEqual(s.Const, val) {
+		fail("value must be %s", short(s.Const))
+	}
+
+	switch v := val.(type) {
+	case string:
+		n := len([]rune(v))
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("string shorter than %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("string longer than %d", *s.MaxLength)
+		}
+		if s.Pattern != nil && !s.Pattern.MatchString(v) {
+			fail("string does not match pattern %q", s.Pattern.String())
+		}
+		if msg := checkFormat(s.Format, v); msg != "" {
+			fail("%s", msg)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("value %v less than minimum %v", v, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("value %v greater than maximum %v", v, *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum {
+			fail("value %v must be greater than %v", v, *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum {
+			fail("value %v must be less than %v", v, *s.ExclusiveMaximum)
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("array has fewer than %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("array has more than %d items", *s.MaxItems)
+		}
+		if s.UniqueItems {
+		outer:
+			for i := range v {
+				for j := 0; j < i; j++ {
+					if reflect.DeepEqual(v[i], v[j]) {
+						fail("array items %d and %d are equal", j, i)
+						break outer
+					}
+				}
+			}
+		}
+		if s.Items != nil {
+			for i, e := range v {
+				s.Items.validate(e, ptr+"/"+strconv.Itoa(i), errs, depth+1)
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, FieldError{
+					Pointer: ptr + "/" + escapePointer(name),
+					Message: "required field missing",
+				})
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := ptr + "/" + escapePointer(k)
+			if prop, ok := s.Properties[k]; ok {
+				prop.validate(v[k], p, errs, depth+1)
+			} else if s.AdditionalProperties != nil {
+				if a := s.AdditionalProperties; a.always != nil && !*a.always {
+					*errs = append(*errs, FieldError{Pointer: p, Message: "unknown field"})
+				} else {
+					a.validate(v[k], p, errs, depth+1)
+				}
+			}
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		sub.validate(val, ptr, errs, depth+1)
+	}
+	if len(s.AnyOf) > 0 {
+		var ok bool
+		for _, sub := range s.AnyOf {
+			if len(sub.Validate(val, ptr)) == 0 {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			fail("value matches none of anyOf")
+		}
+	}
+	if len(s.OneOf) > 0 {
+		var n int
+		for _, sub := range s.OneOf {
+			if len(sub.Validate(val, ptr)) == 0 {
+				n++
+			}
+		}
+		if n != 1 {
+			fail("value matches %d of oneOf, expected exactly one", n)
+		}
+	}
+	if s.Not != nil && len(s.Not.Validate(val, ptr)) == 0 {
+		fail("value must not match schema")
+	}
+}
+
+func matchType(types []string, val any) bool {
+	t := jsonType(val)
+	for _, v := range types {
+		if v == t || (v == "number" && t == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonType(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", val)
+	}
+}
+
+func checkFormat(format, s string) string {
+	switch format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			return "invalid date-time"
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", s); err != nil {
+			return "invalid date"
+		}
+	case "uri":
+		if u, err := url.Parse(s); err != nil || u.Scheme == "" {
+			return "invalid uri"
+		}
+	case "email":
+		if i := strings.IndexByte(s, '@'); i < 1 || i == len(s)-1 {
+			return "invalid email"
+		}
+	case "address":
+		if _, err := mavryk.ParseAddress(s); err != nil {
+			return "invalid address"
+		}
+	}
+	return ""
+}
+
+func asList(v any) []any {
+	l, _ := v.([]any)
+	return l
+}
+
+func asInt(v any) *int {
+	f, ok := v.(float64)
+	if !ok {
+		return nil
+	}
+	i := int(f)
+	return &i
+}
+
+func asFloat(v any) *float64 {
+	f, ok := v.(float64)
+	if !ok {
+		return nil
+	}
+	return &f
+}
+
+func short(v any) string {
+	buf, _ := json.Marshal(v)
+	if len(buf) > 40 {
+		return string(buf[:37]) + "..."
+	}
+	return string(buf)
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+func unescapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~1", "/"), "~0", "~")
+}
+
+func pointerOrRoot(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return p
+}
@@ -0,0 +1,519 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/echa/log"
+	"github.com/mavryk-network/mvgo/mavryk"
+)
+
+var ErrTooManyDeletes = errors.New("plan deletes too many entries")
+
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+func (a Action) Symbol() string {
+	switch a {
+	case ActionCreate:
+		return "+"
+	case ActionUpdate:
+		return "~"
+	case ActionDelete:
+		return "-"
+	default:
+		return " "
+	}
+}
+
+// FieldDiff is a changed value at a JSON pointer inside a metadata
+// document. Old is nil for added and New is nil for removed fields.
+type FieldDiff struct {
+	Path string `json:"path"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+func (d FieldDiff) String() string {
+	switch {
+	case d.Old == nil:
+		return fmt.Sprintf("+ %s = %s", d.Path, short(d.New))
+	case d.New == nil:
+		return fmt.Sprintf("- %s = %s", d.Path, short(d.Old))
+	default:
+		return fmt.Sprintf("~ %s: %s => %s", d.Path, short(d.Old), short(d.New))
+	}
+}
+
+// Change is a single planned operation on a metadata entry identified by
+// Metadata.ID().
+type Change struct {
+	ID     string      `json:"id"`
+	Action Action      `json:"action"`
+	Local  *Metadata   `json:"local,omitempty"`
+	Remote *Metadata   `json:"remote,omitempty"`
+	Diffs  []FieldDiff `json:"diffs,omitempty"`
+}
+
+// Plan is the ordered set of changes that turns remote into local state.
+type Plan struct {
+	Changes   []Change `json:"changes"`
+	Unchanged int      `json:"unchanged"`
+	Remote    int      `json:"remote"`
+}
+
+func (p Plan) IsEmpty() bool {
+	return len(p.Changes) == 0
+}
+
+// Count returns the number of changes with action a.
+func (p Plan) Count(a Action) int {
+	var n int
+	for _, c := range p.Changes {
+		if c.Action == a {
+			n++
+		}
+	}
+	return n
+}
+
+func (p Plan) Summary() string {
+	return fmt.Sprintf("%d to create, %d to update, %d to delete, %d unchanged",
+		p.Count(ActionCreate), p.Count(ActionUpdate), p.Count(ActionDelete), p.Unchanged)
+}
+
+func (p Plan) String() string {
+	var b strings.Builder
+	for _, c := range p.Changes {
+		fmt.Fprintf(&b, "%s %s\n", c.Action.Symbol(), c.ID)
+		for _, d := range c.Diffs {
+			fmt.Fprintf(&b, "    %s\n", d)
+		}
+	}
+	fmt.Fprintf(&b, "Plan: %s\n", p.Summary())
+	return b.String()
+}
+
+// ApplyResult counts changes applied to the remote state.
+type ApplyResult struct {
+	Created int  `json:"created"`
+	Updated int  `json:"updated"`
+	Deleted int  `json:"deleted"`
+	DryRun  bool `json:"dry_run"`
+}
+
+// Syncer synchronizes metadata entries from local files to the metadata API
+// in two steps. Plan compares local entries against remote state and Apply
+// executes the resulting changes. Remote entries missing locally are deleted
+// unless pruning is disabled. A guard refuses plans that delete more than
+// a configured number of entries.
+type Syncer struct {
+	api        MetadataAPI
+	validator  *Validator
+	batchSize  int
+	dryRun     bool
+	prune      bool
+	maxDeletes int
+	ignore     map[string]bool
+	log        log.Logger
+}
+
+func NewSyncer(api MetadataAPI) *Syncer {
+	return &Syncer{
+		api:        api,
+		batchSize:  100,
+		prune:      true,
+		maxDeletes: 10,
+		ignore:     map[string]bool{"updated": true},
+		log:        log.Disabled,
+	}
+}
+
+// WithValidator checks local entries before planning.
+func (s *Syncer) WithValidator(v *Validator) *Syncer {
+	s.validator = v
+	return s
+}
+
+// WithBatchSize sets the number of entries created per API call.
+func (s *Syncer) WithBatchSize(n int) *Syncer {
+	if n > 0 {
+		s.batchSize = n
+	}
+	return s
+}
+
+// WithDryRun makes Apply log changes without calling the API.
+func (s *Syncer) WithDryRun(b bool) *Syncer {
+	s.dryRun = b
+	return s
+}
+
+// WithPrune controls whether remote entries without local file are deleted.
+func (s *Syncer) WithPrune(b bool) *Syncer {
+	s.prune = b
+	return s
+}
+
+// WithMaxDeletes sets the maximum number of deletes Apply accepts. Use -1
+// to disable the guard.
+func (s *Syncer) WithMaxDeletes(n int) *Syncer {
+	s.maxDeletes = n
+	return s
+}
+
+// WithIgnore excludes content keys from comparison. Server-managed keys
+// like `updated` are ignored by default.
+func (s *Syncer) WithIgnore(keys ...string) *Syncer {
+	for _, k := range keys {
+		s.ignore[k] = true
+	}
+	return s
+}
+
+func (s *Syncer) WithLogger(l log.Logger) *Syncer {
+	s.log = l
+	return s
+}
+
+// LoadDir reads all *.json files below dir. Each file contains a single
+// metadata document or an array of documents. Duplicate IDs are an error.
+func LoadDir(dir string) ([]Metadata, error) {
+	res := make([]Metadata, 0)
+	seen := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		list, err := LoadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, m := range list {
+			id := m.ID()
+			if prev, ok := seen[id]; ok {
+				return fmt.Errorf("%s: duplicate entry %s, first defined in %s", path, id, prev)
+			}
+			seen[id] = path
+			res = append(res, m)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// LoadFile reads a single metadata document or an array of documents.
+func LoadFile(fname string) ([]Metadata, error) {
+	buf, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	buf = bytes.TrimSpace(buf)
+	var list []Metadata
+	if len(buf) > 0 && buf[0] == '[' {
+		err = json.Unmarshal(buf, &list)
+	} else {
+		var m Metadata
+		err = json.Unmarshal(buf, &m)
+		list = []Metadata{m}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+	for _, m := range list {
+		if !m.Address.IsValid() {
+			return nil, fmt.Errorf("%s: missing or invalid address", fname)
+		}
+	}
+	return list, nil
+}
+
+// Plan fetches remote state and computes changes required to match local.
+func (s *Syncer) Plan(ctx context.Context, local []Metadata) (*Plan, error) {
+	if s.validator != nil {
+		if err := s.validator.ValidateList(local); err != nil {
+			return nil, err
+		}
+	}
+	remote, err := s.api.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Diff(local, remote)
+}
+
+// Diff computes changes required to turn remote into local state without
+// calling the API.
+func (s *Syncer) Diff(local, remote []Metadata) (*Plan, error) {
+	rmap := make(map[string]int, len(remote))
+	for i, m := range remote {
+		rmap[m.ID()] = i
+	}
+	lmap := make(map[string]bool, len(local))
+	plan := &Plan{
+		Changes: make([]Change, 0),
+		Remote:  len(remote),
+	}
+	for i := range local {
+		l := &local[i]
+		id := l.ID()
+		if lmap[id] {
+			return nil, fmt.Errorf("duplicate local entry %s", id)
+		}
+		lmap[id] = true
+		lv, err := s.normalize(*l)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", id, err)
+		}
+		j, ok := rmap[id]
+		if !ok {
+			plan.Changes = append(plan.Changes, Change{
+				ID:     id,
+				Action: ActionCreate,
+				Local:  l,
+				Diffs:  diffValues("", nil, lv),
+			})
+			continue
+		}
+		r := &remote[j]
+		rv, err := s.normalize(*r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", id, err)
+		}
+		diffs := diffValues("", rv, lv)
+		if len(diffs) == 0 {
+			plan.Unchanged++
+			continue
+		}
+		plan.Changes = append(plan.Changes, Change{
+			ID:     id,
+			Action: ActionUpdate,
+			Local:  l,
+			Remote: r,
+			Diffs:  diffs,
+		})
+	}
+	if s.prune {
+		for i := range remote {
+			r := &remote[i]
+			id := r.ID()
+			if lmap[id] {
+				continue
+			}
+			rv, err := s.normalize(*r)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", id, err)
+			}
+			plan.Changes = append(plan.Changes, Change{
+				ID:     id,
+				Action: ActionDelete,
+				Remote: r,
+				Diffs:  diffValues("", rv, nil),
+			})
+		}
+	}
+	// deterministic order: creates, updates, deletes, each by id
+	order := map[Action]int{ActionCreate: 0, ActionUpdate: 1, ActionDelete: 2}
+	sort.SliceStable(plan.Changes, func(i, j int) bool {
+		ci, cj := plan.Changes[i], plan.Changes[j]
+		if ci.Action != cj.Action {
+			return order[ci.Action] < order[cj.Action]
+		}
+		return ci.ID < cj.ID
+	})
+	return plan, nil
+}
+
+// normalize converts metadata contents into generic JSON values so local
+// and remote documents compare equal independent of their Go types. Empty
+// values are dropped because typed contents cannot distinguish them from
+// unset fields.
+func (s *Syncer) normalize(m Metadata) (map[string]any, error) {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]any
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return nil, err
+	}
+	delete(v, "address")
+	delete(v, "asset_id")
+	for k := range s.ignore {
+		delete(v, k)
+	}
+	v, _ = prune(v).(map[string]any)
+	return v, nil
+}
+
+// zeroTime is how zero time.Time values marshal.
+const zeroTime = "0001-01-01T00:00:00Z"
+
+func prune(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, x := range val {
+			if x = prune(x); x == nil {
+				delete(val, k)
+			} else {
+				val[k] = x
+			}
+		}
+		if len(val) == 0 {
+			return nil
+		}
+	case []any:
+		if len(val) == 0 {
+			return nil
+		}
+		for i, x := range val {
+			val[i] = prune(x)
+		}
+	case string:
+		if val == "" || val == zeroTime {
+			return nil
+		}
+	case float64:
+		if val == 0 {
+			return nil
+		}
+	case bool:
+		if !val {
+			return nil
+		}
+	}
+	return v
+}
+
+// diffValues returns differences between a and b as JSON pointer paths.
+// Objects are compared by key, all other values as a whole.
+func diffValues(path string, a, b any) []FieldDiff {
+	am, aok := a.(map[string]any)
+	bm, bok := b.(map[string]any)
+	if (aok || a == nil) && (bok || b == nil) && (aok || bok) {
+		keys := make(map[string]bool)
+		for k := range am {
+			keys[k] = true
+		}
+		for k := range bm {
+			keys[k] = true
+		}
+		names := make([]string, 0, len(keys))
+		for k := range keys {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		diffs := make([]FieldDiff, 0)
+		for _, k := range names {
+			diffs = append(diffs, diffValues(path+"/"+escapePointer(k), am[k], bm[k])...)
+		}
+		return diffs
+	}
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	return []FieldDiff{{Path: path, Old: a, New: b}}
+}
+
+// Check returns ErrTooManyDeletes when plan violates the delete guard.
+func (s *Syncer) Check(plan *Plan) error {
+	n := plan.Count(ActionDelete)
+	if s.maxDeletes >= 0 && n > s.maxDeletes {
+		return fmt.Errorf("%w: %d of %d remote entries, limit is %d",
+			ErrTooManyDeletes, n, plan.Remote, s.maxDeletes)
+	}
+	return nil
+}
+
+// Apply executes plan. Creates are sent in batches, updates and deletes one
+// entry at a time. Apply stops at the first error and returns the changes
+// applied so far. In dry-run mode changes are only logged.
+func (s *Syncer) Apply(ctx context.Context, plan *Plan) (*ApplyResult, error) {
+	if err := s.Check(plan); err != nil {
+		return nil, err
+	}
+	res := &ApplyResult{DryRun: s.dryRun}
+	creates := make([]Metadata, 0)
+	for _, c := range plan.Changes {
+		if c.Action == ActionCreate {
+			creates = append(creates, *c.Local)
+		}
+	}
+	for len(creates) > 0 {
+		n := s.batchSize
+		if n > len(creates) {
+			n = len(creates)
+		}
+		batch := creates[:n]
+		creates = creates[n:]
+		s.log.Debugf("metadata: create %d entries", len(batch))
+		if !s.dryRun {
+			if _, err := s.api.Create(ctx, batch); err != nil {
+				return res, fmt.Errorf("create %s..: %w", batch[0].ID(), err)
+			}
+		}
+		res.Created += len(batch)
+	}
+	for _, c := range plan.Changes {
+		switch c.Action {
+		case ActionUpdate:
+			s.log.Debugf("metadata: update %s (%d fields)", c.ID, len(c.Diffs))
+			if !s.dryRun {
+				if _, err := s.api.Update(ctx, *c.Local); err != nil {
+					return res, fmt.Errorf("update %s: %w", c.ID, err)
+				}
+			}
+			res.Updated++
+		case ActionDelete:
+			s.log.Debugf("metadata: delete %s", c.ID)
+			if !s.dryRun {
+				if err := s.remove(ctx, *c.Remote); err != nil {
+					return res, fmt.Errorf("delete %s: %w", c.ID, err)
+				}
+			}
+			res.Deleted++
+		}
+	}
+	return res, nil
+}
+
+func (s *Syncer) remove(ctx context.Context, m Metadata) error {
+	if m.TokenId != nil {
+		return s.api.RemoveAsset(ctx, mavryk.NewToken(m.Address, *m.TokenId))
+	}
+	return s.api.RemoveWallet(ctx, m.Address)
+}
+
+// Sync plans and applies changes from local to remote state.
+func (s *Syncer) Sync(ctx context.Context, local []Metadata) (*Plan, *ApplyResult, error) {
+	plan, err := s.Plan(ctx, local)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.log.Infof("metadata: %s", plan.Summary())
+	res, err := s.Apply(ctx, plan)
+	return plan, res, err
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package metadata
+
+import (
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+type (
+	Address     = mavryk.Address
+	Z           = mavryk.Z
+	Metadata    = index.Metadata
+	MetadataAPI = index.MetadataAPI
+)
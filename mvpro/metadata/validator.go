@@ -0,0 +1,293 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mavryk-network/mvgo/mavryk"
+)
+
+//go:embed schemas/*.json
+var embedded embed.FS
+
+// Schema sources
+const (
+	SourceEmbedded = "embedded"
+	SourceRemote   = "remote"
+	SourceLocal    = "local"
+)
+
+// ValidationError is a field-level error in a metadata document. Pointer is
+// a JSON pointer into the document, e.g. /alias/name.
+type ValidationError struct {
+	ID      string `json:"id"`
+	Key     string `json:"key"`
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.ID == "" {
+		return pointerOrRoot(e.Pointer) + ": " + e.Message
+	}
+	return e.ID + ": " + pointerOrRoot(e.Pointer) + ": " + e.Message
+}
+
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	switch len(e) {
+	case 0:
+		return "no errors"
+	case 1:
+		return e[0].Error()
+	default:
+		return fmt.Sprintf("%s (and %d more errors)", e[0].Error(), len(e)-1)
+	}
+}
+
+// IsValidationError returns all field-level errors wrapped in err.
+func IsValidationError(err error) (ValidationErrors, bool) {
+	e, ok := err.(ValidationErrors)
+	return e, ok
+}
+
+// Validator checks metadata documents against JSON schemas per content key.
+// Schemas embedded in the SDK are used until Fetch or Load replace them.
+// A Validator is safe for concurrent use.
+type Validator struct {
+	mu      sync.RWMutex
+	schemas map[string]*Schema
+	source  string
+	strict  bool
+}
+
+// NewValidator returns a validator using the embedded schemas.
+func NewValidator() *Validator {
+	v := &Validator{
+		schemas: make(map[string]*Schema),
+		source:  SourceEmbedded,
+		strict:  true,
+	}
+	if err := v.load(EmbeddedSchemas(), SourceEmbedded); err != nil {
+		panic(fmt.Errorf("metadata: invalid embedded schema: %v", err))
+	}
+	return v
+}
+
+// WithStrict controls whether content keys without schema are reported.
+// Strict mode is the default.
+func (v *Validator) WithStrict(b bool) *Validator {
+	v.strict = b
+	return v
+}
+
+// EmbeddedSchemas returns the raw schemas shipped with the SDK.
+func EmbeddedSchemas() map[string]json.RawMessage {
+	res := make(map[string]json.RawMessage)
+	files, _ := embedded.ReadDir("schemas")
+	for _, f := range files {
+		buf, err := embedded.ReadFile(path.Join("schemas", f.Name()))
+		if err != nil {
+			continue
+		}
+		res[strings.TrimSuffix(f.Name(), ".json")] = buf
+	}
+	return res
+}
+
+// Fetch loads schemas from the API and replaces known schemas. On error
+// the current schemas are kept.
+func (v *Validator) Fetch(ctx context.Context, api MetadataAPI) error {
+	schemas, err := api.GetSchemas(ctx)
+	if err != nil {
+		return err
+	}
+	return v.load(schemas, SourceRemote)
+}
+
+// Load compiles schemas by content key and replaces known schemas. Keys
+// not contained in schemas keep their current schema.
+func (v *Validator) Load(schemas map[string]json.RawMessage) error {
+	return v.load(schemas, SourceLocal)
+}
+
+func (v *Validator) load(schemas map[string]json.RawMessage, source string) error {
+	compiled := make(map[string]*Schema, len(schemas))
+	for key, buf := range schemas {
+		s, err := Compile(buf)
+		if err != nil {
+			return fmt.Errorf("schema %s: %v", key, err)
+		}
+		compiled[key] = s
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, s := range compiled {
+		v.schemas[key] = s
+	}
+	v.source = source
+	return nil
+}
+
+// Source returns where the most recently loaded schemas came from.
+func (v *Validator) Source() string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.source
+}
+
+// Keys returns all content keys with a known schema.
+func (v *Validator) Keys() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	keys := make([]string, 0, len(v.schemas))
+	for k := range v.schemas {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Schema returns the compiled schema for content key.
+func (v *Validator) Schema(key string) (*Schema, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	s, ok := v.schemas[key]
+	return s, ok
+}
+
+// Validate checks all contents of m. It returns ValidationErrors or nil.
+func (v *Validator) Validate(m Metadata) error {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return v.ValidateJSON(buf)
+}
+
+// ValidateList checks all documents in list and returns their combined
+// errors.
+func (v *Validator) ValidateList(list []Metadata) error {
+	errs := make(ValidationErrors, 0)
+	for _, m := range list {
+		err := v.Validate(m)
+		if err == nil {
+			continue
+		}
+		if e, ok := IsValidationError(err); ok {
+			errs = append(errs, e...)
+		} else {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidateJSON checks a single JSON metadata document or an array of
+// documents in the format used by MetadataAPI. Errors are checked before
+// decoding into Metadata so values that would not survive decoding
+// are reported too.
+func (v *Validator) ValidateJSON(buf []byte) error {
+	buf = bytes.TrimSpace(buf)
+	if len(buf) > 0 && buf[0] == '[' {
+		var list []json.RawMessage
+		if err := json.Unmarshal(buf, &list); err != nil {
+			return err
+		}
+		errs := make(ValidationErrors, 0)
+		for i, doc := range list {
+			errs = append(errs, v.validateDoc(doc, "/"+fmt.Sprint(i))...)
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+		return nil
+	}
+	if errs := v.validateDoc(buf, ""); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (v *Validator) validateDoc(buf []byte, prefix string) ValidationErrors {
+	var doc map[string]any
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return ValidationErrors{{Pointer: prefix, Message: err.Error()}}
+	}
+	errs := make(ValidationErrors, 0)
+	fail := func(id, key, ptr, msg string) {
+		errs = append(errs, ValidationError{ID: id, Key: key, Pointer: ptr, Message: msg})
+	}
+
+	// document identity
+	var id string
+	addr, ok := doc["address"].(string)
+	if !ok || addr == "" {
+		fail("", "address", prefix+"/address", "required field missing")
+	} else if a, err := mavryk.ParseAddress(addr); err != nil {
+		id = addr
+		fail(id, "address", prefix+"/address", "invalid address")
+	} else {
+		id = a.String()
+	}
+	if tid, ok := doc["asset_id"]; ok {
+		// token ids are sent as decimal strings, numbers are accepted too
+		var z Z
+		var err error
+		switch t := tid.(type) {
+		case string:
+			err = z.UnmarshalText([]byte(t))
+		case float64:
+			err = z.UnmarshalText([]byte(fmt.Sprint(int64(t))))
+			if t != float64(int64(t)) {
+				err = fmt.Errorf("fractional")
+			}
+		default:
+			err = fmt.Errorf("invalid type")
+		}
+		if err != nil || z.IsNeg() {
+			fail(id, "asset_id", prefix+"/asset_id", "expected non-negative integer")
+		} else {
+			id += "_" + z.String()
+		}
+	}
+
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		if k != "address" && k != "asset_id" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for _, key := range keys {
+		ptr := prefix + "/" + escapePointer(key)
+		s, ok := v.schemas[key]
+		if !ok {
+			if v.strict {
+				fail(id, key, ptr, "unknown metadata key")
+			}
+			continue
+		}
+		for _, e := range s.Validate(doc[key], ptr) {
+			fail(id, key, e.Pointer, e.Message)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package monitor
+
+import (
+	"fmt"
+	"time"
+)
+
+type AlertType byte
+
+const (
+	AlertTypeInvalid AlertType = iota
+	AlertTypeMissedEndorsement
+	AlertTypeLostBlock
+	AlertTypeStolenBlock
+	AlertTypeSeedUnrevealed
+	AlertTypeOverDelegated
+	AlertTypeOverStaked
+	AlertTypeDeactivationRisk
+	AlertTypeConsensusKeyChange
+)
+
+var alertTypeStrings = map[AlertType]string{
+	AlertTypeInvalid:            "",
+	AlertTypeMissedEndorsement:  "missed_endorsement",
+	AlertTypeLostBlock:          "lost_block",
+	AlertTypeStolenBlock:        "stolen_block",
+	AlertTypeSeedUnrevealed:     "seed_unrevealed",
+	AlertTypeOverDelegated:      "over_delegated",
+	AlertTypeOverStaked:         "over_staked",
+	AlertTypeDeactivationRisk:   "deactivation_risk",
+	AlertTypeConsensusKeyChange: "consensus_key_change",
+}
+
+func (t AlertType) IsValid() bool {
+	return t != AlertTypeInvalid
+}
+
+func (t AlertType) String() string {
+	return alertTypeStrings[t]
+}
+
+func (t AlertType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *AlertType) UnmarshalText(data []byte) error {
+	for n, v := range alertTypeStrings {
+		if v == string(data) && n.IsValid() {
+			*t = n
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid alert type '%s'", string(data))
+}
+
+type Severity byte
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityCritical:
+		return "critical"
+	default:
+		return "info"
+	}
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Alert is a single problem detected for a monitored baker.
+type Alert struct {
+	Type     AlertType `json:"type"`
+	Severity Severity  `json:"severity"`
+	Baker    Address   `json:"baker"`
+	Height   int64     `json:"height"`
+	Cycle    int64     `json:"cycle"`
+	Time     time.Time `json:"time"`
+	Message  string    `json:"message"`
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("[%s] %s %s at %d: %s", a.Severity, a.Type, a.Baker, a.Height, a.Message)
+}
@@ -0,0 +1,303 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/echa/log"
+)
+
+type Config struct {
+	Bakers          []Address     // bakers to watch
+	Interval        time.Duration // head polling interval
+	Lag             int64         // blocks to wait until endorsements for a height are final
+	SeedWarnBlocks  int64         // warn about unrevealed nonces this many blocks before the deadline
+	GraceWarnCycles int64         // warn when the grace period ends within this many cycles
+	BakerInterval   int64         // reload baker state every n blocks
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval:        5 * time.Second,
+		Lag:             2,
+		SeedWarnBlocks:  256,
+		GraceWarnCycles: 1,
+		BakerInterval:   16,
+	}
+}
+
+type bakerState struct {
+	loaded        bool
+	consensusKey  Key
+	overDelegated bool
+	overStaked    bool
+	atRisk        bool
+	seedWarned    map[int64]bool
+}
+
+// Monitor follows new blocks and checks the rights of a set of bakers.
+// Detected problems are sent as alerts to all registered sinks.
+type Monitor struct {
+	cfg    Config
+	block  BlockAPI
+	baker  BakerAPI
+	sinks  []Sink
+	log    log.Logger
+	last   int64
+	start  bool
+	bakers map[string]*bakerState
+
+	// rights cache, valid for cycle and heights up to covered
+	rights  map[int64]map[string]*Rights
+	cycle   int64
+	covered int64
+}
+
+func New(cfg Config, block BlockAPI, baker BakerAPI) *Monitor {
+	def := DefaultConfig()
+	if cfg.Interval <= 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.Lag <= 0 {
+		cfg.Lag = def.Lag
+	}
+	if cfg.SeedWarnBlocks <= 0 {
+		cfg.SeedWarnBlocks = def.SeedWarnBlocks
+	}
+	if cfg.GraceWarnCycles <= 0 {
+		cfg.GraceWarnCycles = def.GraceWarnCycles
+	}
+	if cfg.BakerInterval <= 0 {
+		cfg.BakerInterval = def.BakerInterval
+	}
+	m := &Monitor{
+		cfg:    cfg,
+		block:  block,
+		baker:  baker,
+		log:    log.Disabled,
+		bakers: make(map[string]*bakerState),
+	}
+	for _, v := range cfg.Bakers {
+		m.bakers[v.String()] = &bakerState{seedWarned: make(map[int64]bool)}
+	}
+	return m
+}
+
+func (m *Monitor) WithSink(s Sink) *Monitor {
+	m.sinks = append(m.sinks, s)
+	return m
+}
+
+func (m *Monitor) WithLogger(l log.Logger) *Monitor {
+	m.log = l
+	return m
+}
+
+// WithStartHeight makes the monitor catch up from height instead of
+// starting at the current chain head.
+func (m *Monitor) WithStartHeight(height int64) *Monitor {
+	m.last = height - 1
+	m.start = true
+	return m
+}
+
+// Run polls the chain head and checks every new block until ctx is canceled.
+func (m *Monitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if err := m.poll(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			m.log.Errorf("monitor: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Monitor) poll(ctx context.Context) error {
+	head, err := m.block.GetHead(ctx, NewQuery())
+	if err != nil {
+		return err
+	}
+	target := head.Height - m.cfg.Lag
+	if !m.start {
+		m.last = target - 1
+		m.start = true
+	}
+	for m.last < target {
+		if err := m.check(ctx, m.last+1, target); err != nil {
+			return err
+		}
+		m.last++
+	}
+	return nil
+}
+
+// Check verifies all monitored bakers' rights at height and emits alerts.
+func (m *Monitor) Check(ctx context.Context, height int64) error {
+	return m.check(ctx, height, height)
+}
+
+// check verifies rights at height. Rights are cached per cycle and only
+// reloaded when the cycle changes or height is newer than the cached data.
+// Final heights up to target are covered by a single load during catch-up.
+func (m *Monitor) check(ctx context.Context, height, target int64) error {
+	block, err := m.block.GetHeight(ctx, height, NewQuery())
+	if err != nil {
+		return err
+	}
+	if m.rights == nil || m.cycle != block.Cycle || height > m.covered {
+		rights, err := m.loadRights(ctx, block.Cycle)
+		if err != nil {
+			return err
+		}
+		m.rights, m.cycle, m.covered = rights, block.Cycle, target
+	}
+	rights := m.rights
+	for _, addr := range m.cfg.Bakers {
+		key := addr.String()
+		r, ok := rights[block.Cycle][key]
+		if ok {
+			m.checkRights(ctx, block, r)
+		}
+		if prev, ok := rights[block.Cycle-1][key]; ok && r != nil {
+			m.checkSeeds(ctx, block, prev, r)
+		}
+		if !m.bakers[key].loaded || height%m.cfg.BakerInterval == 0 {
+			if err := m.checkBaker(ctx, block, addr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Monitor) loadRights(ctx context.Context, cycle int64) (map[int64]map[string]*Rights, error) {
+	addrs := make([]any, len(m.cfg.Bakers))
+	for i, v := range m.cfg.Bakers {
+		addrs[i] = v
+	}
+	res, err := m.baker.NewRightsQuery().
+		AndIn("address", addrs...).
+		AndRange("cycle", cycle-1, cycle).
+		Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rights := map[int64]map[string]*Rights{
+		cycle - 1: make(map[string]*Rights),
+		cycle:     make(map[string]*Rights),
+	}
+	for _, r := range res.Rows() {
+		if set, ok := rights[r.Cycle]; ok {
+			set[r.Address.String()] = r
+		}
+	}
+	return rights, nil
+}
+
+func (m *Monitor) checkRights(ctx context.Context, b *Block, r *Rights) {
+	pos := r.Pos(b.Height)
+	if r.IsLost(pos) {
+		m.emit(ctx, b, r.Address, AlertTypeLostBlock, SeverityCritical,
+			fmt.Sprintf("block %d was baked by %s", b.Height, b.Baker))
+	}
+	if r.IsStolen(pos) {
+		m.emit(ctx, b, r.Address, AlertTypeStolenBlock, SeverityInfo,
+			fmt.Sprintf("baked block %d at round %d without round 0 right", b.Height, b.Round))
+	}
+	if r.IsMissed(pos) {
+		m.emit(ctx, b, r.Address, AlertTypeMissedEndorsement, SeverityWarning,
+			fmt.Sprintf("endorsement for block %d is missing", b.Height))
+	}
+}
+
+// checkSeeds warns about seed nonces from the previous cycle which have not
+// been revealed shortly before the end of the revelation period.
+func (m *Monitor) checkSeeds(ctx context.Context, b *Block, prev, cur *Rights) {
+	length := cur.Height - prev.Height
+	if length <= 0 {
+		return
+	}
+	deadline := cur.Height + length - 1
+	if deadline-b.Height > m.cfg.SeedWarnBlocks {
+		return
+	}
+	state := m.bakers[prev.Address.String()]
+	for pos := 0; pos < int(length); pos++ {
+		if !prev.IsSeedRequired(pos) || prev.IsSeedRevealed(pos) {
+			continue
+		}
+		height := prev.Height + int64(pos)
+		if state.seedWarned[height] {
+			continue
+		}
+		state.seedWarned[height] = true
+		m.emit(ctx, b, prev.Address, AlertTypeSeedUnrevealed, SeverityCritical,
+			fmt.Sprintf("seed nonce for block %d not revealed, deadline at block %d", height, deadline))
+	}
+	for h := range state.seedWarned {
+		if h < prev.Height {
+			delete(state.seedWarned, h)
+		}
+	}
+}
+
+// checkBaker reloads baker state and emits alerts on state transitions only.
+func (m *Monitor) checkBaker(ctx context.Context, b *Block, addr Address) error {
+	baker, err := m.baker.Get(ctx, addr, NewQuery())
+	if err != nil {
+		return err
+	}
+	state := m.bakers[addr.String()]
+	if baker.IsOverDelegated && !state.overDelegated {
+		m.emit(ctx, b, addr, AlertTypeOverDelegated, SeverityWarning,
+			fmt.Sprintf("delegated balance %.6f exceeds capacity %.6f", baker.DelegatedBalance, baker.DelegationCapacity))
+	}
+	if baker.IsOverStaked && !state.overStaked {
+		m.emit(ctx, b, addr, AlertTypeOverStaked, SeverityWarning,
+			fmt.Sprintf("total stake %.6f exceeds staking capacity %.6f", baker.TotalStake, baker.StakingCapacity))
+	}
+	atRisk := !baker.IsActive || baker.GracePeriod-b.Cycle <= m.cfg.GraceWarnCycles
+	if atRisk && !state.atRisk {
+		m.emit(ctx, b, addr, AlertTypeDeactivationRisk, SeverityCritical,
+			fmt.Sprintf("grace period ends in cycle %d, active=%t", baker.GracePeriod, baker.IsActive))
+	}
+	if state.loaded && baker.ConsensusKey.IsValid() && !baker.ConsensusKey.IsEqual(state.consensusKey) {
+		m.emit(ctx, b, addr, AlertTypeConsensusKeyChange, SeverityWarning,
+			fmt.Sprintf("consensus key changed from %s to %s", state.consensusKey, baker.ConsensusKey))
+	}
+	state.loaded = true
+	state.consensusKey = baker.ConsensusKey
+	state.overDelegated = baker.IsOverDelegated
+	state.overStaked = baker.IsOverStaked
+	state.atRisk = atRisk
+	return nil
+}
+
+func (m *Monitor) emit(ctx context.Context, b *Block, addr Address, typ AlertType, sev Severity, msg string) {
+	a := Alert{
+		Type:     typ,
+		Severity: sev,
+		Baker:    addr,
+		Height:   b.Height,
+		Cycle:    b.Cycle,
+		Time:     b.Timestamp,
+		Message:  msg,
+	}
+	for _, s := range m.sinks {
+		if err := s.Send(ctx, a); err != nil {
+			m.log.Errorf("monitor: sending %s alert: %v", typ, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PrometheusSink counts alerts per baker and type and exposes them in
+// Prometheus text format. Use it as http.Handler or call ListenAndServe
+// to start a local metrics endpoint.
+type PrometheusSink struct {
+	mu     sync.Mutex
+	prefix string
+	counts map[promKey]int64
+	height int64
+}
+
+type promKey struct {
+	baker string
+	typ   string
+}
+
+func NewPrometheusSink(prefix string) *PrometheusSink {
+	if prefix == "" {
+		prefix = "mvpro_monitor"
+	}
+	return &PrometheusSink{
+		prefix: prefix,
+		counts: make(map[promKey]int64),
+	}
+}
+
+func (s *PrometheusSink) Send(_ context.Context, a Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[promKey{a.Baker.String(), a.Type.String()}]++
+	if a.Height > s.height {
+		s.height = a.Height
+	}
+	return nil
+}
+
+func (s *PrometheusSink) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, s.String())
+}
+
+func (s *PrometheusSink) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]promKey, 0, len(s.counts))
+	for k := range s.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].baker == keys[j].baker {
+			return keys[i].typ < keys[j].typ
+		}
+		return keys[i].baker < keys[j].baker
+	})
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s_alerts_total Number of alerts raised per baker and type.\n", s.prefix)
+	fmt.Fprintf(&b, "# TYPE %s_alerts_total counter\n", s.prefix)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s_alerts_total{baker=%q,type=%q} %d\n", s.prefix, k.baker, k.typ, s.counts[k])
+	}
+	fmt.Fprintf(&b, "# HELP %s_last_alert_height Block height of the most recent alert.\n", s.prefix)
+	fmt.Fprintf(&b, "# TYPE %s_last_alert_height gauge\n", s.prefix)
+	fmt.Fprintf(&b, "%s_last_alert_height %d\n", s.prefix, s.height)
+	return b.String()
+}
+
+// ListenAndServe serves metrics on addr under /metrics until ctx is canceled.
+func (s *PrometheusSink) ListenAndServe(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/echa/log"
+)
+
+// Sink receives alerts produced by a Monitor. Implementations must be safe
+// for use from a single monitor goroutine.
+type Sink interface {
+	Send(context.Context, Alert) error
+}
+
+// SinkFunc adapts a plain function to the Sink interface.
+type SinkFunc func(context.Context, Alert) error
+
+func (f SinkFunc) Send(ctx context.Context, a Alert) error {
+	return f(ctx, a)
+}
+
+// LogSink writes alerts to a logger using the alert severity as log level.
+type LogSink struct {
+	log log.Logger
+}
+
+func NewLogSink(l log.Logger) *LogSink {
+	return &LogSink{log: l}
+}
+
+func (s *LogSink) Send(_ context.Context, a Alert) error {
+	switch a.Severity {
+	case SeverityCritical:
+		s.log.Error(a.String())
+	case SeverityWarning:
+		s.log.Warn(a.String())
+	default:
+		s.log.Info(a.String())
+	}
+	return nil
+}
+
+// WebhookSink posts alerts as JSON to a remote URL.
+type WebhookSink struct {
+	url     string
+	headers http.Header
+	client  *http.Client
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		url:     url,
+		headers: make(http.Header),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *WebhookSink) WithHeader(key, value string) *WebhookSink {
+	s.headers.Set(key, value)
+	return s
+}
+
+func (s *WebhookSink) WithHttpClient(c *http.Client) *WebhookSink {
+	s.client = c
+	return s
+}
+
+func (s *WebhookSink) Send(ctx context.Context, a Alert) error {
+	buf, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	for n, v := range s.headers {
+		req.Header[n] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s: %s", s.url, resp.Status)
+	}
+	return nil
+}
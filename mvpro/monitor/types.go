@@ -0,0 +1,24 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package monitor
+
+import (
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+type (
+	Address = mavryk.Address
+	Key     = mavryk.Key
+
+	Block    = index.Block
+	Baker    = index.Baker
+	Rights   = index.Rights
+	BlockAPI = index.BlockAPI
+	BakerAPI = index.BakerAPI
+)
+
+var (
+	NewQuery = index.NewQuery
+)
@@ -0,0 +1,129 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package optree
+
+import (
+	"fmt"
+
+	"github.com/mavryk-network/mvgo/mavryk"
+	m "github.com/mavryk-network/mvgo/micheline"
+)
+
+// ledgerShape is the key/value layout of a token ledger bigmap.
+type ledgerShape byte
+
+const (
+	shapeNone      ledgerShape = iota
+	shapeSingle                // address -> nat (FA1.2, FA2 single asset)
+	shapeAllowance             // address -> pair(nat, map) (FA1.2 with allowances)
+	shapeMulti                 // pair(address, nat) -> nat (FA2 multi asset)
+	shapeMultiRev              // pair(nat, address) -> nat
+	shapeNFT                   // nat -> address (FA2 NFT)
+)
+
+// ledgerShapeOf detects token ledgers by their key and value types.
+func ledgerShapeOf(typ Type) ledgerShape {
+	key, val := leafOps(typ.Left().Prim), leafOps(typ.Right().Prim)
+	switch {
+	case isOps(key, m.T_ADDRESS) && isOps(val, m.T_NAT):
+		return shapeSingle
+	case isOps(key, m.T_ADDRESS) && (isOps(val, m.T_NAT, m.T_MAP) || isOps(val, m.T_MAP, m.T_NAT)):
+		return shapeAllowance
+	case isOps(key, m.T_ADDRESS, m.T_NAT) && isOps(val, m.T_NAT):
+		return shapeMulti
+	case isOps(key, m.T_NAT, m.T_ADDRESS) && isOps(val, m.T_NAT):
+		return shapeMultiRev
+	case isOps(key, m.T_NAT) && isOps(val, m.T_ADDRESS):
+		return shapeNFT
+	}
+	return shapeNone
+}
+
+// leafOps returns the leaf opcodes of nested pair types.
+func leafOps(p Prim) []m.OpCode {
+	if p.OpCode != m.T_PAIR {
+		return []m.OpCode{p.OpCode}
+	}
+	var res []m.OpCode
+	for _, a := range p.Args {
+		res = append(res, leafOps(a)...)
+	}
+	return res
+}
+
+func isOps(have []m.OpCode, want ...m.OpCode) bool {
+	if len(have) != len(want) {
+		return false
+	}
+	for i := range have {
+		if have[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// balance decodes a ledger entry into owner, token id and balance. An
+// invalid val stands for a removed key which decodes to a zero balance.
+// NFT ledgers store the owner in val, so removed NFT keys return an
+// invalid owner.
+func (s ledgerShape) balance(key, val Prim) (owner Address, id, bal Z, err error) {
+	id, bal = mavryk.Zero, mavryk.Zero
+	switch s {
+	case shapeSingle, shapeAllowance:
+		owner, err = primAddress(key)
+	case shapeMulti, shapeMultiRev:
+		if key.OpCode != m.D_PAIR || len(key.Args) != 2 {
+			return owner, id, bal, fmt.Errorf("invalid ledger key")
+		}
+		a, n := key.Args[0], key.Args[1]
+		if s == shapeMultiRev {
+			a, n = n, a
+		}
+		if n.Type != m.PrimInt {
+			return owner, id, bal, fmt.Errorf("invalid token id")
+		}
+		id = mavryk.NewBigZ(n.Int)
+		owner, err = primAddress(a)
+	case shapeNFT:
+		if key.Type != m.PrimInt {
+			return owner, id, bal, fmt.Errorf("invalid token id")
+		}
+		id = mavryk.NewBigZ(key.Int)
+		if !val.IsValid() {
+			return owner, id, bal, nil
+		}
+		owner, err = primAddress(val)
+		return owner, id, mavryk.NewZ(1), err
+	default:
+		return owner, id, bal, fmt.Errorf("unsupported ledger shape")
+	}
+	if err != nil || !val.IsValid() {
+		return owner, id, bal, err
+	}
+	if s == shapeAllowance {
+		for _, v := range val.Args {
+			if v.Type == m.PrimInt {
+				return owner, id, mavryk.NewBigZ(v.Int), nil
+			}
+		}
+		return owner, id, bal, fmt.Errorf("no balance in ledger value")
+	}
+	if val.Type != m.PrimInt {
+		return owner, id, bal, fmt.Errorf("unexpected ledger value type")
+	}
+	return owner, id, mavryk.NewBigZ(val.Int), nil
+}
+
+func primAddress(p Prim) (a Address, err error) {
+	switch p.Type {
+	case m.PrimBytes:
+		err = a.Decode(p.Bytes)
+	case m.PrimString:
+		a, err = mavryk.ParseAddress(p.String)
+	default:
+		err = fmt.Errorf("invalid address")
+	}
+	return
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package optree
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Label returns a one line summary of the node's operation.
+func (n *Node) Label() string {
+	o := n.Op
+	var b strings.Builder
+	b.WriteString(o.Type.String())
+	if o.Sender.IsValid() {
+		fmt.Fprintf(&b, " %s", o.Sender)
+	}
+	if o.Receiver.IsValid() {
+		fmt.Fprintf(&b, " -> %s", o.Receiver)
+	}
+	if o.Entrypoint != "" {
+		fmt.Fprintf(&b, " %%%s", o.Entrypoint)
+	}
+	if o.Volume > 0 {
+		fmt.Fprintf(&b, " %.6f", o.Volume)
+	}
+	if o.GasUsed > 0 {
+		fmt.Fprintf(&b, " gas=%d", o.GasUsed)
+	}
+	fmt.Fprintf(&b, " %s", o.Status)
+	return b.String()
+}
+
+// String renders the tree as indented plain text including transfers,
+// events and the failure point.
+func (t *Tree) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%d)\n", t.Hash, t.Height)
+	_ = t.Walk(func(n *Node) error {
+		indent := strings.Repeat("  ", n.Depth+1)
+		mark := ""
+		if n.IsFailurePoint {
+			mark = " <-- FAILED"
+		}
+		fmt.Fprintf(&b, "%s%s%s\n", indent, n.Label(), mark)
+		for _, tr := range n.Transfers {
+			fmt.Fprintf(&b, "%s  transfer %s\n", indent, tr)
+		}
+		for _, ev := range n.Events {
+			fmt.Fprintf(&b, "%s  event %s from %s\n", indent, ev.Tag, ev.Contract)
+		}
+		if n.IsFailurePoint && len(n.Errors) > 0 {
+			fmt.Fprintf(&b, "%s  errors %s\n", indent, string(n.Errors))
+		}
+		return nil
+	})
+	return b.String()
+}
+
+func (t Transfer) String() string {
+	from, to := "mint", "burn"
+	if t.From.IsValid() {
+		from = t.From.String()
+	}
+	if t.To.IsValid() {
+		to = t.To.String()
+	}
+	kind := "token"
+	if t.Ticket {
+		kind = "ticket"
+	}
+	return fmt.Sprintf("%s %s %s/%s %s -> %s", t.Amount, kind, t.Token, t.TokenId, from, to)
+}
+
+// WriteText writes the plain text rendering to w.
+func (t *Tree) WriteText(w io.Writer) error {
+	_, err := io.WriteString(w, t.String())
+	return err
+}
+
+// WriteDot writes the tree as Graphviz DOT digraph.
+func (t *Tree) WriteDot(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph optree {\n")
+	b.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+	fmt.Fprintf(&b, "  label=%q;\n", t.Hash.String())
+	ids := t.ids()
+	_ = t.Walk(func(n *Node) error {
+		label := n.Label()
+		for _, tr := range n.Transfers {
+			label += "\n" + tr.String()
+		}
+		attr := ""
+		switch {
+		case n.IsFailurePoint:
+			attr = ", color=red, style=bold"
+		case !n.Op.IsSuccess:
+			attr = ", color=gray"
+		}
+		fmt.Fprintf(&b, "  %s [label=%q%s];\n", ids[n], label, attr)
+		if n.Parent != nil {
+			fmt.Fprintf(&b, "  %s -> %s;\n", ids[n.Parent], ids[n])
+		}
+		return nil
+	})
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteMermaid writes the tree as Mermaid flowchart.
+func (t *Tree) WriteMermaid(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	ids := t.ids()
+	_ = t.Walk(func(n *Node) error {
+		label := mermaidEscape(n.Label())
+		for _, tr := range n.Transfers {
+			label += "<br/>" + mermaidEscape(tr.String())
+		}
+		fmt.Fprintf(&b, "  %s[\"%s\"]\n", ids[n], label)
+		if n.Parent != nil {
+			fmt.Fprintf(&b, "  %s --> %s\n", ids[n.Parent], ids[n])
+		}
+		if n.IsFailurePoint {
+			fmt.Fprintf(&b, "  style %s stroke:#d00,stroke-width:3px\n", ids[n])
+		}
+		return nil
+	})
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (t *Tree) ids() map[*Node]string {
+	ids := make(map[*Node]string)
+	_ = t.Walk(func(n *Node) error {
+		ids[n] = fmt.Sprintf("n%d", len(ids))
+		return nil
+	})
+	return ids
+}
+
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(s)
+}
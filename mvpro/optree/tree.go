@@ -0,0 +1,516 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package optree
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+// Transfer is a token movement derived from ledger bigmap updates, a ticket
+// update or, as fallback, a decoded FA1.2/FA2 call. From is empty for mints, To is empty for burns.
+type Transfer struct {
+	Token   Address `json:"token"`
+	TokenId Z       `json:"token_id"`
+	From    Address `json:"from"`
+	To      Address `json:"to"`
+	Amount  Z       `json:"amount"`
+	Ticket  bool    `json:"is_ticket,omitempty"`
+}
+
+// Node is a single operation in a call tree. Children are the internal
+// operations emitted by this node's receiver during execution.
+type Node struct {
+	Op             *Op                 `json:"-"`
+	Depth          int                 `json:"depth"`
+	Parent         *Node               `json:"-"`
+	Children       []*Node             `json:"children,omitempty"`
+	Params         *ContractParameters `json:"params,omitempty"`
+	Call           any                 `json:"call,omitempty"`
+	Transfers      []Transfer          `json:"transfers,omitempty"`
+	BigmapUpdates  BigmapUpdateList    `json:"bigmap_updates,omitempty"`
+	TicketUpdates  []TicketUpdate      `json:"ticket_updates,omitempty"`
+	Events         []Event             `json:"events,omitempty"`
+	Errors         json.RawMessage     `json:"errors,omitempty"`
+	IsFailurePoint bool                `json:"is_failure_point,omitempty"`
+}
+
+// Walk visits n and all descendants in execution order.
+func (n *Node) Walk(fn func(*Node) error) error {
+	if err := fn(n); err != nil {
+		return err
+	}
+	for _, c := range n.Children {
+		if err := c.Walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tree is the call tree of an operation group. Each manager operation in a
+// batch is a root.
+type Tree struct {
+	Hash    OpHash  `json:"hash"`
+	Height  int64   `json:"height"`
+	Roots   []*Node `json:"roots"`
+	Failure *Node   `json:"-"` // operation that caused the failure, nil on success
+}
+
+// Walk visits all nodes in execution order.
+func (t *Tree) Walk(fn func(*Node) error) error {
+	for _, r := range t.Roots {
+		if err := r.Walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of nodes in the tree.
+func (t *Tree) Len() int {
+	var n int
+	_ = t.Walk(func(*Node) error { n++; return nil })
+	return n
+}
+
+// Transfers returns all token transfers in execution order.
+func (t *Tree) Transfers() []Transfer {
+	list := make([]Transfer, 0)
+	_ = t.Walk(func(n *Node) error {
+		list = append(list, n.Transfers...)
+		return nil
+	})
+	return list
+}
+
+// Build reconstructs call trees from ops as returned by OpAPI.Get. Typed
+// calls are decoded with the default call registry. Resolve contract types
+// with OpAPI.ResolveTypes beforehand to decode parameters and to detect
+// ledger bigmaps from which token transfers are derived.
+func Build(ops OpList) *Tree {
+	return NewBuilder().Build(ops)
+}
+
+// BuildWithRegistry works like Build but decodes typed calls with reg.
+func BuildWithRegistry(ops OpList, reg *CallRegistry) *Tree {
+	return NewBuilder().WithRegistry(reg).Build(ops)
+}
+
+// PrevValueFunc returns the raw value of key hash h in bigmap id before
+// height. ok is false when the key did not exist.
+type PrevValueFunc func(id int64, h ExprHash, height int64) (val Prim, ok bool, err error)
+
+// Builder reconstructs call trees. Token transfers are derived from balance
+// changes in ledger bigmaps. Ledgers store absolute balances, so the value
+// of each key before the operation group is required to compute a delta.
+// Without a PrevValueFunc only keys that are written twice inside the group
+// or belong to new bigmaps can be resolved. For all other nodes transfers
+// are taken from decoded FA1.2/FA2 call parameters instead.
+type Builder struct {
+	reg  *CallRegistry
+	prev PrevValueFunc
+}
+
+func NewBuilder() *Builder {
+	return &Builder{
+		reg: index.DefaultCallRegistry,
+	}
+}
+
+func (b *Builder) WithRegistry(reg *CallRegistry) *Builder {
+	b.reg = reg
+	return b
+}
+
+func (b *Builder) WithPrevValues(fn PrevValueFunc) *Builder {
+	b.prev = fn
+	return b
+}
+
+// APIPrevValues looks up previous ledger values from each key's update
+// history.
+func APIPrevValues(ctx context.Context, api ContractAPI) PrevValueFunc {
+	return func(id int64, h ExprHash, height int64) (Prim, bool, error) {
+		list, err := api.ListBigmapKeyUpdates(ctx, id, h.String(), NewQuery().
+			AndLt("height", height).
+			WithPrim().
+			WithLimit(1).
+			Desc())
+		if err != nil {
+			return Prim{}, false, err
+		}
+		if len(list) == 0 || list[0].Action == DiffActionRemove || list[0].ValuePrim == nil {
+			return Prim{}, false, nil
+		}
+		return *list[0].ValuePrim, true, nil
+	}
+}
+
+// Build reconstructs the call tree of ops.
+func (b *Builder) Build(ops OpList) *Tree {
+	t := &Tree{Roots: make([]*Node, 0)}
+	for _, o := range ops {
+		if !t.Hash.IsValid() {
+			t.Hash = o.Hash
+			t.Height = o.Height
+		}
+		roots := []*Op{o}
+		if len(o.Batch) > 0 {
+			roots = o.Batch
+		}
+		for _, r := range roots {
+			internal := r.Internal
+			if len(o.Batch) == 0 && len(internal) == 0 {
+				internal = o.Internal
+			}
+			root := b.newNode(r, nil)
+			b.attach(root, internal)
+			t.Roots = append(t.Roots, root)
+		}
+	}
+	b.deriveTransfers(t)
+	t.markFailure()
+	return t
+}
+
+// attach adds internal operations below parent. When the API returns
+// internal operations nested under the operation that emitted them this
+// nesting is used as is. Flat lists are in depth-first execution order, so
+// each operation belongs to the closest node on the current call path whose
+// receiver is the operation's sender.
+func (b *Builder) attach(parent *Node, internal []*Op) {
+	path := []*Node{parent}
+	for _, o := range internal {
+		p, i := parent, len(path)-1
+		for ; i > 0; i-- {
+			if path[i].Op.Receiver.Equal(o.Sender) {
+				p = path[i]
+				break
+			}
+		}
+		path = path[:i+1]
+		n := b.newNode(o, p)
+		p.Children = append(p.Children, n)
+		if len(o.Internal) > 0 {
+			b.attach(n, o.Internal)
+			continue
+		}
+		path = append(path, n)
+	}
+}
+
+func (b *Builder) newNode(o *Op, parent *Node) *Node {
+	n := &Node{
+		Op:            o,
+		Parent:        parent,
+		Events:        o.Events,
+		TicketUpdates: o.TicketUpdates,
+		Errors:        o.Errors,
+	}
+	if parent != nil {
+		n.Depth = parent.Depth + 1
+	}
+	if o.HasParameters() {
+		if p, err := o.DecodeParams(false, 0); err == nil {
+			n.Params = p
+		}
+		if b.reg != nil {
+			if v, err := b.reg.DecodeCall(o); err == nil {
+				n.Call = v
+			}
+		}
+	}
+	if o.HasBigmapUpdates() {
+		if upd, err := o.DecodeBigmapUpdates(false, false, 0); err == nil {
+			n.BigmapUpdates = upd
+		}
+	}
+	return n
+}
+
+// deriveTransfers replays ledger bigmap writes in execution order. A node's
+// transfers come from its ledger balance changes, from its decoded call when
+// no ledger change could be resolved and from its ticket updates.
+func (b *Builder) deriveTransfers(t *Tree) {
+	state := make(map[string]Prim)
+	fresh := make(map[int64]bool)
+	_ = t.Walk(func(n *Node) error {
+		list, ok := b.ledgerTransfers(n.Op, state, fresh)
+		if !ok && n.Call != nil {
+			list = callTransfers(n.Op.Receiver, n.Call)
+		}
+		n.Transfers = append(list, ticketTransfers(n.TicketUpdates)...)
+		return nil
+	})
+}
+
+type ledgerDelta struct {
+	owner  Address
+	id     Z
+	amount Z
+}
+
+// ledgerTransfers computes balance changes from updates to ledger bigmaps
+// of o and pairs them into transfers. State tracks the latest value per key
+// inside the group. It returns false when o has no ledger updates or when
+// a previous value is unknown.
+func (b *Builder) ledgerTransfers(o *Op, state map[string]Prim, fresh map[int64]bool) ([]Transfer, bool) {
+	if !o.HasBigmapUpdates() {
+		return nil, false
+	}
+	events, err := o.DecodeBigmapEvents(false)
+	if err != nil {
+		return nil, false
+	}
+	allocs := make(map[int64]Type)
+	deltas := make([]*ledgerDelta, 0)
+	add := func(owner Address, id, amount Z) {
+		if !owner.IsValid() || amount.IsZero() {
+			return
+		}
+		for _, d := range deltas {
+			if d.owner.Equal(owner) && d.id.Equal(id) {
+				d.amount = d.amount.Add(amount)
+				return
+			}
+		}
+		deltas = append(deltas, &ledgerDelta{owner: owner, id: id, amount: amount})
+	}
+	var (
+		seen     bool
+		resolved = true
+	)
+	for _, ev := range events {
+		switch ev.Action {
+		case DiffActionAlloc:
+			allocs[ev.Id] = NewType(NewCode(T_BIG_MAP, ev.KeyType, ev.ValueType))
+			fresh[ev.Id] = true
+			continue
+		case DiffActionCopy:
+			fresh[ev.DestId] = false
+			continue
+		}
+		typ, ok := allocs[ev.Id]
+		if !ok {
+			typ, ok = o.BigmapType(ev.Id)
+		}
+		if !ok || !ev.Key.IsValid() {
+			continue
+		}
+		shape := ledgerShapeOf(typ)
+		if shape == shapeNone {
+			continue
+		}
+		seen = true
+		h := ev.KeyHash
+		if !h.IsValid() {
+			k, err := NewKey(typ.Left(), ev.Key)
+			if err != nil {
+				resolved = false
+				continue
+			}
+			h = k.Hash()
+		}
+		key := fmt.Sprintf("%d/%s", ev.Id, h)
+		prev, ok := state[key]
+		if !ok {
+			switch {
+			case fresh[ev.Id]:
+			case b.prev != nil:
+				if prev, _, err = b.prev(ev.Id, h, o.Height); err != nil {
+					resolved = false
+				}
+			default:
+				resolved = false
+			}
+		}
+		var next Prim
+		if ev.Action == DiffActionUpdate {
+			next = ev.Value
+		}
+		state[key] = next
+		owner, id, bal, err := shape.balance(ev.Key, prev)
+		if err != nil {
+			resolved = false
+			continue
+		}
+		add(owner, id, bal.Neg())
+		owner, id, bal, err = shape.balance(ev.Key, next)
+		if err != nil {
+			resolved = false
+			continue
+		}
+		add(owner, id, bal)
+	}
+	if !seen || !resolved {
+		return nil, false
+	}
+	return pairDeltas(o.Receiver, deltas), true
+}
+
+// pairDeltas matches balance decreases with increases of the same token id
+// in order. Unmatched decreases are reported as burns, unmatched increases
+// as mints.
+func pairDeltas(token Address, deltas []*ledgerDelta) []Transfer {
+	list := make([]Transfer, 0)
+	for i, from := range deltas {
+		if !from.amount.IsNeg() {
+			continue
+		}
+		left := from.amount.Neg()
+		for _, to := range deltas[i+1:] {
+			list, left = pairDelta(list, token, from, to, left)
+		}
+		for _, to := range deltas[:i] {
+			list, left = pairDelta(list, token, from, to, left)
+		}
+		if !left.IsZero() {
+			list = append(list, Transfer{Token: token, TokenId: from.id, From: from.owner, Amount: left})
+		}
+	}
+	for _, to := range deltas {
+		if to.amount.IsNeg() || to.amount.IsZero() {
+			continue
+		}
+		list = append(list, Transfer{Token: token, TokenId: to.id, To: to.owner, Amount: to.amount})
+	}
+	return list
+}
+
+// pairDelta moves up to left tokens from sender from to receiver to and
+// consumes the matched amount from to.
+func pairDelta(list []Transfer, token Address, from, to *ledgerDelta, left Z) ([]Transfer, Z) {
+	if left.IsZero() || to.amount.IsNeg() || to.amount.IsZero() || !to.id.Equal(from.id) {
+		return list, left
+	}
+	amount := left
+	if to.amount.Cmp(amount) < 0 {
+		amount = to.amount
+	}
+	to.amount = to.amount.Sub(amount)
+	list = append(list, Transfer{
+		Token:   token,
+		TokenId: from.id,
+		From:    from.owner,
+		To:      to.owner,
+		Amount:  amount,
+	})
+	return list, left.Sub(amount)
+}
+
+// markFailure flags the operation that caused a group to fail. Outer calls
+// of a failed internal operation may be reported as failed too, so the node
+// which carries errors wins, otherwise the last failed node is used.
+func (t *Tree) markFailure() {
+	var last, withErr *Node
+	_ = t.Walk(func(n *Node) error {
+		if n.Op.Status != OpStatusFailed {
+			return nil
+		}
+		last = n
+		if withErr == nil && len(n.Errors) > 0 {
+			withErr = n
+		}
+		return nil
+	})
+	t.Failure = withErr
+	if t.Failure == nil {
+		t.Failure = last
+	}
+	if t.Failure != nil {
+		t.Failure.IsFailurePoint = true
+	}
+}
+
+func callTransfers(token Address, call any) []Transfer {
+	switch v := call.(type) {
+	case *index.FA12Transfer:
+		return []Transfer{{
+			Token:  token,
+			From:   v.From,
+			To:     v.To,
+			Amount: v.Value,
+		}}
+	case *index.FA2Transfer:
+		list := make([]Transfer, 0)
+		for _, b := range *v {
+			for _, tx := range b.Txs {
+				list = append(list, Transfer{
+					Token:   token,
+					TokenId: tx.TokenId,
+					From:    b.From,
+					To:      tx.To,
+					Amount:  tx.Amount,
+				})
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// ticketTransfers pairs ticket balance updates per ticket. When a ticket has
+// exactly one decreasing account all increases are attributed to it,
+// otherwise updates are reported as mints and burns.
+func ticketTransfers(upd []TicketUpdate) []Transfer {
+	if len(upd) == 0 {
+		return nil
+	}
+	type group struct {
+		ticketer Address
+		from     []TicketUpdate
+		to       []TicketUpdate
+	}
+	groups := make(map[string]*group)
+	order := make([]string, 0)
+	for _, u := range upd {
+		key := u.Ticketer.String() + "/" + u.Type.Dump() + "/" + u.Content.Dump()
+		g, ok := groups[key]
+		if !ok {
+			g = &group{ticketer: u.Ticketer}
+			groups[key] = g
+			order = append(order, key)
+		}
+		if u.Amount.IsNeg() {
+			g.from = append(g.from, u)
+		} else {
+			g.to = append(g.to, u)
+		}
+	}
+	list := make([]Transfer, 0)
+	for _, key := range order {
+		g := groups[key]
+		if len(g.from) == 1 {
+			for _, u := range g.to {
+				list = append(list, Transfer{
+					Token:  g.ticketer,
+					From:   g.from[0].Account,
+					To:     u.Account,
+					Amount: u.Amount,
+					Ticket: true,
+				})
+			}
+			if len(g.to) == 0 {
+				list = append(list, Transfer{
+					Token:  g.ticketer,
+					From:   g.from[0].Account,
+					Amount: g.from[0].Amount.Neg(),
+					Ticket: true,
+				})
+			}
+			continue
+		}
+		for _, u := range g.from {
+			list = append(list, Transfer{Token: g.ticketer, From: u.Account, Amount: u.Amount.Neg(), Ticket: true})
+		}
+		for _, u := range g.to {
+			list = append(list, Transfer{Token: g.ticketer, To: u.Account, Amount: u.Amount, Ticket: true})
+		}
+	}
+	return list
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package optree
+
+import (
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvgo/micheline"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+type (
+	Address  = mavryk.Address
+	ExprHash = mavryk.ExprHash
+	OpHash   = mavryk.OpHash
+	OpStatus = mavryk.OpStatus
+	Z        = mavryk.Z
+	Prim     = micheline.Prim
+	Type     = micheline.Type
+
+	Op                 = index.Op
+	OpList             = index.OpList
+	Event              = index.Event
+	TicketUpdate       = index.TicketUpdate
+	BigmapUpdateList   = index.BigmapUpdateList
+	ContractParameters = index.ContractParameters
+	CallRegistry       = index.CallRegistry
+	ContractAPI        = index.ContractAPI
+)
+
+var (
+	OpStatusFailed = mavryk.OpStatusFailed
+	NewQuery       = index.NewQuery
+	NewType        = micheline.NewType
+	NewKey         = micheline.NewKey
+	NewCode        = micheline.NewCode
+
+	T_BIG_MAP = micheline.T_BIG_MAP
+
+	DiffActionAlloc  = micheline.DiffActionAlloc
+	DiffActionCopy   = micheline.DiffActionCopy
+	DiffActionUpdate = micheline.DiffActionUpdate
+	DiffActionRemove = micheline.DiffActionRemove
+)
@@ -0,0 +1,273 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package profile
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Stats summarizes a distribution of values.
+type Stats struct {
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Sum  float64 `json:"sum"`
+}
+
+// Call is a single contract call with its costs.
+type Call struct {
+	Hash        OpHash  `json:"hash"`
+	Height      int64   `json:"height"`
+	Contract    Address `json:"contract"`
+	Entrypoint  string  `json:"entrypoint"`
+	IsInternal  bool    `json:"is_internal"`
+	IsSuccess   bool    `json:"is_success"`
+	GasUsed     int64   `json:"gas_used"`
+	StorageUsed int64   `json:"storage_used"`
+	Fee         float64 `json:"fee"`
+	StorageBurn float64 `json:"storage_burn"`
+	Total       float64 `json:"total"` // fee and all burn
+}
+
+// Entry is the profile of a single contract entrypoint.
+type Entry struct {
+	Contract   Address `json:"contract"`
+	Entrypoint string  `json:"entrypoint"`
+	Calls      int     `json:"n_calls"`
+	Failed     int     `json:"n_failed"`
+	Gas        Stats   `json:"gas"`
+	Storage    Stats   `json:"storage"`
+	Fee        Stats   `json:"fee"`
+	FeePerGas  float64 `json:"fee_per_gas"` // in mumav, external calls only
+	Top        []Call  `json:"top"`
+
+	calls []Call
+}
+
+// Report lists entrypoint profiles sorted by total gas used.
+type Report struct {
+	Calls   int      `json:"n_calls"`
+	Entries []*Entry `json:"entries"`
+	Top     []Call   `json:"top"`
+}
+
+// Profiler collects contract calls and builds a gas and storage report per
+// contract and entrypoint.
+type Profiler struct {
+	topN  int
+	model *CostModel
+	calls map[string]*Entry
+	all   []Call
+}
+
+func New() *Profiler {
+	return &Profiler{
+		topN:  10,
+		calls: make(map[string]*Entry),
+	}
+}
+
+// WithTop sets the number of most expensive calls listed per entry.
+func (p *Profiler) WithTop(n int) *Profiler {
+	p.topN = n
+	return p
+}
+
+// WithCostModel resolves protocol cost params for each op before the storage
+// burn is calculated.
+func (p *Profiler) WithCostModel(m *CostModel) *Profiler {
+	p.model = m
+	return p
+}
+
+// Add collects all contract calls in ops including batch and internal
+// operations.
+func (p *Profiler) Add(ctx context.Context, ops ...*Op) error {
+	for _, op := range ops {
+		if p.model != nil {
+			if err := p.model.Resolve(ctx, op); err != nil {
+				return err
+			}
+		}
+		for _, o := range op.Content() {
+			if o.Type != OpTypeTransaction || !o.Receiver.IsContract() {
+				continue
+			}
+			p.add(op, o)
+		}
+	}
+	return nil
+}
+
+func (p *Profiler) add(parent, o *Op) {
+	c := o.Costs()
+	call := Call{
+		Hash:        parent.Hash,
+		Height:      o.Height,
+		Contract:    o.Receiver,
+		Entrypoint:  o.Entrypoint,
+		IsInternal:  o.IsInternal,
+		IsSuccess:   o.IsSuccess,
+		GasUsed:     c.GasUsed,
+		StorageUsed: c.StorageUsed,
+		Fee:         c.Fee,
+		StorageBurn: c.StorageBurn,
+		Total:       c.Sum(),
+	}
+	if call.Height == 0 {
+		call.Height = parent.Height
+	}
+	key := o.Receiver.String() + "/" + o.Entrypoint
+	e, ok := p.calls[key]
+	if !ok {
+		e = &Entry{Contract: o.Receiver, Entrypoint: o.Entrypoint}
+		p.calls[key] = e
+	}
+	e.calls = append(e.calls, call)
+	p.all = append(p.all, call)
+}
+
+// AddQuery runs q page by page and collects all returned calls. Use filters
+// on q to select contracts, entrypoints and time ranges. The caller's query
+// is not modified.
+func (p *Profiler) AddQuery(ctx context.Context, q *OpQuery) error {
+	q = q.Clone()
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 500
+		q.WithLimit(limit)
+	}
+	for {
+		res, err := q.Run(ctx)
+		if err != nil {
+			return err
+		}
+		if err := p.Add(ctx, res.Rows()...); err != nil {
+			return err
+		}
+		if res.Len() < limit {
+			return nil
+		}
+		q.WithCursor(res.Cursor())
+	}
+}
+
+// Report computes statistics over all collected calls. Collected calls are
+// not modified, so Report may be called repeatedly between calls to Add.
+func (p *Profiler) Report() *Report {
+	r := &Report{
+		Calls:   len(p.all),
+		Entries: make([]*Entry, 0, len(p.calls)),
+		Top:     top(p.all, p.topN),
+	}
+	for _, c := range p.calls {
+		gas := make([]float64, 0, len(c.calls))
+		storage := make([]float64, 0, len(c.calls))
+		fee := make([]float64, 0, len(c.calls))
+		var feeSum, gasSum float64
+		var failed int
+		for _, call := range c.calls {
+			gas = append(gas, float64(call.GasUsed))
+			storage = append(storage, float64(call.StorageUsed))
+			if !call.IsInternal {
+				fee = append(fee, call.Fee)
+				feeSum += call.Fee
+				gasSum += float64(call.GasUsed)
+			}
+			if !call.IsSuccess {
+				failed++
+			}
+		}
+		e := &Entry{
+			Contract:   c.Contract,
+			Entrypoint: c.Entrypoint,
+			Calls:      len(c.calls),
+			Failed:     failed,
+			Gas:        NewStats(gas),
+			Storage:    NewStats(storage),
+			Fee:        NewStats(fee),
+			Top:        top(c.calls, p.topN),
+		}
+		if gasSum > 0 {
+			e.FeePerGas = feeSum * 1e6 / gasSum
+		}
+		r.Entries = append(r.Entries, e)
+	}
+	sort.Slice(r.Entries, func(i, j int) bool {
+		return r.Entries[i].Gas.Sum > r.Entries[j].Gas.Sum
+	})
+	return r
+}
+
+// NewStats computes min, max, mean and nearest-rank percentiles of vals.
+func NewStats(vals []float64) Stats {
+	if len(vals) == 0 {
+		return Stats{}
+	}
+	sorted := make([]float64, len(vals))
+	copy(sorted, vals)
+	sort.Float64s(sorted)
+	var s Stats
+	for _, v := range sorted {
+		s.Sum += v
+	}
+	s.Min = sorted[0]
+	s.Max = sorted[len(sorted)-1]
+	s.Mean = s.Sum / float64(len(sorted))
+	s.P50 = percentile(sorted, 50)
+	s.P90 = percentile(sorted, 90)
+	s.P99 = percentile(sorted, 99)
+	return s
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func top(calls []Call, n int) []Call {
+	list := make([]Call, len(calls))
+	copy(list, calls)
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Total == list[j].Total {
+			return list[i].GasUsed > list[j].GasUsed
+		}
+		return list[i].Total > list[j].Total
+	})
+	if n >= 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// String renders the report as plain text table.
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d calls in %d entrypoints\n\n", r.Calls, len(r.Entries))
+	fmt.Fprintf(&b, "%-36s %-20s %7s %6s %10s %10s %10s %8s %8s %10s\n",
+		"CONTRACT", "ENTRYPOINT", "CALLS", "FAILED", "GAS P50", "GAS P90", "GAS P99", "STO P50", "STO MAX", "MUMAV/GAS")
+	for _, e := range r.Entries {
+		fmt.Fprintf(&b, "%-36s %-20s %7d %6d %10.0f %10.0f %10.0f %8.0f %8.0f %10.4f\n",
+			e.Contract, e.Entrypoint, e.Calls, e.Failed,
+			e.Gas.P50, e.Gas.P90, e.Gas.P99, e.Storage.P50, e.Storage.Max, e.FeePerGas)
+	}
+	if len(r.Top) > 0 {
+		b.WriteString("\nMost expensive calls\n")
+		for _, c := range r.Top {
+			fmt.Fprintf(&b, "  %s %d %s %%%s gas=%d storage=%d fee=%.6f total=%.6f\n",
+				c.Hash, c.Height, c.Contract, c.Entrypoint, c.GasUsed, c.StorageUsed, c.Fee, c.Total)
+		}
+	}
+	return b.String()
+}
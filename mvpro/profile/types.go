@@ -0,0 +1,22 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package profile
+
+import (
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+type (
+	Address = mavryk.Address
+	OpHash  = mavryk.OpHash
+
+	Op        = index.Op
+	OpQuery   = index.OpQuery
+	CostModel = index.CostModel
+)
+
+var (
+	OpTypeTransaction = index.OpTypeTransaction
+)
@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package schedule
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const icalTimeFormat = "20060102T150405Z"
+
+// WriteJSON writes the schedule including all individual duties as JSON.
+func (s Schedule) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// WriteICS writes the schedule as iCalendar (RFC 5545) document. Each baking
+// right becomes a separate event, endorsing rights are merged into windows
+// with up to gap blocks between them to keep the calendar readable.
+func (s Schedule) WriteICS(w io.Writer, gap int64) error {
+	bw := bufio.NewWriter(w)
+	now := time.Now().UTC().Format(icalTimeFormat)
+	writeLine(bw, "BEGIN:VCALENDAR")
+	writeLine(bw, "VERSION:2.0")
+	writeLine(bw, "PRODID:-//Blockwatch//mvpro-go//EN")
+	writeLine(bw, "CALSCALE:GREGORIAN")
+	writeLine(bw, "METHOD:PUBLISH")
+	writeLine(bw, "X-WR-CALNAME:"+escapeText(fmt.Sprintf("%s duties %s", s.Network, s.Address)))
+
+	delay := time.Duration(s.BlockDelay) * time.Second
+	for _, v := range s.Filter(RightTypeBaking) {
+		writeEvent(bw, now, Window{
+			Type:        v.Type,
+			Address:     v.Address,
+			Cycle:       v.Cycle,
+			StartHeight: v.Height,
+			EndHeight:   v.Height,
+			StartTime:   v.Time,
+			EndTime:     v.Time.Add(delay),
+			Count:       1,
+		})
+	}
+	for _, v := range s.Windows(RightTypeEndorsing, gap) {
+		writeEvent(bw, now, v)
+	}
+	writeLine(bw, "END:VCALENDAR")
+	return bw.Flush()
+}
+
+func writeEvent(w *bufio.Writer, stamp string, v Window) {
+	var summary, desc string
+	if v.StartHeight == v.EndHeight {
+		summary = fmt.Sprintf("%s right at block %d", v.Type, v.StartHeight)
+	} else {
+		summary = fmt.Sprintf("%d %s rights at blocks %d-%d", v.Count, v.Type, v.StartHeight, v.EndHeight)
+	}
+	desc = fmt.Sprintf("Baker %s, cycle %d. Times are estimates based on minimal block delay.", v.Address, v.Cycle)
+	writeLine(w, "BEGIN:VEVENT")
+	writeLine(w, fmt.Sprintf("UID:%s-%s-%d@mvpro", v.Address, v.Type, v.StartHeight))
+	writeLine(w, "DTSTAMP:"+stamp)
+	writeLine(w, "DTSTART:"+v.StartTime.UTC().Format(icalTimeFormat))
+	writeLine(w, "DTEND:"+v.EndTime.UTC().Format(icalTimeFormat))
+	writeLine(w, "SUMMARY:"+escapeText(summary))
+	writeLine(w, "DESCRIPTION:"+escapeText(desc))
+	writeLine(w, "CATEGORIES:"+strings.ToUpper(v.Type.String()))
+	writeLine(w, "END:VEVENT")
+}
+
+// writeLine folds content lines longer than 75 octets as required by RFC 5545.
+func writeLine(w *bufio.Writer, line string) {
+	n := 75
+	for len(line) > n {
+		w.WriteString(line[:n])
+		w.WriteString("\r\n ")
+		line = line[n:]
+		n = 74
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\n", `\n`).Replace(s)
+}
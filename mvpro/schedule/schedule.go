@@ -0,0 +1,202 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package schedule
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Duty is a single baking or endorsing right at a future block height
+// with an estimated wall-clock time.
+type Duty struct {
+	Type    RightType `json:"type"`
+	Address Address   `json:"address"`
+	Height  int64     `json:"height"`
+	Cycle   int64     `json:"cycle"`
+	Time    time.Time `json:"time"`
+}
+
+// Window is a span of consecutive duties of the same type. Endorsing
+// rights are typically assigned at almost every block, so windows are
+// the more useful unit for planning.
+type Window struct {
+	Type        RightType `json:"type"`
+	Address     Address   `json:"address"`
+	Cycle       int64     `json:"cycle"`
+	StartHeight int64     `json:"start_height"`
+	EndHeight   int64     `json:"end_height"`
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+	Count       int       `json:"count"`
+}
+
+// Schedule lists upcoming duties for a baker. Times are estimated from the
+// chain tip at creation time and the minimal block delay, so they drift
+// when blocks are produced at higher rounds.
+type Schedule struct {
+	Address    Address   `json:"address"`
+	Network    string    `json:"network"`
+	Height     int64     `json:"height"`
+	Time       time.Time `json:"time"`
+	BlockDelay int       `json:"block_delay"`
+	Duties     []Duty    `json:"duties"`
+}
+
+func NewSchedule(addr Address, height int64, tm time.Time, delay int) *Schedule {
+	return &Schedule{
+		Address:    addr,
+		Height:     height,
+		Time:       tm,
+		BlockDelay: delay,
+		Duties:     make([]Duty, 0),
+	}
+}
+
+// EstimateTime returns the expected time at which height will be produced.
+func (s Schedule) EstimateTime(height int64) time.Time {
+	return s.Time.Add(time.Duration(height-s.Height) * time.Duration(s.BlockDelay) * time.Second)
+}
+
+// Add expands the rights bitmaps of r into duties. Heights at or below
+// the schedule's reference height are skipped.
+func (s *Schedule) Add(r *Rights) {
+	for _, typ := range []RightType{RightTypeBaking, RightTypeEndorsing} {
+		for _, h := range r.Heights(typ) {
+			if h <= s.Height {
+				continue
+			}
+			s.Duties = append(s.Duties, Duty{
+				Type:    typ,
+				Address: r.Address,
+				Height:  h,
+				Cycle:   r.Cycle,
+				Time:    s.EstimateTime(h),
+			})
+		}
+	}
+	sort.SliceStable(s.Duties, func(i, j int) bool {
+		return s.Duties[i].Height < s.Duties[j].Height
+	})
+}
+
+// Filter returns all duties of type typ.
+func (s Schedule) Filter(typ RightType) []Duty {
+	list := make([]Duty, 0)
+	for _, v := range s.Duties {
+		if v.Type == typ {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Next returns the first upcoming duty of type typ.
+func (s Schedule) Next(typ RightType) (Duty, bool) {
+	for _, v := range s.Duties {
+		if v.Type == typ {
+			return v, true
+		}
+	}
+	return Duty{}, false
+}
+
+// Windows merges duties of type typ into spans. Duties closer than gap
+// blocks apart end up in the same window. A window ends one block delay
+// after its last duty.
+func (s Schedule) Windows(typ RightType, gap int64) []Window {
+	if gap < 1 {
+		gap = 1
+	}
+	list := make([]Window, 0)
+	delay := time.Duration(s.BlockDelay) * time.Second
+	for _, v := range s.Filter(typ) {
+		if l := len(list); l > 0 {
+			last := &list[l-1]
+			if v.Cycle == last.Cycle && v.Height-last.EndHeight <= gap {
+				last.EndHeight = v.Height
+				last.EndTime = v.Time.Add(delay)
+				last.Count++
+				continue
+			}
+		}
+		list = append(list, Window{
+			Type:        typ,
+			Address:     v.Address,
+			Cycle:       v.Cycle,
+			StartHeight: v.Height,
+			EndHeight:   v.Height,
+			StartTime:   v.Time,
+			EndTime:     v.Time.Add(delay),
+			Count:       1,
+		})
+	}
+	return list
+}
+
+// Gaps returns free periods of at least minLen between any two duties,
+// i.e. candidate maintenance windows.
+func (s Schedule) Gaps(minLen time.Duration) []Window {
+	list := make([]Window, 0)
+	prev := Duty{Height: s.Height, Time: s.Time}
+	for _, v := range s.Duties {
+		if v.Time.Sub(prev.Time) >= minLen {
+			list = append(list, Window{
+				Address:     s.Address,
+				Cycle:       v.Cycle,
+				StartHeight: prev.Height + 1,
+				EndHeight:   v.Height - 1,
+				StartTime:   prev.Time,
+				EndTime:     v.Time,
+				Count:       int(v.Height - prev.Height - 1),
+			})
+		}
+		prev = v
+	}
+	return list
+}
+
+// Builder loads rights from the API and turns them into a Schedule.
+type Builder struct {
+	baker    BakerAPI
+	explorer ExplorerAPI
+}
+
+func NewBuilder(b BakerAPI, e ExplorerAPI) *Builder {
+	return &Builder{
+		baker:    b,
+		explorer: e,
+	}
+}
+
+// Build creates a schedule for addr covering the current cycle and the
+// following cycles. When cycles is zero or negative all cycles with known
+// rights (preserved cycles) are included.
+func (b *Builder) Build(ctx context.Context, addr Address, cycles int64) (*Schedule, error) {
+	tip, err := b.explorer.GetTip(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config, err := b.explorer.GetConfigHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cycles <= 0 {
+		cycles = config.PreservedCycles
+	}
+	res, err := b.baker.NewRightsQuery().
+		AndEqual("address", addr).
+		AndRange("cycle", tip.Cycle, tip.Cycle+cycles).
+		Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := NewSchedule(addr, tip.Height, tip.Timestamp, config.MinimalBlockDelay)
+	s.Network = tip.Network
+	for _, r := range res.Rows() {
+		s.Add(r)
+	}
+	return s, nil
+}
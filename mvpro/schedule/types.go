@@ -0,0 +1,24 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package schedule
+
+import (
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+type (
+	Address   = mavryk.Address
+	RightType = mavryk.RightType
+
+	Rights      = index.Rights
+	RightsList  = index.RightsList
+	BakerAPI    = index.BakerAPI
+	ExplorerAPI = index.ExplorerAPI
+)
+
+var (
+	RightTypeBaking    = mavryk.RightTypeBaking
+	RightTypeEndorsing = mavryk.RightTypeEndorsing
+)
@@ -38,6 +38,7 @@ type Client struct {
 	Metadata index.MetadataAPI
 	Op       index.OpAPI
 	Stats    index.StatsAPI
+	Rollup   index.RollupAPI
 	Dex      defi.DexAPI
 	Farm     defi.FarmAPI
 	Lend     defi.LendingAPI
@@ -67,6 +68,7 @@ func NewClient(url string, httpClient *http.Client) *Client {
 		Metadata: index.NewMetadataAPI(c),
 		Op:       index.NewOpAPI(c),
 		Stats:    index.NewStatsAPI(c),
+		Rollup:   index.NewRollupAPI(c),
 		Dex:      defi.NewDexAPI(c),
 		Farm:     defi.NewFarmAPI(c),
 		Lend:     defi.NewLendingAPI(c),
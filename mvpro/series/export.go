@@ -0,0 +1,230 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package series
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteCSV writes t with a leading RFC 3339 time column. Missing values
+// are written as empty cells.
+func (t *Table) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append([]string{"time"}, t.Columns...)); err != nil {
+		return err
+	}
+	rec := make([]string, len(t.Columns)+1)
+	for i, row := range t.Rows {
+		rec[0] = t.Times[i].Format(time.RFC3339)
+		for j, v := range row {
+			rec[j+1] = formatValue(v)
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteCSV writes l in long format with one row per point and columns
+// time, name, labels and value.
+func (l List) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"time", "name", "labels", "value"}); err != nil {
+		return err
+	}
+	for _, s := range l {
+		labels := strings.TrimPrefix(s.Key(), s.Name)
+		for _, p := range s.Points {
+			rec := []string{p.Time.UTC().Format(time.RFC3339), s.Name, labels, formatValue(p.Value)}
+			if err := cw.Write(rec); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes l as JSON array. NaN and infinite values are written
+// as null.
+func (l List) WriteJSON(w io.Writer) error {
+	type point struct {
+		Time  time.Time `json:"time"`
+		Value *float64  `json:"value"`
+	}
+	type series struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels,omitempty"`
+		Points []point           `json:"points"`
+	}
+	out := make([]series, len(l))
+	for i, s := range l {
+		out[i] = series{Name: s.Name, Labels: s.Labels, Points: make([]point, len(s.Points))}
+		for j, p := range s.Points {
+			out[i].Points[j].Time = p.Time.UTC()
+			if !math.IsNaN(p.Value) && !math.IsInf(p.Value, 0) {
+				v := p.Value
+				out[i].Points[j].Value = &v
+			}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// WriteJSON writes t as JSON object with columns, times and rows.
+func (t *Table) WriteJSON(w io.Writer) error {
+	rows := make([][]*float64, len(t.Rows))
+	for i, row := range t.Rows {
+		rows[i] = make([]*float64, len(row))
+		for j, v := range row {
+			if !math.IsNaN(v) && !math.IsInf(v, 0) {
+				v := v
+				rows[i][j] = &v
+			}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Columns []string     `json:"columns"`
+		Times   []time.Time  `json:"times"`
+		Rows    [][]*float64 `json:"rows"`
+	}{t.Columns, t.Times, rows})
+}
+
+// WritePrometheus writes l in Prometheus text exposition format with
+// millisecond timestamps. Metric names are prefixed and sanitized, all
+// series are typed as gauge.
+func (l List) WritePrometheus(w io.Writer, prefix string) error {
+	return l.writeMetrics(w, prefix, false)
+}
+
+// WriteOpenMetrics writes l in OpenMetrics text format with timestamps in
+// seconds and a trailing # EOF as required to backfill with promtool tsdb
+// create-blocks-from openmetrics. Naming works like WritePrometheus.
+func (l List) WriteOpenMetrics(w io.Writer, prefix string) error {
+	return l.writeMetrics(w, prefix, true)
+}
+
+func (l List) writeMetrics(w io.Writer, prefix string, openMetrics bool) error {
+	bw := bufio.NewWriter(w)
+	byName := make(map[string][]*Series)
+	names := make([]string, 0)
+	for _, s := range l {
+		name := metricName(prefix, s.Name)
+		if _, ok := byName[name]; !ok {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], s)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		bw.WriteString("# TYPE ")
+		bw.WriteString(name)
+		bw.WriteString(" gauge\n")
+		for _, s := range byName[name] {
+			labels := promLabels(s.Labels)
+			for _, p := range s.Points {
+				bw.WriteString(name)
+				bw.WriteString(labels)
+				bw.WriteByte(' ')
+				bw.WriteString(promValue(p.Value))
+				bw.WriteByte(' ')
+				if openMetrics {
+					ms := p.Time.UnixMilli()
+					bw.WriteString(strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64))
+				} else {
+					bw.WriteString(strconv.FormatInt(p.Time.UnixMilli(), 10))
+				}
+				bw.WriteByte('\n')
+			}
+		}
+	}
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+func formatValue(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func promValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func promLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(sanitize(k))
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(labels[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func metricName(prefix, name string) string {
+	if prefix != "" {
+		name = prefix + "_" + name
+	}
+	return sanitize(name)
+}
+
+// sanitize replaces characters outside [a-zA-Z0-9_] and a leading digit.
+func sanitize(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+func escapeLabel(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package series
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Table is a set of series joined on time. Missing values are NaN.
+type Table struct {
+	Columns []string
+	Times   []time.Time
+	Rows    [][]float64
+}
+
+// Join aligns all series of the given lists on their timestamps. Column
+// names are series keys, prefixed with the list index when the same key
+// appears in more than one list. Join reports of different frequency
+// after resampling them to a common interval.
+func Join(lists ...List) *Table {
+	count := make(map[string]int)
+	for _, l := range lists {
+		for _, s := range l {
+			count[s.Key()]++
+		}
+	}
+	t := &Table{}
+	var all []*Series
+	for i, l := range lists {
+		for _, s := range l {
+			name := s.Key()
+			if count[name] > 1 {
+				name = fmt.Sprintf("%d.%s", i, name)
+			}
+			t.Columns = append(t.Columns, name)
+			all = append(all, s)
+		}
+	}
+	rows := make(map[int64][]float64)
+	for col, s := range all {
+		for _, p := range s.Points {
+			key := p.Time.UnixNano()
+			row, ok := rows[key]
+			if !ok {
+				row = make([]float64, len(all))
+				for i := range row {
+					row[i] = math.NaN()
+				}
+				rows[key] = row
+				t.Times = append(t.Times, p.Time.UTC())
+			}
+			row[col] = p.Value
+		}
+	}
+	sort.Slice(t.Times, func(i, j int) bool { return t.Times[i].Before(t.Times[j]) })
+	t.Rows = make([][]float64, len(t.Times))
+	for i, tm := range t.Times {
+		t.Rows[i] = rows[tm.UnixNano()]
+	}
+	return t
+}
+
+// Column returns the values of column name.
+func (t *Table) Column(name string) ([]float64, bool) {
+	for i, c := range t.Columns {
+		if c == name {
+			vals := make([]float64, len(t.Rows))
+			for j, row := range t.Rows {
+				vals[j] = row[i]
+			}
+			return vals, true
+		}
+	}
+	return nil, false
+}
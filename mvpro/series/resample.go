@@ -0,0 +1,215 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package series
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Interval is a calendar bucket size. Buckets are aligned in UTC, weeks
+// start on Monday.
+type Interval byte
+
+const (
+	IntervalDay Interval = iota
+	IntervalWeek
+	IntervalMonth
+)
+
+func (i Interval) String() string {
+	switch i {
+	case IntervalDay:
+		return "day"
+	case IntervalWeek:
+		return "week"
+	case IntervalMonth:
+		return "month"
+	default:
+		return "invalid"
+	}
+}
+
+func ParseInterval(s string) (Interval, error) {
+	switch s {
+	case "d", "1d", "day":
+		return IntervalDay, nil
+	case "w", "1w", "week":
+		return IntervalWeek, nil
+	case "M", "1M", "month":
+		return IntervalMonth, nil
+	default:
+		return 0, fmt.Errorf("invalid interval %q", s)
+	}
+}
+
+// Truncate returns the start of the bucket containing t.
+func (i Interval) Truncate(t time.Time) time.Time {
+	t = t.UTC()
+	y, m, d := t.Date()
+	switch i {
+	case IntervalWeek:
+		wd := (int(t.Weekday()) + 6) % 7 // Monday = 0
+		return time.Date(y, m, d-wd, 0, 0, 0, 0, time.UTC)
+	case IntervalMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// Next returns the start of the bucket following the one containing t.
+func (i Interval) Next(t time.Time) time.Time {
+	t = i.Truncate(t)
+	switch i {
+	case IntervalWeek:
+		return t.AddDate(0, 0, 7)
+	case IntervalMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// Agg selects how points in a bucket are combined. Reports are snapshots
+// so AggLast is the right choice for levels like supply or balances while
+// AggSum fits per-period flows like volume or op counts.
+type Agg byte
+
+const (
+	AggLast Agg = iota
+	AggFirst
+	AggSum
+	AggMean
+	AggMin
+	AggMax
+	AggCount
+)
+
+func (a Agg) String() string {
+	switch a {
+	case AggLast:
+		return "last"
+	case AggFirst:
+		return "first"
+	case AggSum:
+		return "sum"
+	case AggMean:
+		return "mean"
+	case AggMin:
+		return "min"
+	case AggMax:
+		return "max"
+	case AggCount:
+		return "count"
+	default:
+		return "invalid"
+	}
+}
+
+func ParseAgg(s string) (Agg, error) {
+	for a := AggLast; a <= AggCount; a++ {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid aggregation %q", s)
+}
+
+func (a Agg) reduce(vals []float64) float64 {
+	if len(vals) == 0 {
+		return math.NaN()
+	}
+	switch a {
+	case AggFirst:
+		return vals[0]
+	case AggSum, AggMean:
+		var sum float64
+		for _, v := range vals {
+			sum += v
+		}
+		if a == AggMean {
+			return sum / float64(len(vals))
+		}
+		return sum
+	case AggMin:
+		x := vals[0]
+		for _, v := range vals[1:] {
+			x = math.Min(x, v)
+		}
+		return x
+	case AggMax:
+		x := vals[0]
+		for _, v := range vals[1:] {
+			x = math.Max(x, v)
+		}
+		return x
+	case AggCount:
+		return float64(len(vals))
+	default:
+		return vals[len(vals)-1]
+	}
+}
+
+// Resample aggregates s into buckets of size iv. Points are stamped with
+// the bucket start. Empty buckets are omitted.
+func (s *Series) Resample(iv Interval, agg Agg) *Series {
+	res := s.derive(s.Name)
+	var (
+		bucket time.Time
+		vals   []float64
+	)
+	flush := func() {
+		if len(vals) > 0 {
+			res.Points = append(res.Points, Point{Time: bucket, Value: agg.reduce(vals)})
+		}
+		vals = vals[:0]
+	}
+	for _, p := range s.Points {
+		b := iv.Truncate(p.Time)
+		if !b.Equal(bucket) {
+			flush()
+			bucket = b
+		}
+		vals = append(vals, p.Value)
+	}
+	flush()
+	return res
+}
+
+// Fill inserts missing buckets between the first and last point carrying
+// the previous value forward. s must be resampled to iv before.
+func (s *Series) Fill(iv Interval) *Series {
+	res := s.derive(s.Name)
+	for i, p := range s.Points {
+		if i > 0 {
+			prev := s.Points[i-1]
+			for t := iv.Next(prev.Time); t.Before(p.Time); t = iv.Next(t) {
+				res.Points = append(res.Points, Point{Time: t, Value: prev.Value})
+			}
+		}
+		res.Points = append(res.Points, p)
+	}
+	return res
+}
+
+func (l List) Resample(iv Interval, agg Agg) List {
+	return l.apply(func(s *Series) *Series { return s.Resample(iv, agg) })
+}
+
+// ResampleBy uses per-series aggregations by name and def for all others.
+func (l List) ResampleBy(iv Interval, def Agg, aggs map[string]Agg) List {
+	return l.apply(func(s *Series) *Series {
+		agg, ok := aggs[s.Name]
+		if !ok {
+			agg = def
+		}
+		return s.Resample(iv, agg)
+	})
+}
+
+func (l List) Fill(iv Interval) List {
+	return l.apply(func(s *Series) *Series { return s.Fill(iv) })
+}
@@ -0,0 +1,414 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package series
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNoTime = errors.New("report has no time field")
+
+// Point is a single observation.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Series is a named sequence of points in ascending time order. Labels
+// distinguish series of the same name, e.g. the op kind of an OpReport.
+type Series struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Points []Point           `json:"points"`
+}
+
+// Key returns a unique identifier of name and sorted labels like
+// sum{kind="fee",type="transaction"}.
+func (s *Series) Key() string {
+	if len(s.Labels) == 0 {
+		return s.Name
+	}
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(s.Name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString("=")
+		b.WriteString(strconv.Quote(s.Labels[k]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func (s *Series) Len() int {
+	return len(s.Points)
+}
+
+// Last returns the most recent point.
+func (s *Series) Last() (Point, bool) {
+	if len(s.Points) == 0 {
+		return Point{}, false
+	}
+	return s.Points[len(s.Points)-1], true
+}
+
+// Between returns a copy of s limited to points in [from, to). Zero times
+// leave the range open.
+func (s *Series) Between(from, to time.Time) *Series {
+	res := s.derive(s.Name)
+	for _, p := range s.Points {
+		if !from.IsZero() && p.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !p.Time.Before(to) {
+			continue
+		}
+		res.Points = append(res.Points, p)
+	}
+	return res
+}
+
+// Delta returns the difference of each point to its predecessor. The
+// result has one point less than s.
+func (s *Series) Delta() *Series {
+	res := s.derive(s.Name + "_delta")
+	for i := 1; i < len(s.Points); i++ {
+		res.Points = append(res.Points, Point{
+			Time:  s.Points[i].Time,
+			Value: s.Points[i].Value - s.Points[i-1].Value,
+		})
+	}
+	return res
+}
+
+// Growth returns the relative change of each point to its predecessor,
+// i.e. 0.05 for 5% growth. Points following a zero value are skipped.
+func (s *Series) Growth() *Series {
+	res := s.derive(s.Name + "_growth")
+	for i := 1; i < len(s.Points); i++ {
+		prev := s.Points[i-1].Value
+		if prev == 0 {
+			continue
+		}
+		res.Points = append(res.Points, Point{
+			Time:  s.Points[i].Time,
+			Value: (s.Points[i].Value - prev) / math.Abs(prev),
+		})
+	}
+	return res
+}
+
+// Cumulative returns the running sum of s.
+func (s *Series) Cumulative() *Series {
+	res := s.derive(s.Name + "_cum")
+	var sum float64
+	for _, p := range s.Points {
+		sum += p.Value
+		res.Points = append(res.Points, Point{Time: p.Time, Value: sum})
+	}
+	return res
+}
+
+func (s *Series) derive(name string) *Series {
+	res := &Series{
+		Name:   name,
+		Points: make([]Point, 0, len(s.Points)),
+	}
+	if len(s.Labels) > 0 {
+		res.Labels = make(map[string]string, len(s.Labels))
+		for k, v := range s.Labels {
+			res.Labels[k] = v
+		}
+	}
+	return res
+}
+
+func (s *Series) sort() {
+	sort.SliceStable(s.Points, func(i, j int) bool {
+		return s.Points[i].Time.Before(s.Points[j].Time)
+	})
+}
+
+// List is a set of series.
+type List []*Series
+
+// Find returns the first series called name with all given labels.
+func (l List) Find(name string, labels ...string) (*Series, bool) {
+next:
+	for _, s := range l {
+		if s.Name != name {
+			continue
+		}
+		for i := 0; i+1 < len(labels); i += 2 {
+			if s.Labels[labels[i]] != labels[i+1] {
+				continue next
+			}
+		}
+		return s, true
+	}
+	return nil, false
+}
+
+// Names returns the distinct series names in l.
+func (l List) Names() []string {
+	seen := make(map[string]struct{})
+	names := make([]string, 0)
+	for _, s := range l {
+		if _, ok := seen[s.Name]; ok {
+			continue
+		}
+		seen[s.Name] = struct{}{}
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func (l List) Delta() List {
+	return l.apply((*Series).Delta)
+}
+
+func (l List) Growth() List {
+	return l.apply((*Series).Growth)
+}
+
+func (l List) Cumulative() List {
+	return l.apply((*Series).Cumulative)
+}
+
+func (l List) Between(from, to time.Time) List {
+	return l.apply(func(s *Series) *Series { return s.Between(from, to) })
+}
+
+func (l List) apply(fn func(*Series) *Series) List {
+	res := make(List, len(l))
+	for i, s := range l {
+		res[i] = fn(s)
+	}
+	return res
+}
+
+// Mapper converts slices of report structs into series. Each numeric field
+// becomes a series named after its JSON tag. String and fmt.Stringer fields
+// (like the kind and type of OpReport) become labels so that rows with
+// different label values end up in separate series. Byte slice fields such
+// as the account sets of AccountsReport have no scalar value and are
+// skipped. Reports must have a time.Time field tagged "time".
+type Mapper struct {
+	fields  map[string]bool
+	labels  map[string]bool
+	exclude map[string]bool
+}
+
+func NewMapper() *Mapper {
+	return &Mapper{
+		exclude: map[string]bool{"row_id": true},
+	}
+}
+
+// WithFields limits the output to the listed fields.
+func (m *Mapper) WithFields(names ...string) *Mapper {
+	if len(names) == 0 {
+		return m
+	}
+	if m.fields == nil {
+		m.fields = make(map[string]bool)
+	}
+	for _, n := range names {
+		m.fields[n] = true
+	}
+	return m
+}
+
+// WithLabels uses numeric fields like the year of AgeReport as labels.
+func (m *Mapper) WithLabels(names ...string) *Mapper {
+	if m.labels == nil {
+		m.labels = make(map[string]bool)
+	}
+	for _, n := range names {
+		m.labels[n] = true
+	}
+	return m
+}
+
+// WithExclude drops fields. row_id is excluded by default.
+func (m *Mapper) WithExclude(names ...string) *Mapper {
+	for _, n := range names {
+		m.exclude[n] = true
+	}
+	return m
+}
+
+// FromReports converts a report slice like []*SupplyReport into series.
+// Without fields all numeric fields are converted.
+func FromReports(reports any, fields ...string) (List, error) {
+	return NewMapper().WithFields(fields...).Map(reports)
+}
+
+type fieldInfo struct {
+	name  string
+	index []int
+	label bool
+}
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// Map converts reports which must be a slice of structs or struct pointers.
+// Series are returned in field order and by first appearance of labels.
+func (m *Mapper) Map(reports any) (List, error) {
+	rv := reflect.ValueOf(reports)
+	if rv.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("expected slice, got %T", reports)
+	}
+	typ := rv.Type().Elem()
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected struct elements, got %s", typ)
+	}
+	timeIdx, fields := m.columns(typ)
+	if timeIdx == nil {
+		return nil, fmt.Errorf("%w in %s", ErrNoTime, typ)
+	}
+	for n := range m.fields {
+		found := false
+		for _, f := range fields {
+			found = found || f.name == n
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown field %q in %s", n, typ)
+		}
+	}
+
+	var (
+		res   List
+		index = make(map[string]*Series)
+	)
+	for i := 0; i < rv.Len(); i++ {
+		v := rv.Index(i)
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				continue
+			}
+			v = v.Elem()
+		}
+		tm := v.FieldByIndex(timeIdx).Interface().(time.Time)
+		var labels map[string]string
+		for _, f := range fields {
+			if !f.label {
+				continue
+			}
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[f.name] = labelString(v.FieldByIndex(f.index))
+		}
+		for _, f := range fields {
+			if f.label || (m.fields != nil && !m.fields[f.name]) {
+				continue
+			}
+			s := &Series{Name: f.name, Labels: labels}
+			key := s.Key()
+			if x, ok := index[key]; ok {
+				s = x
+			} else {
+				index[key] = s
+				res = append(res, s)
+			}
+			s.Points = append(s.Points, Point{
+				Time:  tm,
+				Value: numeric(v.FieldByIndex(f.index)),
+			})
+		}
+	}
+	for _, s := range res {
+		s.sort()
+	}
+	return res, nil
+}
+
+// columns lists the usable fields of typ including fields of embedded
+// structs like Supply in SupplyReport.
+func (m *Mapper) columns(typ reflect.Type) ([]int, []fieldInfo) {
+	var (
+		timeIdx []int
+		res     []fieldInfo
+		timeT   = reflect.TypeOf(time.Time{})
+	)
+	for _, f := range reflect.VisibleFields(typ) {
+		if !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" || m.exclude[name] {
+			continue
+		}
+		switch {
+		case f.Type == timeT:
+			if name == "time" {
+				timeIdx = f.Index
+			}
+		case m.labels[name]:
+			res = append(res, fieldInfo{name: name, index: f.Index, label: true})
+		case f.Type.Implements(stringerType) || f.Type.Kind() == reflect.String:
+			res = append(res, fieldInfo{name: name, index: f.Index, label: true})
+		case isNumeric(f.Type.Kind()):
+			res = append(res, fieldInfo{name: name, index: f.Index})
+		}
+	}
+	return timeIdx, res
+}
+
+func isNumeric(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func numeric(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return math.NaN()
+}
+
+func labelString(v reflect.Value) string {
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+	if isNumeric(v.Kind()) {
+		return strconv.FormatFloat(numeric(v), 'f', -1, 64)
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,370 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package ticket
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type IssueKind string
+
+const (
+	IssueSupplyMismatch  IssueKind = "supply_mismatch"    // supply != sum of balances
+	IssueMintBurn        IssueKind = "mint_burn_mismatch" // supply != total mint - total burn
+	IssueReplayMismatch  IssueKind = "replay_mismatch"    // replayed supply != reported supply
+	IssueBalanceMismatch IssueKind = "balance_mismatch"   // replayed balance != reported balance
+	IssueHolderMismatch  IssueKind = "holder_mismatch"    // replayed holders != reported holders
+	IssueNegativeBalance IssueKind = "negative_balance"
+	IssueUnknownEvent    IssueKind = "unknown_event"
+	IssueUnknownTicket   IssueKind = "unknown_ticket"  // balance or event without ticket
+	IssueMissingBalance  IssueKind = "missing_balance" // holder without reported balance
+)
+
+// Issue is a single inconsistency found during reconciliation.
+type Issue struct {
+	Kind     IssueKind `json:"kind"`
+	Hash     string    `json:"ticket"`
+	Account  Address   `json:"account"`
+	Expected Z         `json:"expected"`
+	Actual   Z         `json:"actual"`
+	Message  string    `json:"message"`
+}
+
+func (i Issue) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s ticket=%s", i.Kind, i.Hash)
+	if i.Account.IsValid() {
+		fmt.Fprintf(&b, " account=%s", i.Account)
+	}
+	fmt.Fprintf(&b, " expected=%s actual=%s", i.Expected, i.Actual)
+	if i.Message != "" {
+		b.WriteString(": ")
+		b.WriteString(i.Message)
+	}
+	return b.String()
+}
+
+// TicketReport is the reconciled state of a single ticket. Supply and
+// Balances are replayed from ticket events. Reported values are only set
+// when reconciling the current state.
+type TicketReport struct {
+	Ticket         *Ticket       `json:"ticket"`
+	Content        any           `json:"content"`
+	Minted         Z             `json:"minted"`
+	Burned         Z             `json:"burned"`
+	Supply         Z             `json:"supply"`
+	BalanceSum     Z             `json:"balance_sum"`
+	Balances       map[Address]Z `json:"balances"`
+	ReportedSupply Z             `json:"reported_supply"`
+	ReportedSum    Z             `json:"reported_balance_sum"`
+	Reported       map[Address]Z `json:"reported_balances,omitempty"`
+	Issues         []Issue       `json:"issues,omitempty"`
+}
+
+func (r *TicketReport) IsConsistent() bool {
+	return len(r.Issues) == 0
+}
+
+func (r *TicketReport) flag(kind IssueKind, acc Address, exp, act Z, msg string) {
+	r.Issues = append(r.Issues, Issue{
+		Kind:     kind,
+		Hash:     r.Ticket.Hash,
+		Account:  acc,
+		Expected: exp,
+		Actual:   act,
+		Message:  msg,
+	})
+}
+
+// Report lists reconciled tickets of a single ticketer at Height. Height is
+// zero for the current state.
+type Report struct {
+	Ticketer Address         `json:"ticketer"`
+	Height   int64           `json:"height"`
+	Tickets  []*TicketReport `json:"tickets"`
+	Issues   []Issue         `json:"issues,omitempty"` // issues not related to a known ticket
+}
+
+func (r *Report) IsConsistent() bool {
+	if len(r.Issues) > 0 {
+		return false
+	}
+	for _, v := range r.Tickets {
+		if !v.IsConsistent() {
+			return false
+		}
+	}
+	return true
+}
+
+// AllIssues returns report level and per ticket issues.
+func (r *Report) AllIssues() []Issue {
+	list := append([]Issue{}, r.Issues...)
+	for _, v := range r.Tickets {
+		list = append(list, v.Issues...)
+	}
+	return list
+}
+
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ticketer %s", r.Ticketer)
+	if r.Height > 0 {
+		fmt.Fprintf(&b, " at height %d", r.Height)
+	}
+	fmt.Fprintf(&b, ": %d tickets\n", len(r.Tickets))
+	for _, t := range r.Tickets {
+		state := "OK"
+		if !t.IsConsistent() {
+			state = fmt.Sprintf("%d issues", len(t.Issues))
+		}
+		fmt.Fprintf(&b, "  %s %v supply=%s holders=%d %s\n", t.Ticket.Hash, t.Content, t.Supply, len(t.Balances), state)
+		for _, v := range t.Issues {
+			fmt.Fprintf(&b, "    %s\n", v)
+		}
+	}
+	for _, v := range r.Issues {
+		fmt.Fprintf(&b, "  %s\n", v)
+	}
+	return b.String()
+}
+
+// Auditor reconciles ticket supply against holder balances and traces the
+// flow of tickets over ticket events.
+type Auditor struct {
+	api   ContractAPI
+	batch int
+}
+
+func NewAuditor(api ContractAPI) *Auditor {
+	return &Auditor{
+		api:   api,
+		batch: 500,
+	}
+}
+
+func (a *Auditor) WithBatchSize(n int) *Auditor {
+	if n > 0 {
+		a.batch = n
+	}
+	return a
+}
+
+// Reconcile replays all ticket events of ticketer up to height. At height
+// zero the replayed state is compared against current tickets and balances
+// reported by the index. The index keeps no balance history, so at a
+// historical height only negative balances and unknown events or tickets
+// are detected.
+func (a *Auditor) Reconcile(ctx context.Context, ticketer Address, height int64) (*Report, error) {
+	tickets, err := a.listTickets(ctx, ticketer)
+	if err != nil {
+		return nil, err
+	}
+	events, err := a.listEvents(ctx, ticketer, height)
+	if err != nil {
+		return nil, err
+	}
+	rep := &Report{
+		Ticketer: ticketer,
+		Height:   height,
+		Tickets:  make([]*TicketReport, 0, len(tickets)),
+	}
+	byHash := make(map[string]*TicketReport)
+	for _, t := range tickets {
+		tr := &TicketReport{
+			Ticket:   t,
+			Balances: make(map[Address]Z),
+		}
+		tr.Content, _ = DecodeContent(t.Type, t.Content)
+		byHash[t.Hash] = tr
+		rep.Tickets = append(rep.Tickets, tr)
+	}
+
+	// replay events
+	negative := make(map[string]map[Address]bool)
+	for _, ev := range events {
+		tr, ok := byHash[ev.Hash]
+		if !ok {
+			rep.Issues = append(rep.Issues, Issue{
+				Kind:    IssueUnknownTicket,
+				Hash:    ev.Hash,
+				Actual:  ev.Amount,
+				Message: fmt.Sprintf("event %d at height %d", ev.Id, ev.Height),
+			})
+			continue
+		}
+		var touched []Address
+		switch ev.EventType {
+		case EventTypeMint:
+			tr.Minted = tr.Minted.Add(ev.Amount)
+			tr.Balances[ev.Receiver] = tr.Balances[ev.Receiver].Add(ev.Amount)
+			touched = append(touched, ev.Receiver)
+		case EventTypeBurn:
+			tr.Burned = tr.Burned.Add(ev.Amount)
+			tr.Balances[ev.Sender] = tr.Balances[ev.Sender].Sub(ev.Amount)
+			touched = append(touched, ev.Sender)
+		case EventTypeTransfer:
+			tr.Balances[ev.Sender] = tr.Balances[ev.Sender].Sub(ev.Amount)
+			tr.Balances[ev.Receiver] = tr.Balances[ev.Receiver].Add(ev.Amount)
+			touched = append(touched, ev.Sender, ev.Receiver)
+		default:
+			tr.flag(IssueUnknownEvent, ev.Sender, Z{}, ev.Amount,
+				fmt.Sprintf("event %d type %q at height %d", ev.Id, ev.EventType, ev.Height))
+			continue
+		}
+		for _, acc := range touched {
+			if !tr.Balances[acc].IsNeg() {
+				continue
+			}
+			m, ok := negative[tr.Ticket.Hash]
+			if !ok {
+				m = make(map[Address]bool)
+				negative[tr.Ticket.Hash] = m
+			}
+			if m[acc] {
+				continue
+			}
+			m[acc] = true
+			tr.flag(IssueNegativeBalance, acc, Z{}, tr.Balances[acc],
+				fmt.Sprintf("after event %d at height %d", ev.Id, ev.Height))
+		}
+	}
+	for _, tr := range rep.Tickets {
+		for acc, bal := range tr.Balances {
+			if bal.IsZero() {
+				delete(tr.Balances, acc)
+				continue
+			}
+			tr.BalanceSum = tr.BalanceSum.Add(bal)
+		}
+		tr.Supply = tr.Minted.Sub(tr.Burned)
+	}
+	if height > 0 {
+		return rep, nil
+	}
+
+	// compare against current state reported by the index
+	balances, err := a.listBalances(ctx, ticketer)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range balances {
+		tr, ok := byHash[b.Hash]
+		if !ok {
+			rep.Issues = append(rep.Issues, Issue{
+				Kind:    IssueUnknownTicket,
+				Hash:    b.Hash,
+				Account: b.Account,
+				Actual:  b.Balance,
+				Message: "balance without ticket",
+			})
+			continue
+		}
+		if tr.Reported == nil {
+			tr.Reported = make(map[Address]Z)
+		}
+		tr.Reported[b.Account] = b.Balance
+		tr.ReportedSum = tr.ReportedSum.Add(b.Balance)
+	}
+	for _, tr := range rep.Tickets {
+		t := tr.Ticket
+		tr.ReportedSupply = t.Supply
+		if !t.Supply.Equal(tr.ReportedSum) {
+			tr.flag(IssueSupplyMismatch, Address{}, t.Supply, tr.ReportedSum, "reported balances")
+		}
+		if net := t.TotalMint.Sub(t.TotalBurn); !t.Supply.Equal(net) {
+			tr.flag(IssueMintBurn, Address{}, net, t.Supply, "reported totals")
+		}
+		if !t.Supply.Equal(tr.Supply) {
+			tr.flag(IssueReplayMismatch, Address{}, tr.Supply, t.Supply, "")
+		}
+		var nonZero int
+		for acc, bal := range tr.Reported {
+			if !bal.IsZero() {
+				nonZero++
+			}
+			if exp := tr.Balances[acc]; !exp.Equal(bal) {
+				tr.flag(IssueBalanceMismatch, acc, exp, bal, "")
+			}
+		}
+		for acc, bal := range tr.Balances {
+			if _, ok := tr.Reported[acc]; !ok {
+				tr.flag(IssueMissingBalance, acc, bal, Z{}, "")
+			}
+		}
+		if t.NumHolders != nonZero {
+			tr.flag(IssueHolderMismatch, Address{},
+				NewZ(int64(nonZero)), NewZ(int64(t.NumHolders)), "reported holder count")
+		}
+		sortIssues(tr.Issues)
+	}
+	return rep, nil
+}
+
+func sortIssues(list []Issue) {
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Kind == list[j].Kind {
+			return list[i].Account.String() < list[j].Account.String()
+		}
+		return list[i].Kind < list[j].Kind
+	})
+}
+
+func (a *Auditor) listTickets(ctx context.Context, ticketer Address) (TicketList, error) {
+	res := make(TicketList, 0)
+	params := NewQuery().WithLimit(uint(a.batch)).Asc()
+	for {
+		list, err := a.api.ListTickets(ctx, ticketer, params)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, list...)
+		if len(list) < a.batch {
+			return res, nil
+		}
+		params = params.WithCursor(list.Cursor())
+	}
+}
+
+// listEvents returns ticket events of ticketer in ascending order up to
+// height. Zero height lists all events.
+func (a *Auditor) listEvents(ctx context.Context, ticketer Address, height int64) (TicketEventList, error) {
+	res := make(TicketEventList, 0)
+	params := NewQuery().WithLimit(uint(a.batch)).Asc()
+	if height > 0 {
+		params = params.AndLte("height", height)
+	}
+	for {
+		list, err := a.api.ListTicketEvents(ctx, ticketer, params)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, list...)
+		if len(list) < a.batch {
+			return res, nil
+		}
+		params = params.WithCursor(list.Cursor())
+	}
+}
+
+// listBalances returns current holder balances of all tickets issued by
+// ticketer.
+func (a *Auditor) listBalances(ctx context.Context, ticketer Address) (TicketBalanceList, error) {
+	res := make(TicketBalanceList, 0)
+	params := NewQuery().WithLimit(uint(a.batch)).Asc()
+	for {
+		list, err := a.api.ListTicketBalances(ctx, ticketer, params)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, list...)
+		if len(list) < a.batch {
+			return res, nil
+		}
+		params = params.WithCursor(list.Cursor())
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package ticket
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Hop is a single ticket movement. Mints originate at the ticketer, burns
+// have no receiver.
+type Hop struct {
+	Id     uint64    `json:"id"`
+	Height int64     `json:"height"`
+	Time   time.Time `json:"time"`
+	OpId   uint64    `json:"op_id"`
+	Type   string    `json:"type"`
+	From   Address   `json:"from"`
+	To     Address   `json:"to"`
+	Amount Z         `json:"amount"`
+}
+
+// Flow is the movement history of a single ticket from its ticketer
+// through transfers to burns.
+type Flow struct {
+	Ticketer Address       `json:"ticketer"`
+	Hash     string        `json:"hash"`
+	Content  any           `json:"content"`
+	Minted   Z             `json:"minted"`
+	Burned   Z             `json:"burned"`
+	Hops     []Hop         `json:"hops"`
+	Balances map[Address]Z `json:"balances"`
+}
+
+// NewFlow builds the flow of ticket hash from events in ascending order.
+// Events of other tickets are ignored.
+func NewFlow(ticketer Address, hash string, events TicketEventList) *Flow {
+	f := &Flow{
+		Ticketer: ticketer,
+		Hash:     hash,
+		Hops:     make([]Hop, 0),
+		Balances: make(map[Address]Z),
+	}
+	for _, ev := range events {
+		if ev.Hash != hash {
+			continue
+		}
+		if f.Content == nil {
+			f.Content, _ = DecodeContent(ev.Type, ev.Content)
+		}
+		hop := Hop{
+			Id:     ev.Id,
+			Height: ev.Height,
+			Time:   ev.Time,
+			OpId:   ev.OpId,
+			Type:   ev.EventType,
+			From:   ev.Sender,
+			To:     ev.Receiver,
+			Amount: ev.Amount,
+		}
+		switch ev.EventType {
+		case EventTypeMint:
+			if !hop.From.IsValid() {
+				hop.From = ticketer
+			}
+			f.Minted = f.Minted.Add(ev.Amount)
+			f.Balances[hop.To] = f.Balances[hop.To].Add(ev.Amount)
+		case EventTypeBurn:
+			hop.To = Address{}
+			f.Burned = f.Burned.Add(ev.Amount)
+			f.Balances[hop.From] = f.Balances[hop.From].Sub(ev.Amount)
+		default:
+			f.Balances[hop.From] = f.Balances[hop.From].Sub(ev.Amount)
+			f.Balances[hop.To] = f.Balances[hop.To].Add(ev.Amount)
+		}
+		f.Hops = append(f.Hops, hop)
+	}
+	for acc, bal := range f.Balances {
+		if bal.IsZero() {
+			delete(f.Balances, acc)
+		}
+	}
+	return f
+}
+
+// Trace loads all events of ticketer and returns the flow of ticket hash.
+func (a *Auditor) Trace(ctx context.Context, ticketer Address, hash string) (*Flow, error) {
+	events, err := a.listEvents(ctx, ticketer, 0)
+	if err != nil {
+		return nil, err
+	}
+	f := NewFlow(ticketer, hash, events)
+	if len(f.Hops) == 0 {
+		return nil, fmt.Errorf("ticket %s: no events for ticketer %s", hash, ticketer)
+	}
+	if f.Content == nil {
+		// explorer events may omit type and content
+		tickets, err := a.listTickets(ctx, ticketer)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tickets {
+			if t.Hash == hash {
+				f.Content, _ = DecodeContent(t.Type, t.Content)
+				break
+			}
+		}
+	}
+	return f, nil
+}
+
+// Supply returns minted minus burned amount.
+func (f *Flow) Supply() Z {
+	return f.Minted.Sub(f.Burned)
+}
+
+// Downstream returns all hops reachable from account in time order, i.e.
+// movements of tickets that account held or forwarded.
+func (f *Flow) Downstream(account Address) []Hop {
+	reached := map[Address]bool{account: true}
+	res := make([]Hop, 0)
+	for _, h := range f.Hops {
+		if !reached[h.From] {
+			continue
+		}
+		res = append(res, h)
+		if h.To.IsValid() {
+			reached[h.To] = true
+		}
+	}
+	return res
+}
+
+// Upstream returns all hops through which tickets may have reached account
+// in time order, back to their mint.
+func (f *Flow) Upstream(account Address) []Hop {
+	reached := map[Address]bool{account: true}
+	res := make([]Hop, 0)
+	for i := len(f.Hops) - 1; i >= 0; i-- {
+		h := f.Hops[i]
+		if !h.To.IsValid() || !reached[h.To] {
+			continue
+		}
+		res = append(res, h)
+		reached[h.From] = true
+	}
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res
+}
+
+func (f Flow) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ticket %s %v by %s: minted=%s burned=%s holders=%d\n",
+		f.Hash, f.Content, f.Ticketer, f.Minted, f.Burned, len(f.Balances))
+	for _, h := range f.Hops {
+		to := "-"
+		if h.To.IsValid() {
+			to = h.To.String()
+		}
+		fmt.Fprintf(&b, "  %d %-8s %s -> %s %s\n", h.Height, h.Type, h.From, to, h.Amount)
+	}
+	return b.String()
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package ticket
+
+import (
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvgo/micheline"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+type (
+	Address = mavryk.Address
+	Z       = mavryk.Z
+	Prim    = micheline.Prim
+
+	ContractAPI       = index.ContractAPI
+	Ticket            = index.Ticket
+	TicketList        = index.TicketList
+	TicketBalance     = index.TicketBalance
+	TicketBalanceList = index.TicketBalanceList
+	TicketEvent       = index.TicketEvent
+	TicketEventList   = index.TicketEventList
+)
+
+var (
+	NewQuery = index.NewQuery
+	NewZ     = mavryk.NewZ
+)
+
+// Ticket event types as reported by the index.
+const (
+	EventTypeMint     = "mint"
+	EventTypeBurn     = "burn"
+	EventTypeTransfer = "transfer"
+)
+
+// DecodeContent decodes ticket content against the ticket type into a
+// readable value, e.g. a string, number or map for pairs.
+func DecodeContent(typ, content Prim) (any, error) {
+	if !typ.IsValid() || !content.IsValid() {
+		return nil, nil
+	}
+	val := micheline.NewValue(micheline.NewType(typ), content)
+	return val.Map()
+}
@@ -19,6 +19,8 @@ type (
 	Key         = mavryk.Key
 	Token       = mavryk.Token
 	Z           = mavryk.Z
+	OpType      = index.OpType
+	OpTypeSet   = index.OpTypeSet
 
 	Query          = client.Query
 	FilterMode     = client.FilterMode
@@ -41,6 +43,8 @@ var (
 	IsErrHttp        = client.IsErrHttp
 	IsErrRateLimited = client.IsErrRateLimited
 	ErrorStatus      = client.ErrorStatus
+	NewOpTypeSet     = index.NewOpTypeSet
+	ParseOpTypeSet   = index.ParseOpTypeSet
 
 	NoQuery = NewQuery()
 )
@@ -0,0 +1,1224 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package tz16
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mavryk-network/mvgo/mavryk"
+	m "github.com/mavryk-network/mvgo/micheline"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported instruction")
+	ErrTypeError   = errors.New("type error")
+	ErrStepLimit   = errors.New("step limit exceeded")
+)
+
+// FailedError is returned when view code executes FAILWITH.
+type FailedError struct {
+	Value Prim
+}
+
+func (e *FailedError) Error() string {
+	return "script failed: " + e.Value.Dump()
+}
+
+// BigmapReader looks up a big_map value by bigmap id and typed key. It
+// returns false when the key does not exist.
+type BigmapReader func(ctx context.Context, id int64, keyType, key Prim) (Prim, bool, error)
+
+// Env is the execution context of a view.
+type Env struct {
+	Self   Address
+	Now    time.Time
+	Bigmap BigmapReader
+}
+
+// item is a typed stack element. Values are normalized: pairs are binary,
+// addresses are strings, timestamps are ints.
+type item struct {
+	typ Prim
+	val Prim
+}
+
+type machine struct {
+	ctx      context.Context
+	env      Env
+	stack    []item
+	steps    int
+	maxSteps int
+	bigmaps  map[int64]bigmapTypes
+}
+
+type bigmapTypes struct {
+	key, value Prim
+}
+
+// Run executes Michelson code on a stack initialized with input of type
+// typ and returns the single result element. Only a subset of Michelson
+// is supported which covers typical storage views: stack, pair, option,
+// union, list, set, map and big_map instructions, comparison, arithmetic
+// and boolean logic. Cryptographic, ticket, lambda and operation related
+// instructions fail with ErrUnsupported.
+func Run(ctx context.Context, env Env, code, typ, input Prim, maxSteps int) (Prim, Prim, error) {
+	mc := &machine{
+		ctx:      ctx,
+		env:      env,
+		maxSteps: maxSteps,
+		bigmaps:  make(map[int64]bigmapTypes),
+	}
+	t, err := normType(typ)
+	if err != nil {
+		return Prim{}, Prim{}, err
+	}
+	v, err := mc.normValue(t, input)
+	if err != nil {
+		return Prim{}, Prim{}, err
+	}
+	mc.push(t, v)
+	if err := mc.exec(code); err != nil {
+		return Prim{}, Prim{}, err
+	}
+	if len(mc.stack) != 1 {
+		return Prim{}, Prim{}, fmt.Errorf("%w: %d elements left on stack", ErrTypeError, len(mc.stack))
+	}
+	res := mc.stack[0]
+	return res.typ, res.val, nil
+}
+
+func (mc *machine) push(typ, val Prim) {
+	mc.stack = append(mc.stack, item{typ, val})
+}
+
+func (mc *machine) pop() (item, error) {
+	n := len(mc.stack)
+	if n == 0 {
+		return item{}, fmt.Errorf("%w: stack underflow", ErrTypeError)
+	}
+	it := mc.stack[n-1]
+	mc.stack = mc.stack[:n-1]
+	return it, nil
+}
+
+func (mc *machine) popN(n int) ([]item, error) {
+	if len(mc.stack) < n {
+		return nil, fmt.Errorf("%w: stack underflow", ErrTypeError)
+	}
+	res := make([]item, n)
+	copy(res, mc.stack[len(mc.stack)-n:])
+	mc.stack = mc.stack[:len(mc.stack)-n]
+	// top of stack first
+	for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res, nil
+}
+
+func (mc *machine) exec(code Prim) error {
+	if code.Type == m.PrimSequence {
+		for _, p := range code.Args {
+			if err := mc.exec(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	mc.steps++
+	if mc.maxSteps > 0 && mc.steps > mc.maxSteps {
+		return ErrStepLimit
+	}
+	if err := mc.ctx.Err(); err != nil {
+		return err
+	}
+	if err := mc.step(code); err != nil {
+		if _, ok := err.(*FailedError); ok {
+			return err
+		}
+		if errors.Is(err, ErrUnsupported) || errors.Is(err, ErrTypeError) || errors.Is(err, ErrStepLimit) {
+			return err
+		}
+		return fmt.Errorf("%s: %w", code.OpCode, err)
+	}
+	return nil
+}
+
+func intArg(p Prim, def int) int {
+	if len(p.Args) > 0 && p.Args[0].Type == m.PrimInt && p.Args[0].Int != nil {
+		return int(p.Args[0].Int.Int64())
+	}
+	return def
+}
+
+// instrArgs is the number of arguments required by instructions which
+// carry types or code blocks.
+var instrArgs = map[m.OpCode]int{
+	m.I_PUSH:      2,
+	m.I_NONE:      1,
+	m.I_LEFT:      1,
+	m.I_RIGHT:     1,
+	m.I_NIL:       1,
+	m.I_EMPTY_SET: 1,
+	m.I_EMPTY_MAP: 2,
+	m.I_IF:        2,
+	m.I_IF_NONE:   2,
+	m.I_IF_LEFT:   2,
+	m.I_IF_CONS:   2,
+	m.I_LOOP:      1,
+	m.I_LOOP_LEFT: 1,
+	m.I_ITER:      1,
+	m.I_MAP:       1,
+}
+
+// hasArgs reports whether p has at least n arguments.
+func hasArgs(p Prim, n int) bool {
+	return len(p.Args) >= n
+}
+
+// isInt reports whether all items hold integer values.
+func isInt(items ...item) bool {
+	for _, it := range items {
+		if it.val.Type != m.PrimInt || it.val.Int == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// popType pops an item and checks its type against want.
+func (mc *machine) popType(op m.OpCode, want ...m.OpCode) (item, error) {
+	it, err := mc.pop()
+	if err != nil {
+		return it, err
+	}
+	for _, w := range want {
+		if it.typ.OpCode == w {
+			return it, nil
+		}
+	}
+	return it, fmt.Errorf("%w: %s on %s", ErrTypeError, op, it.typ.OpCode)
+}
+
+func (mc *machine) step(p Prim) error {
+	if n, ok := instrArgs[p.OpCode]; ok && len(p.Args) != n {
+		return fmt.Errorf("%w: %s expects %d arguments, got %d", ErrTypeError, p.OpCode, n, len(p.Args))
+	}
+	switch p.OpCode {
+	case m.I_DROP:
+		n := intArg(p, 1)
+		if n < 0 {
+			return fmt.Errorf("%w: DROP %d", ErrTypeError, n)
+		}
+		_, err := mc.popN(n)
+		return err
+
+	case m.I_DUP:
+		n := intArg(p, 1)
+		if n < 1 || n > len(mc.stack) {
+			return fmt.Errorf("%w: DUP %d", ErrTypeError, n)
+		}
+		mc.stack = append(mc.stack, mc.stack[len(mc.stack)-n])
+
+	case m.I_SWAP:
+		n := len(mc.stack)
+		if n < 2 {
+			return fmt.Errorf("%w: stack underflow", ErrTypeError)
+		}
+		mc.stack[n-1], mc.stack[n-2] = mc.stack[n-2], mc.stack[n-1]
+
+	case m.I_DIG:
+		n := intArg(p, 0)
+		l := len(mc.stack)
+		if n < 0 || n >= l {
+			return fmt.Errorf("%w: DIG %d", ErrTypeError, n)
+		}
+		it := mc.stack[l-1-n]
+		copy(mc.stack[l-1-n:], mc.stack[l-n:])
+		mc.stack[l-1] = it
+
+	case m.I_DUG:
+		n := intArg(p, 0)
+		l := len(mc.stack)
+		if n < 0 || n >= l {
+			return fmt.Errorf("%w: DUG %d", ErrTypeError, n)
+		}
+		it := mc.stack[l-1]
+		copy(mc.stack[l-n:], mc.stack[l-1-n:l-1])
+		mc.stack[l-1-n] = it
+
+	case m.I_DIP:
+		n, code := 1, Prim{}
+		switch len(p.Args) {
+		case 1:
+			code = p.Args[0]
+		case 2:
+			n, code = intArg(p, -1), p.Args[1]
+		}
+		if n < 0 || !code.IsValid() {
+			return fmt.Errorf("%w: DIP", ErrTypeError)
+		}
+		saved, err := mc.popN(n)
+		if err != nil {
+			return err
+		}
+		if err := mc.exec(code); err != nil {
+			return err
+		}
+		for i := len(saved) - 1; i >= 0; i-- {
+			mc.stack = append(mc.stack, saved[i])
+		}
+
+	case m.I_PUSH:
+		t, err := normType(p.Args[0])
+		if err != nil {
+			return err
+		}
+		v, err := mc.normValue(t, p.Args[1])
+		if err != nil {
+			return err
+		}
+		mc.push(t, v)
+
+	case m.I_UNIT:
+		mc.push(m.NewCode(m.T_UNIT), m.NewCode(m.D_UNIT))
+
+	case m.I_NONE:
+		t, err := normType(p.Args[0])
+		if err != nil {
+			return err
+		}
+		mc.push(m.NewOptType(t), m.NewCode(m.D_NONE))
+
+	case m.I_SOME:
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		mc.push(m.NewOptType(it.typ), m.NewCode(m.D_SOME, it.val))
+
+	case m.I_LEFT, m.I_RIGHT:
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		other, err := normType(p.Args[0])
+		if err != nil {
+			return err
+		}
+		if p.OpCode == m.I_LEFT {
+			mc.push(m.NewCode(m.T_OR, it.typ, other), m.NewCode(m.D_LEFT, it.val))
+		} else {
+			mc.push(m.NewCode(m.T_OR, other, it.typ), m.NewCode(m.D_RIGHT, it.val))
+		}
+
+	case m.I_NIL, m.I_EMPTY_SET:
+		t, err := normType(p.Args[0])
+		if err != nil {
+			return err
+		}
+		coll := m.T_LIST
+		if p.OpCode == m.I_EMPTY_SET {
+			coll = m.T_SET
+		}
+		mc.push(m.NewCode(coll, t), m.NewSeq())
+
+	case m.I_EMPTY_MAP:
+		kt, err := normType(p.Args[0])
+		if err != nil {
+			return err
+		}
+		vt, err := normType(p.Args[1])
+		if err != nil {
+			return err
+		}
+		mc.push(m.NewCode(m.T_MAP, kt, vt), m.NewSeq())
+
+	case m.I_CONS:
+		args, err := mc.popN(2)
+		if err != nil {
+			return err
+		}
+		list := args[1]
+		if list.typ.OpCode != m.T_LIST {
+			return fmt.Errorf("%w: CONS on %s", ErrTypeError, list.typ.OpCode)
+		}
+		vals := append(m.PrimList{args[0].val}, list.val.Args...)
+		mc.push(list.typ, m.NewSeq(vals...))
+
+	case m.I_PAIR:
+		n := intArg(p, 2)
+		if n < 2 {
+			return fmt.Errorf("%w: PAIR %d", ErrTypeError, n)
+		}
+		args, err := mc.popN(n)
+		if err != nil {
+			return err
+		}
+		t, v := args[n-1].typ, args[n-1].val
+		for i := n - 2; i >= 0; i-- {
+			t = m.NewPairType(args[i].typ, t)
+			v = m.NewPair(args[i].val, v)
+		}
+		mc.push(t, v)
+
+	case m.I_UNPAIR:
+		n := intArg(p, 2)
+		if n < 2 {
+			return fmt.Errorf("%w: UNPAIR %d", ErrTypeError, n)
+		}
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		res := make([]item, 0, n)
+		for i := 0; i < n-1; i++ {
+			if it.typ.OpCode != m.T_PAIR {
+				return fmt.Errorf("%w: UNPAIR on %s", ErrTypeError, it.typ.OpCode)
+			}
+			res = append(res, item{it.typ.Args[0], it.val.Args[0]})
+			it = item{it.typ.Args[1], it.val.Args[1]}
+		}
+		res = append(res, it)
+		for i := len(res) - 1; i >= 0; i-- {
+			mc.stack = append(mc.stack, res[i])
+		}
+
+	case m.I_CAR, m.I_CDR:
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		if it.typ.OpCode != m.T_PAIR {
+			return fmt.Errorf("%w: %s on %s", ErrTypeError, p.OpCode, it.typ.OpCode)
+		}
+		i := 0
+		if p.OpCode == m.I_CDR {
+			i = 1
+		}
+		mc.push(it.typ.Args[i], it.val.Args[i])
+
+	case m.I_GET:
+		if len(p.Args) > 0 {
+			// comb access
+			it, err := mc.pop()
+			if err != nil {
+				return err
+			}
+			n := intArg(p, 0)
+			for ; n > 1; n -= 2 {
+				if it.typ.OpCode != m.T_PAIR {
+					return fmt.Errorf("%w: GET %d", ErrTypeError, intArg(p, 0))
+				}
+				it = item{it.typ.Args[1], it.val.Args[1]}
+			}
+			if n == 1 {
+				if it.typ.OpCode != m.T_PAIR {
+					return fmt.Errorf("%w: GET %d", ErrTypeError, intArg(p, 0))
+				}
+				it = item{it.typ.Args[0], it.val.Args[0]}
+			}
+			mc.push(it.typ, it.val)
+			return nil
+		}
+		args, err := mc.popN(2)
+		if err != nil {
+			return err
+		}
+		key, coll := args[0], args[1]
+		val, ok, err := mc.lookup(coll, key)
+		if err != nil {
+			return err
+		}
+		res := m.NewCode(m.D_NONE)
+		if ok {
+			res = m.NewCode(m.D_SOME, val)
+		}
+		mc.push(m.NewOptType(coll.typ.Args[1]), res)
+
+	case m.I_MEM:
+		args, err := mc.popN(2)
+		if err != nil {
+			return err
+		}
+		key, coll := args[0], args[1]
+		var ok bool
+		if coll.typ.OpCode == m.T_SET {
+			ok = findElem(coll, key) >= 0
+		} else {
+			_, ok, err = mc.lookup(coll, key)
+			if err != nil {
+				return err
+			}
+		}
+		mc.push(boolType(), boolValue(ok))
+
+	case m.I_UPDATE:
+		if len(p.Args) > 0 {
+			return fmt.Errorf("%w: UPDATE n", ErrUnsupported)
+		}
+		args, err := mc.popN(3)
+		if err != nil {
+			return err
+		}
+		key, upd, coll := args[0], args[1], args[2]
+		switch coll.typ.OpCode {
+		case m.T_SET, m.T_MAP, m.T_BIG_MAP:
+		default:
+			return fmt.Errorf("%w: UPDATE on %s", ErrTypeError, coll.typ.OpCode)
+		}
+		if coll.typ.OpCode == m.T_BIG_MAP && coll.val.Type == m.PrimInt {
+			return fmt.Errorf("%w: UPDATE on stored big_map", ErrUnsupported)
+		}
+		mc.push(coll.typ, update(coll, key, upd))
+
+	case m.I_SIZE:
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		var n int
+		switch it.typ.OpCode {
+		case m.T_STRING:
+			n = len(it.val.String)
+		case m.T_BYTES:
+			n = len(it.val.Bytes)
+		case m.T_LIST, m.T_SET, m.T_MAP:
+			n = len(it.val.Args)
+		default:
+			return fmt.Errorf("%w: SIZE on %s", ErrTypeError, it.typ.OpCode)
+		}
+		mc.push(natType(), m.NewInt64(int64(n)))
+
+	case m.I_IF:
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		if it.val.OpCode == m.D_TRUE {
+			return mc.exec(p.Args[0])
+		}
+		return mc.exec(p.Args[1])
+
+	case m.I_IF_NONE:
+		it, err := mc.popType(p.OpCode, m.T_OPTION)
+		if err != nil {
+			return err
+		}
+		if it.val.OpCode == m.D_NONE {
+			return mc.exec(p.Args[0])
+		}
+		mc.push(it.typ.Args[0], it.val.Args[0])
+		return mc.exec(p.Args[1])
+
+	case m.I_IF_LEFT:
+		it, err := mc.popType(p.OpCode, m.T_OR)
+		if err != nil {
+			return err
+		}
+		if it.val.OpCode == m.D_LEFT {
+			mc.push(it.typ.Args[0], it.val.Args[0])
+			return mc.exec(p.Args[0])
+		}
+		mc.push(it.typ.Args[1], it.val.Args[0])
+		return mc.exec(p.Args[1])
+
+	case m.I_IF_CONS:
+		it, err := mc.popType(p.OpCode, m.T_LIST)
+		if err != nil {
+			return err
+		}
+		if len(it.val.Args) == 0 {
+			return mc.exec(p.Args[1])
+		}
+		mc.push(it.typ, m.NewSeq(it.val.Args[1:]...))
+		mc.push(it.typ.Args[0], it.val.Args[0])
+		return mc.exec(p.Args[0])
+
+	case m.I_LOOP:
+		for {
+			it, err := mc.pop()
+			if err != nil {
+				return err
+			}
+			if it.val.OpCode != m.D_TRUE {
+				return nil
+			}
+			if err := mc.exec(p.Args[0]); err != nil {
+				return err
+			}
+		}
+
+	case m.I_LOOP_LEFT:
+		for {
+			it, err := mc.popType(p.OpCode, m.T_OR)
+			if err != nil {
+				return err
+			}
+			if it.val.OpCode != m.D_LEFT {
+				mc.push(it.typ.Args[1], it.val.Args[0])
+				return nil
+			}
+			mc.push(it.typ.Args[0], it.val.Args[0])
+			if err := mc.exec(p.Args[0]); err != nil {
+				return err
+			}
+		}
+
+	case m.I_ITER:
+		it, err := mc.popType(p.OpCode, m.T_LIST, m.T_SET, m.T_MAP)
+		if err != nil {
+			return err
+		}
+		for _, v := range it.val.Args {
+			t, e := elemType(it.typ), v
+			if it.typ.OpCode == m.T_MAP {
+				e = m.NewPair(v.Args[0], v.Args[1])
+			}
+			mc.push(t, e)
+			if err := mc.exec(p.Args[0]); err != nil {
+				return err
+			}
+		}
+
+	case m.I_MAP:
+		it, err := mc.popType(p.OpCode, m.T_LIST, m.T_MAP)
+		if err != nil {
+			return err
+		}
+		out := make(m.PrimList, 0, len(it.val.Args))
+		var rtyp Prim
+		for _, v := range it.val.Args {
+			t, e := elemType(it.typ), v
+			if it.typ.OpCode == m.T_MAP {
+				e = m.NewPair(v.Args[0], v.Args[1])
+			}
+			mc.push(t, e)
+			if err := mc.exec(p.Args[0]); err != nil {
+				return err
+			}
+			r, err := mc.pop()
+			if err != nil {
+				return err
+			}
+			rtyp = r.typ
+			if it.typ.OpCode == m.T_MAP {
+				out = append(out, m.NewMapElem(v.Args[0], r.val))
+			} else {
+				out = append(out, r.val)
+			}
+		}
+		if !rtyp.IsValid() {
+			// empty input, result type stays unknown
+			mc.push(it.typ, m.NewSeq())
+			return nil
+		}
+		if it.typ.OpCode == m.T_MAP {
+			mc.push(m.NewCode(m.T_MAP, it.typ.Args[0], rtyp), m.NewSeq(out...))
+		} else {
+			mc.push(m.NewCode(m.T_LIST, rtyp), m.NewSeq(out...))
+		}
+
+	case m.I_COMPARE:
+		args, err := mc.popN(2)
+		if err != nil {
+			return err
+		}
+		c, err := compare(args[0].typ, args[0].val, args[1].val)
+		if err != nil {
+			return err
+		}
+		mc.push(intType(), m.NewInt64(int64(c)))
+
+	case m.I_EQ, m.I_NEQ, m.I_LT, m.I_GT, m.I_LE, m.I_GE:
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		if !isInt(it) {
+			return fmt.Errorf("%w: %s on %s", ErrTypeError, p.OpCode, it.typ.OpCode)
+		}
+		s := it.val.Int.Sign()
+		var ok bool
+		switch p.OpCode {
+		case m.I_EQ:
+			ok = s == 0
+		case m.I_NEQ:
+			ok = s != 0
+		case m.I_LT:
+			ok = s < 0
+		case m.I_GT:
+			ok = s > 0
+		case m.I_LE:
+			ok = s <= 0
+		case m.I_GE:
+			ok = s >= 0
+		}
+		mc.push(boolType(), boolValue(ok))
+
+	case m.I_ADD, m.I_SUB, m.I_MUL, m.I_SUB_MUMAV:
+		return mc.arith(p.OpCode)
+
+	case m.I_EDIV:
+		args, err := mc.popN(2)
+		if err != nil {
+			return err
+		}
+		a, b := args[0], args[1]
+		if !isInt(a, b) {
+			return fmt.Errorf("%w: EDIV on %s and %s", ErrTypeError, a.typ.OpCode, b.typ.OpCode)
+		}
+		if b.val.Int.Sign() == 0 {
+			mc.push(m.NewOptType(m.NewPairType(natType(), natType())), m.NewCode(m.D_NONE))
+			return nil
+		}
+		q, r := new(big.Int), new(big.Int)
+		q.DivMod(a.val.Int, new(big.Int).Abs(b.val.Int), r)
+		if b.val.Int.Sign() < 0 {
+			q.Neg(q)
+		}
+		qt, rt := intType(), natType()
+		switch {
+		case a.typ.OpCode == m.T_NAT && b.typ.OpCode == m.T_NAT:
+			qt = natType()
+		case a.typ.OpCode == m.T_MUMAV && b.typ.OpCode == m.T_NAT:
+			qt, rt = a.typ, a.typ
+		case a.typ.OpCode == m.T_MUMAV && b.typ.OpCode == m.T_MUMAV:
+			qt, rt = natType(), a.typ
+		}
+		mc.push(m.NewOptType(m.NewPairType(qt, rt)), m.NewCode(m.D_SOME, m.NewPair(m.NewBig(q), m.NewBig(r))))
+
+	case m.I_ABS:
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		if !isInt(it) {
+			return fmt.Errorf("%w: ABS on %s", ErrTypeError, it.typ.OpCode)
+		}
+		mc.push(natType(), m.NewBig(new(big.Int).Abs(it.val.Int)))
+
+	case m.I_NEG:
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		if !isInt(it) {
+			return fmt.Errorf("%w: NEG on %s", ErrTypeError, it.typ.OpCode)
+		}
+		mc.push(intType(), m.NewBig(new(big.Int).Neg(it.val.Int)))
+
+	case m.I_INT:
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		if !isInt(it) {
+			return fmt.Errorf("%w: INT on %s", ErrTypeError, it.typ.OpCode)
+		}
+		mc.push(intType(), it.val)
+
+	case m.I_ISNAT:
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		if !isInt(it) {
+			return fmt.Errorf("%w: ISNAT on %s", ErrTypeError, it.typ.OpCode)
+		}
+		if it.val.Int.Sign() < 0 {
+			mc.push(m.NewOptType(natType()), m.NewCode(m.D_NONE))
+		} else {
+			mc.push(m.NewOptType(natType()), m.NewCode(m.D_SOME, it.val))
+		}
+
+	case m.I_NOT:
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		if it.typ.OpCode == m.T_BOOL {
+			mc.push(it.typ, boolValue(it.val.OpCode != m.D_TRUE))
+		} else if !isInt(it) {
+			return fmt.Errorf("%w: NOT on %s", ErrTypeError, it.typ.OpCode)
+		} else {
+			mc.push(intType(), m.NewBig(new(big.Int).Not(it.val.Int)))
+		}
+
+	case m.I_AND, m.I_OR, m.I_XOR:
+		args, err := mc.popN(2)
+		if err != nil {
+			return err
+		}
+		a, b := args[0], args[1]
+		if a.typ.OpCode == m.T_BOOL {
+			x, y := a.val.OpCode == m.D_TRUE, b.val.OpCode == m.D_TRUE
+			var r bool
+			switch p.OpCode {
+			case m.I_AND:
+				r = x && y
+			case m.I_OR:
+				r = x || y
+			default:
+				r = x != y
+			}
+			mc.push(a.typ, boolValue(r))
+			return nil
+		}
+		if !isInt(a, b) {
+			return fmt.Errorf("%w: %s on %s and %s", ErrTypeError, p.OpCode, a.typ.OpCode, b.typ.OpCode)
+		}
+		r := new(big.Int)
+		switch p.OpCode {
+		case m.I_AND:
+			r.And(a.val.Int, b.val.Int)
+		case m.I_OR:
+			r.Or(a.val.Int, b.val.Int)
+		default:
+			r.Xor(a.val.Int, b.val.Int)
+		}
+		mc.push(b.typ, m.NewBig(r))
+
+	case m.I_CONCAT:
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		var parts []item
+		if it.typ.OpCode == m.T_LIST {
+			for _, v := range it.val.Args {
+				parts = append(parts, item{it.typ.Args[0], v})
+			}
+		} else {
+			other, err := mc.pop()
+			if err != nil {
+				return err
+			}
+			parts = []item{it, other}
+		}
+		if len(parts) == 0 {
+			if it.typ.Args[0].OpCode == m.T_BYTES {
+				mc.push(it.typ.Args[0], m.NewBytes(nil))
+			} else {
+				mc.push(it.typ.Args[0], m.NewString(""))
+			}
+			return nil
+		}
+		if parts[0].typ.OpCode == m.T_BYTES {
+			var buf []byte
+			for _, v := range parts {
+				buf = append(buf, v.val.Bytes...)
+			}
+			mc.push(parts[0].typ, m.NewBytes(buf))
+		} else {
+			var b strings.Builder
+			for _, v := range parts {
+				b.WriteString(v.val.String)
+			}
+			mc.push(parts[0].typ, m.NewString(b.String()))
+		}
+
+	case m.I_FAILWITH:
+		it, err := mc.pop()
+		if err != nil {
+			return err
+		}
+		return &FailedError{Value: it.val}
+
+	case m.I_SELF_ADDRESS:
+		mc.push(m.NewCode(m.T_ADDRESS), m.NewString(mc.env.Self.String()))
+
+	case m.I_NOW:
+		mc.push(m.NewCode(m.T_TIMESTAMP), m.NewInt64(mc.env.Now.Unix()))
+
+	case m.I_AMOUNT:
+		mc.push(m.NewCode(m.T_MUMAV), m.NewInt64(0))
+
+	case m.I_CAST, m.I_RENAME:
+		// no-op on untyped annotations
+
+	default:
+		return fmt.Errorf("%w %s", ErrUnsupported, p.OpCode)
+	}
+	return nil
+}
+
+func (mc *machine) arith(op m.OpCode) error {
+	args, err := mc.popN(2)
+	if err != nil {
+		return err
+	}
+	a, b := args[0], args[1]
+	if !isInt(a, b) {
+		return fmt.Errorf("%w: %s on %s and %s", ErrTypeError, op, a.typ.OpCode, b.typ.OpCode)
+	}
+	r := new(big.Int)
+	switch op {
+	case m.I_ADD:
+		r.Add(a.val.Int, b.val.Int)
+	case m.I_SUB, m.I_SUB_MUMAV:
+		r.Sub(a.val.Int, b.val.Int)
+	case m.I_MUL:
+		r.Mul(a.val.Int, b.val.Int)
+	}
+	ta, tb := a.typ.OpCode, b.typ.OpCode
+	var t Prim
+	switch {
+	case op == m.I_SUB_MUMAV:
+		if r.Sign() < 0 {
+			mc.push(m.NewOptType(a.typ), m.NewCode(m.D_NONE))
+		} else {
+			mc.push(m.NewOptType(a.typ), m.NewCode(m.D_SOME, m.NewBig(r)))
+		}
+		return nil
+	case ta == m.T_TIMESTAMP && tb == m.T_TIMESTAMP:
+		t = intType() // SUB
+	case ta == m.T_TIMESTAMP || tb == m.T_TIMESTAMP:
+		t = m.NewCode(m.T_TIMESTAMP)
+	case ta == m.T_MUMAV || tb == m.T_MUMAV:
+		t = m.NewCode(m.T_MUMAV)
+		if r.Sign() < 0 {
+			return fmt.Errorf("mumav underflow")
+		}
+	case ta == m.T_NAT && tb == m.T_NAT && op != m.I_SUB:
+		t = natType()
+	default:
+		t = intType()
+	}
+	mc.push(t, m.NewBig(r))
+	return nil
+}
+
+// lookup reads key from a map or big_map. Stored big_maps are read through
+// the environment's BigmapReader.
+func (mc *machine) lookup(coll, key item) (Prim, bool, error) {
+	switch coll.typ.OpCode {
+	case m.T_MAP, m.T_BIG_MAP:
+	default:
+		return Prim{}, false, fmt.Errorf("%w: GET on %s", ErrTypeError, coll.typ.OpCode)
+	}
+	if coll.val.Type == m.PrimInt {
+		if mc.env.Bigmap == nil {
+			return Prim{}, false, fmt.Errorf("%w: big_map access without reader", ErrUnsupported)
+		}
+		val, ok, err := mc.env.Bigmap(mc.ctx, coll.val.Int.Int64(), coll.typ.Args[0], key.val)
+		if err != nil || !ok {
+			return Prim{}, false, err
+		}
+		v, err := mc.normValue(coll.typ.Args[1], val)
+		return v, err == nil, err
+	}
+	if i := findElem(coll, key); i >= 0 {
+		return coll.val.Args[i].Args[1], true, nil
+	}
+	return Prim{}, false, nil
+}
+
+// findElem returns the position of key in a set or map or -1.
+func findElem(coll, key item) int {
+	kt := coll.typ.Args[0]
+	for i, v := range coll.val.Args {
+		k := v
+		if v.OpCode == m.D_ELT && len(v.Args) == 2 {
+			k = v.Args[0]
+		}
+		if c, err := compare(kt, k, key.val); err == nil && c == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// update sets or removes key in a set or map and keeps elements sorted.
+func update(coll, key, upd item) Prim {
+	kt := coll.typ.Args[0]
+	out := make(m.PrimList, 0, len(coll.val.Args)+1)
+	var elem Prim
+	switch {
+	case coll.typ.OpCode == m.T_SET && upd.val.OpCode == m.D_TRUE:
+		elem = key.val
+	case coll.typ.OpCode != m.T_SET && upd.val.OpCode == m.D_SOME:
+		elem = m.NewMapElem(key.val, upd.val.Args[0])
+	}
+	inserted := !elem.IsValid()
+	for _, v := range coll.val.Args {
+		k := v
+		if v.OpCode == m.D_ELT && len(v.Args) == 2 {
+			k = v.Args[0]
+		}
+		c, _ := compare(kt, k, key.val)
+		if c == 0 {
+			continue
+		}
+		if c > 0 && !inserted {
+			out = append(out, elem)
+			inserted = true
+		}
+		out = append(out, v)
+	}
+	if !inserted {
+		out = append(out, elem)
+	}
+	return m.NewSeq(out...)
+}
+
+func elemType(t Prim) Prim {
+	if t.OpCode == m.T_MAP {
+		return m.NewPairType(t.Args[0], t.Args[1])
+	}
+	return t.Args[0]
+}
+
+func intType() Prim  { return m.NewCode(m.T_INT) }
+func natType() Prim  { return m.NewCode(m.T_NAT) }
+func boolType() Prim { return m.NewCode(m.T_BOOL) }
+
+func boolValue(b bool) Prim {
+	if b {
+		return m.NewCode(m.D_TRUE)
+	}
+	return m.NewCode(m.D_FALSE)
+}
+
+// typeArgs is the number of arguments of composite types. Pairs take two
+// or more.
+var typeArgs = map[m.OpCode]int{
+	m.T_PAIR:     2,
+	m.T_OPTION:   1,
+	m.T_OR:       2,
+	m.T_LIST:     1,
+	m.T_SET:      1,
+	m.T_MAP:      2,
+	m.T_BIG_MAP:  2,
+	m.T_CONTRACT: 1,
+	m.T_LAMBDA:   2,
+	m.T_TICKET:   1,
+}
+
+// normType strips annotations and converts comb pairs into binary pairs.
+// Composite types with missing arguments are rejected.
+func normType(t Prim) (Prim, error) {
+	if n, ok := typeArgs[t.OpCode]; ok && (len(t.Args) < n || (t.OpCode != m.T_PAIR && len(t.Args) > n)) {
+		return t, fmt.Errorf("%w: invalid type %s", ErrTypeError, t.Dump())
+	}
+	t = t.CloneNoAnnots()
+	args := make(m.PrimList, len(t.Args))
+	for i, a := range t.Args {
+		if t.OpCode == m.K_VIEW || a.Type == m.PrimInt {
+			args[i] = a
+			continue
+		}
+		var err error
+		if args[i], err = normType(a); err != nil {
+			return t, err
+		}
+	}
+	t.Args = args
+	if t.OpCode == m.T_PAIR && len(t.Args) > 2 {
+		r, err := normType(m.NewCode(m.T_PAIR, t.Args[1:]...))
+		if err != nil {
+			return t, err
+		}
+		return m.NewPairType(t.Args[0], r), nil
+	}
+	return t, nil
+}
+
+// normValue converts v into the canonical form used by the machine.
+func (mc *machine) normValue(t, v Prim) (Prim, error) {
+	switch t.OpCode {
+	case m.T_PAIR:
+		var args m.PrimList
+		switch {
+		case v.OpCode == m.D_PAIR:
+			args = v.Args
+		case v.Type == m.PrimSequence:
+			args = v.Args
+		default:
+			return v, fmt.Errorf("%w: expected pair, got %s", ErrTypeError, v.Dump())
+		}
+		if len(args) < 2 {
+			return v, fmt.Errorf("%w: short pair", ErrTypeError)
+		}
+		l, err := mc.normValue(t.Args[0], args[0])
+		if err != nil {
+			return v, err
+		}
+		rv := args[1]
+		if len(args) > 2 {
+			rv = m.NewCode(m.D_PAIR, args[1:]...)
+		}
+		r, err := mc.normValue(t.Args[1], rv)
+		if err != nil {
+			return v, err
+		}
+		return m.NewPair(l, r), nil
+	case m.T_OPTION:
+		if v.OpCode == m.D_SOME {
+			if !hasArgs(v, 1) {
+				return v, fmt.Errorf("%w: short Some", ErrTypeError)
+			}
+			x, err := mc.normValue(t.Args[0], v.Args[0])
+			return m.NewCode(m.D_SOME, x), err
+		}
+		if v.OpCode != m.D_NONE {
+			return v, fmt.Errorf("%w: expected option, got %s", ErrTypeError, v.Dump())
+		}
+		return v, nil
+	case m.T_OR:
+		if (v.OpCode != m.D_LEFT && v.OpCode != m.D_RIGHT) || !hasArgs(v, 1) {
+			return v, fmt.Errorf("%w: expected or, got %s", ErrTypeError, v.Dump())
+		}
+		i := 0
+		if v.OpCode == m.D_RIGHT {
+			i = 1
+		}
+		x, err := mc.normValue(t.Args[i], v.Args[0])
+		return m.NewCode(v.OpCode, x), err
+	case m.T_LIST, m.T_SET:
+		if v.Type != m.PrimSequence {
+			return v, fmt.Errorf("%w: expected sequence, got %s", ErrTypeError, v.Dump())
+		}
+		out := make(m.PrimList, len(v.Args))
+		for i, e := range v.Args {
+			x, err := mc.normValue(t.Args[0], e)
+			if err != nil {
+				return v, err
+			}
+			out[i] = x
+		}
+		return m.NewSeq(out...), nil
+	case m.T_MAP, m.T_BIG_MAP:
+		if v.Type == m.PrimInt {
+			// stored big_map id
+			return v, nil
+		}
+		if v.Type != m.PrimSequence {
+			return v, fmt.Errorf("%w: expected sequence, got %s", ErrTypeError, v.Dump())
+		}
+		out := make(m.PrimList, len(v.Args))
+		for i, e := range v.Args {
+			if e.OpCode != m.D_ELT || len(e.Args) != 2 {
+				return v, fmt.Errorf("%w: expected map element, got %s", ErrTypeError, e.Dump())
+			}
+			k, err := mc.normValue(t.Args[0], e.Args[0])
+			if err != nil {
+				return v, err
+			}
+			x, err := mc.normValue(t.Args[1], e.Args[1])
+			if err != nil {
+				return v, err
+			}
+			out[i] = m.NewMapElem(k, x)
+		}
+		return m.NewSeq(out...), nil
+	case m.T_ADDRESS, m.T_KEY_HASH, m.T_CONTRACT:
+		if v.Type == m.PrimBytes {
+			var a mavryk.Address
+			if err := a.Decode(v.Bytes); err != nil {
+				return v, err
+			}
+			return m.NewString(a.String()), nil
+		}
+		return v, nil
+	case m.T_TIMESTAMP:
+		if v.Type == m.PrimString {
+			if n, err := strconv.ParseInt(v.String, 10, 64); err == nil {
+				return m.NewInt64(n), nil
+			}
+			tm, err := time.Parse(time.RFC3339, v.String)
+			if err != nil {
+				return v, err
+			}
+			return m.NewInt64(tm.Unix()), nil
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+// compare orders values of comparable type t.
+func compare(t, a, b Prim) (int, error) {
+	switch t.OpCode {
+	case m.T_INT, m.T_NAT, m.T_MUMAV, m.T_TIMESTAMP:
+		if a.Int == nil || b.Int == nil {
+			return 0, fmt.Errorf("%w: compare %s", ErrTypeError, t.OpCode)
+		}
+		return a.Int.Cmp(b.Int), nil
+	case m.T_STRING:
+		return strings.Compare(a.String, b.String), nil
+	case m.T_BYTES, m.T_CHAIN_ID, m.T_KEY, m.T_SIGNATURE:
+		if a.Type == m.PrimString || b.Type == m.PrimString {
+			return strings.Compare(a.String, b.String), nil
+		}
+		return bytes.Compare(a.Bytes, b.Bytes), nil
+	case m.T_BOOL:
+		return boolRank(a) - boolRank(b), nil
+	case m.T_UNIT:
+		return 0, nil
+	case m.T_ADDRESS, m.T_KEY_HASH:
+		x, err := mavryk.ParseAddress(strings.Split(a.String, "%")[0])
+		if err != nil {
+			return 0, err
+		}
+		y, err := mavryk.ParseAddress(strings.Split(b.String, "%")[0])
+		if err != nil {
+			return 0, err
+		}
+		if c := bytes.Compare(x.EncodePadded(), y.EncodePadded()); c != 0 {
+			return c, nil
+		}
+		return strings.Compare(a.String, b.String), nil
+	case m.T_PAIR:
+		if !hasArgs(a, 2) || !hasArgs(b, 2) {
+			return 0, fmt.Errorf("%w: compare %s", ErrTypeError, t.OpCode)
+		}
+		c, err := compare(t.Args[0], a.Args[0], b.Args[0])
+		if err != nil || c != 0 {
+			return c, err
+		}
+		return compare(t.Args[1], a.Args[1], b.Args[1])
+	case m.T_OPTION:
+		switch {
+		case a.OpCode == m.D_NONE && b.OpCode == m.D_NONE:
+			return 0, nil
+		case a.OpCode == m.D_NONE:
+			return -1, nil
+		case b.OpCode == m.D_NONE:
+			return 1, nil
+		case !hasArgs(a, 1) || !hasArgs(b, 1):
+			return 0, fmt.Errorf("%w: compare %s", ErrTypeError, t.OpCode)
+		}
+		return compare(t.Args[0], a.Args[0], b.Args[0])
+	case m.T_OR:
+		switch {
+		case !hasArgs(a, 1) || !hasArgs(b, 1):
+			return 0, fmt.Errorf("%w: compare %s", ErrTypeError, t.OpCode)
+		case a.OpCode == m.D_LEFT && b.OpCode == m.D_RIGHT:
+			return -1, nil
+		case a.OpCode == m.D_RIGHT && b.OpCode == m.D_LEFT:
+			return 1, nil
+		case a.OpCode == m.D_LEFT:
+			return compare(t.Args[0], a.Args[0], b.Args[0])
+		default:
+			return compare(t.Args[1], a.Args[0], b.Args[0])
+		}
+	default:
+		return 0, fmt.Errorf("%w: %s is not comparable", ErrTypeError, t.OpCode)
+	}
+}
+
+func boolRank(p Prim) int {
+	if p.OpCode == m.D_TRUE {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,380 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package tz16
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/mavryk-network/mvgo/mavryk"
+	m "github.com/mavryk-network/mvgo/micheline"
+	"github.com/mavryk-network/mvpro-go/internal/client"
+	"github.com/mavryk-network/mvpro-go/internal/util"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+var (
+	ErrNoMetadata     = errors.New("no tzip-16 metadata")
+	ErrUnsupportedUri = errors.New("unsupported metadata uri")
+	ErrHashMismatch   = errors.New("metadata does not match sha256 hash")
+	ErrTooManyHops    = errors.New("too many metadata uri hops")
+)
+
+// Metadata is a resolved TZIP-16 document. Uris lists every uri followed
+// from the root of the metadata bigmap to the document. Verified is set
+// when at least one sha256 hash was checked along the way.
+type Metadata struct {
+	Address  Address         `json:"address"`
+	Uris     []string        `json:"uris"`
+	Verified bool            `json:"verified"`
+	Tz16     *Tz16           `json:"tz16"`
+	Raw      json.RawMessage `json:"-"`
+}
+
+// Uri returns the last uri followed, i.e. the location of the document.
+func (md Metadata) Uri() string {
+	if len(md.Uris) == 0 {
+		return ""
+	}
+	return md.Uris[len(md.Uris)-1]
+}
+
+// View returns the off-chain view called name.
+func (md Metadata) View(name string) (Tz16View, bool) {
+	if md.Tz16 != nil {
+		for _, v := range md.Tz16.Views {
+			if v.Name == name {
+				return v, true
+			}
+		}
+	}
+	return Tz16View{}, false
+}
+
+// Resolver resolves TZIP-16 contract metadata. It reads the %metadata
+// bigmap through the contract API and follows tezos-storage (and
+// mavryk-storage), ipfs, http(s) and sha256 uris. IPFS content is loaded
+// through the IPFS API, http content with a plain http client. A Resolver
+// is safe for concurrent use.
+type Resolver struct {
+	contracts ContractAPI
+	ipfs      IpfsAPI
+	http      *http.Client
+	maxHops   int
+	maxSize   int64
+	maxSteps  int
+	mu        sync.Mutex
+	scripts   map[string]*index.ContractScript
+}
+
+func NewResolver(contracts ContractAPI, ipfs IpfsAPI) *Resolver {
+	return &Resolver{
+		contracts: contracts,
+		ipfs:      ipfs,
+		http:      http.DefaultClient,
+		maxHops:   4,
+		maxSize:   1 << 20,
+		maxSteps:  10000,
+		scripts:   make(map[string]*index.ContractScript),
+	}
+}
+
+// WithHttpClient sets the client used for http(s) uris. Nil disables http.
+func (r *Resolver) WithHttpClient(c *http.Client) *Resolver {
+	r.http = c
+	return r
+}
+
+// WithMaxHops sets how many uris may be followed for a single document.
+func (r *Resolver) WithMaxHops(n int) *Resolver {
+	r.maxHops = n
+	return r
+}
+
+// WithMaxSize limits the size of fetched documents.
+func (r *Resolver) WithMaxSize(n int64) *Resolver {
+	r.maxSize = n
+	return r
+}
+
+// WithMaxSteps limits the number of instructions a view may execute.
+func (r *Resolver) WithMaxSteps(n int) *Resolver {
+	r.maxSteps = n
+	return r
+}
+
+// Resolve loads the TZIP-16 metadata of contract addr starting at the
+// empty key of its %metadata bigmap.
+func (r *Resolver) Resolve(ctx context.Context, addr Address) (*Metadata, error) {
+	script, err := r.script(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := bigmapIds(script)["metadata"]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has no metadata bigmap", ErrNoMetadata, addr)
+	}
+	val, err := r.storageValue(ctx, id, "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", addr, err)
+	}
+	md := &Metadata{Address: addr}
+	if err := r.follow(ctx, md, addr, string(val), 0); err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// ResolveUri loads the TZIP-16 document at uri. Relative tezos-storage
+// uris are looked up in contract addr.
+func (r *Resolver) ResolveUri(ctx context.Context, addr Address, uri string) (*Metadata, error) {
+	md := &Metadata{Address: addr}
+	if err := r.follow(ctx, md, addr, uri, 0); err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+func (r *Resolver) follow(ctx context.Context, md *Metadata, addr Address, uri string, hops int) error {
+	if hops >= r.maxHops {
+		return fmt.Errorf("%w at %q", ErrTooManyHops, uri)
+	}
+	md.Uris = append(md.Uris, uri)
+	buf, next, err := r.fetch(ctx, md, addr, uri)
+	if err != nil {
+		return err
+	}
+	if next != "" {
+		return r.follow(ctx, md, addr, next, hops+1)
+	}
+	return md.decode(buf)
+}
+
+// fetch loads the content at uri. Storage values that are not a JSON
+// document are returned as next uri to follow.
+func (r *Resolver) fetch(ctx context.Context, md *Metadata, addr Address, uri string) ([]byte, string, error) {
+	scheme, rest, _ := strings.Cut(uri, ":")
+	switch strings.ToLower(scheme) {
+	case "tezos-storage", "mavryk-storage":
+		host, key, err := parseStorageUri(rest)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w %q: %v", ErrUnsupportedUri, uri, err)
+		}
+		if host.IsValid() {
+			addr = host
+		}
+		script, err := r.script(ctx, addr)
+		if err != nil {
+			return nil, "", err
+		}
+		id, ok := bigmapIds(script)["metadata"]
+		if !ok {
+			return nil, "", fmt.Errorf("%w: %s has no metadata bigmap", ErrNoMetadata, addr)
+		}
+		val, err := r.storageValue(ctx, id, key)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s %q: %w", addr, key, err)
+		}
+		if isJSON(val) {
+			return val, "", nil
+		}
+		return nil, string(val), nil
+
+	case "ipfs":
+		if r.ipfs == nil {
+			return nil, "", fmt.Errorf("%w %q: no ipfs api", ErrUnsupportedUri, uri)
+		}
+		buf := util.NewLimitedBuffer(r.maxSize)
+		if err := r.ipfs.GetImage(ctx, uri, "application/json", buf); err != nil {
+			return nil, "", err
+		}
+		if buf.Overflow() {
+			return nil, "", fmt.Errorf("%q: document larger than %d bytes", uri, r.maxSize)
+		}
+		return buf.Bytes(), "", nil
+
+	case "http", "https":
+		buf, err := r.get(ctx, uri)
+		return buf, "", err
+
+	case "sha256":
+		sum, target, err := parseSha256Uri(rest)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w %q: %v", ErrUnsupportedUri, uri, err)
+		}
+		md.Uris = append(md.Uris, target)
+		buf, next, err := r.fetch(ctx, md, addr, target)
+		if err != nil {
+			return nil, "", err
+		}
+		if next != "" {
+			return nil, "", fmt.Errorf("%w %q: hash must refer to a document", ErrUnsupportedUri, uri)
+		}
+		if h := sha256.Sum256(buf); !bytes.Equal(h[:], sum) {
+			return nil, "", fmt.Errorf("%w %s", ErrHashMismatch, target)
+		}
+		md.Verified = true
+		return buf, "", nil
+
+	default:
+		return nil, "", fmt.Errorf("%w %q", ErrUnsupportedUri, uri)
+	}
+}
+
+func (r *Resolver) get(ctx context.Context, uri string) ([]byte, error) {
+	if r.http == nil {
+		return nil, fmt.Errorf("%w %q: http disabled", ErrUnsupportedUri, uri)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", uri, resp.Status)
+	}
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, r.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > r.maxSize {
+		return nil, fmt.Errorf("%q: document larger than %d bytes", uri, r.maxSize)
+	}
+	return buf, nil
+}
+
+func (md *Metadata) decode(buf []byte) error {
+	tz := new(Tz16)
+	if err := json.Unmarshal(buf, tz); err != nil {
+		return fmt.Errorf("%s: invalid tzip-16 document at %q: %w", md.Address, md.Uri(), err)
+	}
+	md.Tz16 = tz
+	md.Raw = buf
+	return nil
+}
+
+// script loads and caches contract scripts including bigmap ids.
+func (r *Resolver) script(ctx context.Context, addr Address) (*index.ContractScript, error) {
+	key := addr.String()
+	r.mu.Lock()
+	s, ok := r.scripts[key]
+	r.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+	s, err := r.contracts.GetScript(ctx, addr, index.NewQuery().WithPrim())
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.scripts[key] = s
+	r.mu.Unlock()
+	return s, nil
+}
+
+func bigmapIds(s *index.ContractScript) map[string]int64 {
+	if len(s.BigmapNames) > 0 || s.Script == nil {
+		return s.BigmapNames
+	}
+	return s.Script.Bigmaps()
+}
+
+// storageValue reads a bytes value from a string keyed bigmap.
+func (r *Resolver) storageValue(ctx context.Context, id int64, key string) ([]byte, error) {
+	k, err := m.NewKey(m.NewType(m.NewCode(m.T_STRING)), m.NewString(key))
+	if err != nil {
+		return nil, err
+	}
+	val, ok, err := r.bigmapValue(ctx, id, k.Hash().String())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: key not found in bigmap %d", ErrNoMetadata, id)
+	}
+	if val.Type != m.PrimBytes {
+		return nil, fmt.Errorf("%w: unexpected value type in bigmap %d", ErrNoMetadata, id)
+	}
+	return val.Bytes, nil
+}
+
+func (r *Resolver) bigmapValue(ctx context.Context, id int64, hash string) (Prim, bool, error) {
+	v, err := r.contracts.GetBigmapValue(ctx, id, hash, index.NewQuery().WithPrim())
+	if err != nil {
+		if isNotFound(err) {
+			return Prim{}, false, nil
+		}
+		return Prim{}, false, err
+	}
+	if v.ValuePrim == nil {
+		return Prim{}, false, nil
+	}
+	return *v.ValuePrim, true, nil
+}
+
+func isNotFound(err error) bool {
+	if e, ok := client.IsErrHttp(err); ok {
+		return e.StatusCode() == http.StatusNotFound
+	}
+	return false
+}
+
+// parseStorageUri splits tezos-storage uris like //KT1.../key or key into
+// an optional contract address and an unescaped key. A chain id suffix on
+// the host part is ignored.
+func parseStorageUri(s string) (Address, string, error) {
+	if !strings.HasPrefix(s, "//") {
+		key, err := url.PathUnescape(s)
+		return Address{}, key, err
+	}
+	host, path, ok := strings.Cut(s[2:], "/")
+	if !ok {
+		return Address{}, "", fmt.Errorf("missing key")
+	}
+	host, _, _ = strings.Cut(host, ".")
+	addr, err := mavryk.ParseAddress(host)
+	if err != nil {
+		return Address{}, "", err
+	}
+	key, err := url.PathUnescape(path)
+	return addr, key, err
+}
+
+// parseSha256Uri decodes sha256://0x<hash>/<escaped uri>.
+func parseSha256Uri(s string) ([]byte, string, error) {
+	s = strings.TrimPrefix(s, "//")
+	hash, target, ok := strings.Cut(s, "/")
+	if !ok {
+		return nil, "", fmt.Errorf("missing target uri")
+	}
+	sum, err := hex.DecodeString(strings.TrimPrefix(hash, "0x"))
+	if err != nil || len(sum) != sha256.Size {
+		return nil, "", fmt.Errorf("invalid hash %q", hash)
+	}
+	target, err = url.PathUnescape(target)
+	if err != nil {
+		return nil, "", err
+	}
+	return sum, target, nil
+}
+
+func isJSON(buf []byte) bool {
+	buf = bytes.TrimSpace(buf)
+	return len(buf) > 1 && buf[0] == '{' && buf[len(buf)-1] == '}'
+}
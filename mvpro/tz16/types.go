@@ -0,0 +1,23 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package tz16
+
+import (
+	"github.com/mavryk-network/mvgo/contract"
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvgo/micheline"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+	"github.com/mavryk-network/mvpro-go/mvpro/ipfs"
+)
+
+type (
+	Address         = mavryk.Address
+	Prim            = micheline.Prim
+	Tz16            = contract.Tz16
+	Tz16View        = contract.Tz16View
+	Tz16ViewImpl    = contract.Tz16ViewImpl
+	Tz16StorageView = contract.Tz16StorageView
+	ContractAPI     = index.ContractAPI
+	IpfsAPI         = ipfs.IpfsAPI
+)
@@ -0,0 +1,96 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package tz16
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	m "github.com/mavryk-network/mvgo/micheline"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+var (
+	ErrNoView        = errors.New("no such view")
+	ErrNoStorageView = errors.New("view has no michelsonStorageView implementation")
+)
+
+// ViewResult is the outcome of an off-chain view evaluation.
+type ViewResult struct {
+	Name  string `json:"name"`
+	Type  Prim   `json:"type"`
+	Value Prim   `json:"value"`
+}
+
+// RunView evaluates the michelsonStorageView implementation of view name
+// locally against the current storage of md's contract. Views without a
+// parameter ignore arg. Big_map reads are served by the contract API, all
+// other data comes from storage. NOW is the local wall clock time.
+func (r *Resolver) RunView(ctx context.Context, md *Metadata, name string, arg Prim) (*ViewResult, error) {
+	view, ok := md.View(name)
+	if !ok {
+		return nil, fmt.Errorf("%w %q in %s", ErrNoView, name, md.Address)
+	}
+	var impl *Tz16StorageView
+	for _, v := range view.Implementations {
+		if v.Storage != nil {
+			impl = v.Storage
+			break
+		}
+	}
+	if impl == nil {
+		return nil, fmt.Errorf("%w: %q", ErrNoStorageView, name)
+	}
+
+	script, err := r.script(ctx, md.Address)
+	if err != nil {
+		return nil, err
+	}
+	if script.Script == nil {
+		return nil, fmt.Errorf("%s: missing script", md.Address)
+	}
+	store, err := r.contracts.GetStorage(ctx, md.Address, index.NewQuery().WithPrim())
+	if err != nil {
+		return nil, err
+	}
+	storage, ok := store.AsPrim()
+	if !ok {
+		return nil, fmt.Errorf("%s: missing storage", md.Address)
+	}
+
+	typ, input := script.Script.StorageType().Prim, storage
+	if impl.ParamType.IsValid() {
+		typ = m.NewPairType(impl.ParamType, typ)
+		input = m.NewPair(arg, storage)
+	}
+	env := Env{
+		Self:   md.Address,
+		Now:    time.Now().UTC(),
+		Bigmap: r.readBigmap,
+	}
+	rtyp, val, err := Run(ctx, env, impl.Code, typ, input, r.maxSteps)
+	if err != nil {
+		return nil, fmt.Errorf("view %q: %w", name, err)
+	}
+	if impl.ReturnType.IsValid() {
+		if rtyp, err = normType(impl.ReturnType); err != nil {
+			return nil, fmt.Errorf("view %q: %w", name, err)
+		}
+	}
+	return &ViewResult{
+		Name:  name,
+		Type:  rtyp,
+		Value: val,
+	}, nil
+}
+
+func (r *Resolver) readBigmap(ctx context.Context, id int64, keyType, key Prim) (Prim, bool, error) {
+	k, err := m.NewKey(m.NewType(keyType), key)
+	if err != nil {
+		return Prim{}, false, err
+	}
+	return r.bigmapValue(ctx, id, k.Hash().String())
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package watchlist
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// bloom is a fixed size bloom filter for addresses. Address hashes are
+// uniformly distributed already, so bit positions are derived directly from
+// hash bytes using double hashing.
+type bloom struct {
+	bits []uint64
+	n    int // sized capacity
+	m    uint64
+	k    uint64
+}
+
+// newBloom sizes a filter for n entries at false positive rate p.
+func newBloom(n int, p float64) *bloom {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloom{
+		bits: make([]uint64, (m+63)/64),
+		n:    n,
+		m:    m,
+		k:    k,
+	}
+}
+
+func (b *bloom) hashes(a Address) (uint64, uint64) {
+	h1 := binary.LittleEndian.Uint64(a[1:9]) ^ uint64(a[0])
+	h2 := binary.LittleEndian.Uint64(a[9:17]) | 1
+	return h1, h2
+}
+
+func (b *bloom) add(a Address) {
+	h1, h2 := b.hashes(a)
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		b.bits[pos>>6] |= 1 << (pos & 63)
+	}
+}
+
+func (b *bloom) contains(a Address) bool {
+	h1, h2 := b.hashes(a)
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		if b.bits[pos>>6]&(1<<(pos&63)) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package watchlist
+
+import (
+	"strings"
+
+	"github.com/mavryk-network/mvgo/mavryk"
+	"github.com/mavryk-network/mvpro-go/mvpro/index"
+)
+
+type Role string
+
+const (
+	RoleSender       Role = "sender"
+	RoleReceiver     Role = "receiver"
+	RoleCreator      Role = "creator"
+	RoleBaker        Role = "baker"
+	RolePrevBaker    Role = "previous_baker"
+	RoleSource       Role = "source"
+	RoleOffender     Role = "offender"
+	RoleAccuser      Role = "accuser"
+	RoleParam        Role = "param"
+	RoleTransferFrom Role = "transfer_from"
+	RoleTransferTo   Role = "transfer_to"
+	RoleEvent        Role = "event"
+	RoleTicket       Role = "ticket"
+)
+
+// Match reports a watched address found in an operation. Op is the matching
+// batch or internal operation, Path is set for matches inside parameters
+// and event payloads.
+type Match struct {
+	Address Address   `json:"address"`
+	Label   string    `json:"label,omitempty"`
+	Role    Role      `json:"role"`
+	Path    string    `json:"path,omitempty"`
+	Op      *index.Op `json:"-"`
+}
+
+// Matcher finds watched addresses in operations. Parameter, transfer and
+// event matching decode data and are therefore optional.
+type Matcher struct {
+	list      *Watchlist
+	reg       *index.CallRegistry
+	params    bool
+	transfers bool
+	events    bool
+}
+
+func (w *Watchlist) NewMatcher() *Matcher {
+	return &Matcher{
+		list:      w,
+		reg:       index.DefaultCallRegistry,
+		params:    true,
+		transfers: true,
+		events:    true,
+	}
+}
+
+func (m *Matcher) WithParams(b bool) *Matcher {
+	m.params = b
+	return m
+}
+
+func (m *Matcher) WithTransfers(b bool) *Matcher {
+	m.transfers = b
+	return m
+}
+
+func (m *Matcher) WithEvents(b bool) *Matcher {
+	m.events = b
+	return m
+}
+
+// WithRegistry sets the call registry used to decode token transfers.
+func (m *Matcher) WithRegistry(r *index.CallRegistry) *Matcher {
+	m.reg = r
+	return m
+}
+
+// Match returns all matches in o including batch and internal operations.
+func (m *Matcher) Match(o *index.Op) []Match {
+	var res []Match
+	for _, op := range o.Content() {
+		res = m.matchOp(res, op)
+	}
+	return res
+}
+
+// MatchList returns all matches in ops.
+func (m *Matcher) MatchList(ops index.OpList) []Match {
+	var res []Match
+	for _, o := range ops {
+		res = append(res, m.Match(o)...)
+	}
+	return res
+}
+
+func (m *Matcher) matchOp(res []Match, op *index.Op) []Match {
+	for _, v := range []struct {
+		addr Address
+		role Role
+	}{
+		{op.Sender, RoleSender},
+		{op.Receiver, RoleReceiver},
+		{op.Creator, RoleCreator},
+		{op.Baker, RoleBaker},
+		{op.PrevBaker, RolePrevBaker},
+		{op.Source, RoleSource},
+		{op.Offender, RoleOffender},
+		{op.Accuser, RoleAccuser},
+	} {
+		res = m.add(res, op, v.addr, v.role, "")
+	}
+	for _, t := range op.TicketUpdates {
+		res = m.add(res, op, t.Account, RoleTicket, "")
+	}
+	if m.params && op.HasParameters() {
+		if p, err := op.DecodeParams(false, 0); err == nil {
+			res = m.walk(res, op, p.ContractValue, RoleParam)
+		}
+	}
+	if m.transfers && m.reg != nil && op.Entrypoint != "" {
+		if v, err := m.reg.DecodeCall(op); err == nil {
+			res = m.matchTransfers(res, op, v)
+		}
+	}
+	if m.events {
+		for _, ev := range op.Events {
+			v, err := ev.Decode()
+			if err != nil {
+				continue
+			}
+			res = m.walk(res, op, *v, RoleEvent)
+		}
+	}
+	return res
+}
+
+func (m *Matcher) matchTransfers(res []Match, op *index.Op, call any) []Match {
+	switch v := call.(type) {
+	case *index.FA12Transfer:
+		res = m.add(res, op, v.From, RoleTransferFrom, "")
+		res = m.add(res, op, v.To, RoleTransferTo, "")
+	case *index.FA2Transfer:
+		for _, b := range *v {
+			res = m.add(res, op, b.From, RoleTransferFrom, "")
+			for _, tx := range b.Txs {
+				res = m.add(res, op, tx.To, RoleTransferTo, "")
+			}
+		}
+	}
+	return res
+}
+
+// walk scans all string leaves of a decoded value for addresses.
+func (m *Matcher) walk(res []Match, op *index.Op, v index.ContractValue, role Role) []Match {
+	_ = v.Walk("", func(path string, val any) error {
+		s, ok := val.(string)
+		if !ok {
+			return nil
+		}
+		if addr, ok := parseAddress(s); ok {
+			res = m.add(res, op, addr, role, path)
+		}
+		return nil
+	})
+	return res
+}
+
+func (m *Matcher) add(res []Match, op *index.Op, addr Address, role Role, path string) []Match {
+	label, ok := m.list.Lookup(addr)
+	if !ok {
+		return res
+	}
+	return append(res, Match{
+		Address: addr,
+		Label:   label,
+		Role:    role,
+		Path:    path,
+		Op:      op,
+	})
+}
+
+// parseAddress cheaply rejects strings that cannot be addresses before
+// parsing. Contract addresses may carry an entrypoint suffix.
+func parseAddress(s string) (Address, bool) {
+	if i := strings.IndexByte(s, '%'); i > 0 {
+		s = s[:i]
+	}
+	if len(s) != 36 {
+		return Address{}, false
+	}
+	a, err := mavryk.ParseAddress(s)
+	return a, err == nil
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package watchlist
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/echa/log"
+	"github.com/mavryk-network/mvgo/mavryk"
+)
+
+type Address = mavryk.Address
+
+// Watchlist is a concurrency safe set of watched addresses with optional
+// labels. An optional bloom filter rejects most non-members before the
+// hash set is consulted which helps when the set is large.
+type Watchlist struct {
+	mu     sync.RWMutex
+	m      map[Address]string
+	bloom  *bloom
+	fpRate float64
+	log    log.Logger
+}
+
+func New() *Watchlist {
+	return &Watchlist{
+		m:   make(map[Address]string),
+		log: log.Disabled,
+	}
+}
+
+// WithBloom enables a bloom prefilter with false positive rate p. The
+// filter is rebuilt on every Replace and whenever Add exceeds its sized
+// capacity.
+func (w *Watchlist) WithBloom(p float64) *Watchlist {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fpRate = p
+	w.rebuild()
+	return w
+}
+
+func (w *Watchlist) WithLogger(l log.Logger) *Watchlist {
+	w.log = l
+	return w
+}
+
+func (w *Watchlist) rebuild() {
+	if w.fpRate <= 0 {
+		w.bloom = nil
+		return
+	}
+	// leave room for some growth before the error rate degrades
+	w.bloom = newBloom(len(w.m)*2, w.fpRate)
+	for a := range w.m {
+		w.bloom.add(a)
+	}
+}
+
+// Add inserts addr with an optional label.
+func (w *Watchlist) Add(addr Address, label string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.m[addr] = label
+	switch {
+	case w.bloom == nil:
+	case len(w.m) > w.bloom.n:
+		w.rebuild()
+	default:
+		w.bloom.add(addr)
+	}
+}
+
+// Remove deletes addr. The bloom filter is not updated, so removed addresses
+// cost one extra map lookup until the next Replace.
+func (w *Watchlist) Remove(addr Address) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.m, addr)
+}
+
+// Replace atomically swaps the entire set.
+func (w *Watchlist) Replace(m map[Address]string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.m = m
+	w.rebuild()
+}
+
+func (w *Watchlist) Len() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return len(w.m)
+}
+
+func (w *Watchlist) Contains(addr Address) bool {
+	_, ok := w.Lookup(addr)
+	return ok
+}
+
+// Lookup returns the label of addr and whether addr is watched.
+func (w *Watchlist) Lookup(addr Address) (string, bool) {
+	if !addr.IsValid() {
+		return "", false
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.bloom != nil && !w.bloom.contains(addr) {
+		return "", false
+	}
+	label, ok := w.m[addr]
+	return label, ok
+}
+
+// Parse reads one address per line with an optional label separated by
+// comma, tab or whitespace. Empty lines and lines starting with # are
+// ignored.
+func Parse(r io.Reader) (map[Address]string, error) {
+	m := make(map[Address]string)
+	scan := bufio.NewScanner(r)
+	var n int
+	for scan.Scan() {
+		n++
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var label string
+		if i := strings.IndexAny(line, ",\t "); i > 0 {
+			line, label = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		addr, err := mavryk.ParseAddress(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		m[addr] = label
+	}
+	return m, scan.Err()
+}
+
+// Load replaces the watchlist with addresses read from r.
+func (w *Watchlist) Load(r io.Reader) error {
+	m, err := Parse(r)
+	if err != nil {
+		return err
+	}
+	w.Replace(m)
+	return nil
+}
+
+// LoadFile replaces the watchlist with addresses from file name.
+func (w *Watchlist) LoadFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := w.Load(f); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// Watch loads file name and reloads it whenever its modification time or
+// size changes until ctx is canceled. Reload errors are logged and keep the
+// previous set active. Interval must be positive.
+func (w *Watchlist) Watch(ctx context.Context, name string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("watchlist: invalid interval %s", interval)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if err := w.LoadFile(name); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		mod, size := fi.ModTime(), fi.Size()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			fi, err := os.Stat(name)
+			if err != nil {
+				w.log.Errorf("watchlist: %v", err)
+				continue
+			}
+			if fi.ModTime().Equal(mod) && fi.Size() == size {
+				continue
+			}
+			mod, size = fi.ModTime(), fi.Size()
+			if err := w.LoadFile(name); err != nil {
+				w.log.Errorf("watchlist: reload: %v", err)
+				continue
+			}
+			w.log.Infof("watchlist: reloaded %d addresses from %s", w.Len(), name)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2024 Blockwatch Data Inc.
+// Author: alex@blockwatch.cc
+
+package zmq
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Topic returns the message topic.
+func (m *Message) Topic() string {
+	return m.topic
+}
+
+// DecodeOpType decodes only the type column of a raw_op message which is
+// cheaper than a full decode when most messages are filtered out.
+func (m *Message) DecodeOpType() (OpType, error) {
+	var row []json.RawMessage
+	if err := json.Unmarshal(m.body, &row); err != nil {
+		return OpTypeInvalid, err
+	}
+	if opTypeColumn < 0 || opTypeColumn >= len(row) {
+		return OpTypeInvalid, fmt.Errorf("decode: missing type field")
+	}
+	var t OpType
+	if err := json.Unmarshal(row[opTypeColumn], &t); err != nil {
+		return OpTypeInvalid, err
+	}
+	return t, nil
+}
+
+var opTypeColumn = func() int {
+	for i, v := range ZmqRawOpColumns {
+		if v == "type" {
+			return i
+		}
+	}
+	return -1
+}()
+
+// MatchOpTypes reports whether m is a raw_op message with a type in set.
+func (m *Message) MatchOpTypes(set OpTypeSet) (bool, error) {
+	switch m.topic {
+	case "raw_op", "raw_op/rollback":
+	default:
+		return false, nil
+	}
+	t, err := m.DecodeOpType()
+	if err != nil {
+		return false, err
+	}
+	return set.Contains(t), nil
+}
+
+// DecodeOpIn decodes m when it is a raw_op message with a type in set and
+// returns nil otherwise.
+func (m *Message) DecodeOpIn(set OpTypeSet) (*Op, error) {
+	ok, err := m.MatchOpTypes(set)
+	if !ok || err != nil {
+		return nil, err
+	}
+	return m.DecodeOp()
+}
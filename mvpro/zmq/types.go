@@ -14,9 +14,13 @@ type (
 	Op        = index.Op
 	Block     = index.Block
 	Status    = index.Status
+	OpType    = index.OpType
+	OpTypeSet = index.OpTypeSet
 )
 
 var (
 	ParseOpHash    = mavryk.ParseOpHash
 	ParseBlockHash = mavryk.ParseBlockHash
 )
+
+const OpTypeInvalid = index.OpTypeInvalid